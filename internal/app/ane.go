@@ -0,0 +1,51 @@
+package app
+
+import (
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+const (
+	// ANEMethodResidency derives ANE usage from IOReport performance state residency
+	ANEMethodResidency = "residency"
+	// ANEMethodPowerTable estimates ANE usage from power draw against the chip's ANE ceiling
+	ANEMethodPowerTable = "power_table"
+)
+
+// defaultANEMaxPower is the ceiling used for chips without an ane_max_watts entry
+const defaultANEMaxPower = 8.0
+
+// aneMaxPower returns the ANE power ceiling for the chip from the first chip
+// table entry that matches the name and carries an ANE ceiling
+func aneMaxPower(chipName string) float64 {
+	for _, spec := range loadChipTable() {
+		if spec.ANEMaxWatts > 0 && spec.Match == chipModel(chipName) {
+			return spec.ANEMaxWatts
+		}
+	}
+	return defaultANEMaxPower
+}
+
+// aneUtilization returns the ANE usage percentage and the method it was
+// derived with. Residency is used when IOReport publishes ANE state channels,
+// otherwise power is scaled against the per-chip ceiling.
+func aneUtilization(cpuMetrics CPUMetrics, chipName string) (float64, string) {
+	if cpuMetrics.ANEResidency {
+		return clampPercent(cpuMetrics.ANEActive), ANEMethodResidency
+	}
+	return clampPercent(cpuMetrics.ANEW / aneMaxPower(chipName) * 100), ANEMethodPowerTable
+}
+
+func clampPercent(v float64) float64 {
+	if v < 0 {
+		return 0
+	}
+	if v > 100 {
+		return 100
+	}
+	return v
+}
+
+func updateANEPrometheus(util float64, method string) {
+	aneUsage.Reset()
+	aneUsage.With(prometheus.Labels{"method": method}).Set(util)
+}
@@ -0,0 +1,227 @@
+package app
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	// historyRetention is how far back /api/v1/history reaches
+	historyRetention = 10 * time.Minute
+	// maxHistorySamples bounds the history at short update intervals
+	maxHistorySamples = 3000
+	maxProcessLimit   = 500
+)
+
+type historyEntry struct {
+	Time time.Time
+	Data []byte
+}
+
+// sampleRing is a fixed-size circular buffer of samples, oldest first
+type sampleRing struct {
+	entries []historyEntry
+	start   int
+	size    int
+}
+
+func newSampleRing(capacity int) *sampleRing {
+	return &sampleRing{entries: make([]historyEntry, capacity)}
+}
+
+func (r *sampleRing) at(i int) historyEntry {
+	return r.entries[(r.start+i)%len(r.entries)]
+}
+
+// push appends an entry, overwriting the oldest when full
+func (r *sampleRing) push(e historyEntry) {
+	if r.size < len(r.entries) {
+		r.entries[(r.start+r.size)%len(r.entries)] = e
+		r.size++
+		return
+	}
+	r.entries[r.start] = e
+	r.start = (r.start + 1) % len(r.entries)
+}
+
+// prune drops entries older than cutoff
+func (r *sampleRing) prune(cutoff time.Time) {
+	for r.size > 0 && r.at(0).Time.Before(cutoff) {
+		r.entries[r.start] = historyEntry{}
+		r.start = (r.start + 1) % len(r.entries)
+		r.size--
+	}
+}
+
+// since returns the entries taken after t, oldest first
+func (r *sampleRing) since(t time.Time) []historyEntry {
+	var out []historyEntry
+	for i := 0; i < r.size; i++ {
+		if e := r.at(i); e.Time.After(t) {
+			out = append(out, e)
+		}
+	}
+	return out
+}
+
+// publishProcesses keeps a copy of the list, the TUI sorts its own in place
+func (h *sampleHub) publishProcesses(processes []ProcessMetrics) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	h.processes = append(h.processes[:0:0], processes...)
+}
+
+// parseSince accepts a duration back from now ("5m"), an RFC 3339 time or
+// Unix seconds. An empty value means the whole history.
+func parseSince(value string, now time.Time) (time.Time, error) {
+	if value == "" {
+		return time.Time{}, nil
+	}
+	if d, err := time.ParseDuration(value); err == nil {
+		return now.Add(-d), nil
+	}
+	if t, err := time.Parse(time.RFC3339, value); err == nil {
+		return t, nil
+	}
+	if secs, err := strconv.ParseFloat(value, 64); err == nil {
+		return time.UnixMilli(int64(secs * 1000)), nil
+	}
+	return time.Time{}, fmt.Errorf("invalid since %q, want a duration, RFC 3339 time or Unix seconds", value)
+}
+
+// selectFields reduces a sample to the requested fields, which may name
+// nested values with dots (soc_metrics.total_power). The timestamp is always
+// kept so samples can be placed in time.
+func selectFields(data []byte, fields []string) (map[string]json.RawMessage, error) {
+	out := make(map[string]json.RawMessage, len(fields)+1)
+	for _, field := range append([]string{"timestamp"}, fields...) {
+		value := json.RawMessage(data)
+		for _, key := range strings.Split(field, ".") {
+			var obj map[string]json.RawMessage
+			if err := json.Unmarshal(value, &obj); err != nil {
+				return nil, fmt.Errorf("unknown field %q", field)
+			}
+			var ok bool
+			if value, ok = obj[key]; !ok {
+				return nil, fmt.Errorf("unknown field %q", field)
+			}
+		}
+		out[field] = value
+	}
+	return out, nil
+}
+
+func writeJSON(w http.ResponseWriter, v any) {
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Cache-Control", "no-store")
+	if err := json.NewEncoder(w).Encode(v); err != nil {
+		stderrLogger.Printf("Error writing response: %v\n", err)
+	}
+}
+
+// serveHistory returns the samples since ?since=, optionally reduced to
+// ?fields=a,b.c
+func (h *sampleHub) serveHistory(w http.ResponseWriter, r *http.Request) {
+	query := r.URL.Query()
+	since, err := parseSince(query.Get("since"), time.Now())
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	var fields []string
+	if value := query.Get("fields"); value != "" {
+		fields = strings.Split(value, ",")
+	}
+
+	h.mu.Lock()
+	entries := h.history.since(since)
+	h.mu.Unlock()
+
+	samples := make([]any, 0, len(entries))
+	for _, e := range entries {
+		if fields == nil {
+			samples = append(samples, json.RawMessage(e.Data))
+			continue
+		}
+		selected, err := selectFields(e.Data, fields)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		samples = append(samples, selected)
+	}
+	writeJSON(w, samples)
+}
+
+// APIProcess is a process as returned by /api/v1/processes
+type APIProcess struct {
+	PID        int     `json:"pid"`
+	User       string  `json:"user"`
+	Command    string  `json:"command"`
+	State      string  `json:"state"`
+	CPUPercent float64 `json:"cpu_percent"`
+	MemPercent float64 `json:"mem_percent"`
+	VirtKB     int64   `json:"virt_kb"`
+	ResKB      int64   `json:"res_kb"`
+	CPUTime    string  `json:"cpu_time"`
+}
+
+// processColumn maps the ?sort= values to process list columns
+func processColumn(sortKey string) (string, bool) {
+	if sortKey == "" {
+		return "CPU", true
+	}
+	for _, col := range columns {
+		if strings.EqualFold(col, sortKey) {
+			return col, true
+		}
+	}
+	return "", false
+}
+
+// serveProcesses returns the process list sorted by ?sort= (a process list
+// column, cpu by default) and cut to ?limit=
+func (h *sampleHub) serveProcesses(w http.ResponseWriter, r *http.Request) {
+	query := r.URL.Query()
+	column, ok := processColumn(query.Get("sort"))
+	if !ok {
+		http.Error(w, fmt.Sprintf("invalid sort %q, want one of %s", query.Get("sort"), strings.ToLower(strings.Join(columns, ", "))), http.StatusBadRequest)
+		return
+	}
+	limit := maxProcessLimit
+	if value := query.Get("limit"); value != "" {
+		n, err := strconv.Atoi(value)
+		if err != nil || n < 0 {
+			http.Error(w, fmt.Sprintf("invalid limit %q", value), http.StatusBadRequest)
+			return
+		}
+		limit = min(n, maxProcessLimit)
+	}
+	reverse, _ := strconv.ParseBool(query.Get("reverse"))
+
+	h.mu.Lock()
+	processes := append([]ProcessMetrics(nil), h.processes...)
+	h.mu.Unlock()
+
+	sortProcesses(processes, column, reverse)
+	processes = processes[:min(limit, len(processes))]
+	out := make([]APIProcess, len(processes))
+	for i, p := range processes {
+		out[i] = APIProcess{
+			PID:        p.PID,
+			User:       p.User,
+			Command:    p.Command,
+			State:      p.State,
+			CPUPercent: p.CPU,
+			MemPercent: p.Memory,
+			VirtKB:     p.VSZ,
+			ResKB:      p.RSS,
+			CPUTime:    p.Time,
+		}
+	}
+	writeJSON(w, out)
+}
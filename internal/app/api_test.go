@@ -0,0 +1,175 @@
+package app
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestSampleRing(t *testing.T) {
+	start := time.Date(2024, 3, 1, 9, 0, 0, 0, time.UTC)
+	ring := newSampleRing(3)
+	for i := 0; i < 5; i++ {
+		ring.push(historyEntry{Time: start.Add(time.Duration(i) * time.Second), Data: []byte{byte('0' + i)}})
+	}
+	data := func(entries []historyEntry) string {
+		var s string
+		for _, e := range entries {
+			s += string(e.Data)
+		}
+		return s
+	}
+
+	if got := data(ring.since(time.Time{})); got != "234" {
+		t.Errorf("after overflow = %q, want the newest three", got)
+	}
+	if got := data(ring.since(start.Add(3 * time.Second))); got != "4" {
+		t.Errorf("since 3s = %q, want 4", got)
+	}
+	ring.prune(start.Add(4 * time.Second))
+	if got := data(ring.since(time.Time{})); got != "4" {
+		t.Errorf("after prune = %q, want 4", got)
+	}
+	ring.push(historyEntry{Time: start.Add(5 * time.Second), Data: []byte("5")})
+	if got := data(ring.since(time.Time{})); got != "45" {
+		t.Errorf("after push = %q, want 45", got)
+	}
+}
+
+func TestParseSince(t *testing.T) {
+	now := time.Date(2024, 3, 1, 9, 0, 0, 0, time.UTC)
+	tests := []struct {
+		value   string
+		want    time.Time
+		wantErr bool
+	}{
+		{"", time.Time{}, false},
+		{"5m", now.Add(-5 * time.Minute), false},
+		{"2024-03-01T08:58:30Z", now.Add(-90 * time.Second), false},
+		{"1709283540", now.Add(-time.Minute), false},
+		{"yesterday", time.Time{}, true},
+	}
+	for _, tt := range tests {
+		got, err := parseSince(tt.value, now)
+		if (err != nil) != tt.wantErr {
+			t.Errorf("parseSince(%q) error = %v, wantErr %v", tt.value, err, tt.wantErr)
+			continue
+		}
+		if !got.Equal(tt.want) {
+			t.Errorf("parseSince(%q) = %v, want %v", tt.value, got, tt.want)
+		}
+	}
+}
+
+func TestHistoryAPI(t *testing.T) {
+	hub := newSampleHub()
+	source := &syntheticSampleSource{}
+	now := time.Now()
+	// The first sample is past the retention by the time the second arrives
+	hub.publishAt(source.Sample().Sample, now.Add(-historyRetention-5*time.Minute))
+	for i := 3; i > 0; i-- {
+		hub.publishAt(source.Sample().Sample, now.Add(-time.Duration(i)*time.Minute))
+	}
+	srv := httptest.NewServer(dashboardHandler(hub))
+	defer srv.Close()
+
+	get := func(path string, v any) int {
+		t.Helper()
+		resp, err := http.Get(srv.URL + path)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer resp.Body.Close()
+		if resp.StatusCode == http.StatusOK {
+			if err := json.NewDecoder(resp.Body).Decode(v); err != nil {
+				t.Fatal(err)
+			}
+		}
+		return resp.StatusCode
+	}
+
+	var all []HeadlessOutput
+	if code := get("/api/v1/history", &all); code != http.StatusOK || len(all) != 3 || all[0].CPUUsage != 2 {
+		t.Errorf("history = %d, %d samples", code, len(all))
+	}
+
+	var recent []map[string]any
+	if code := get("/api/v1/history?since=150s&fields=cpu_usage,soc_metrics.total_power", &recent); code != http.StatusOK {
+		t.Fatalf("history with fields = %d", code)
+	}
+	if len(recent) != 2 {
+		t.Fatalf("got %d samples since 150s, want 2", len(recent))
+	}
+	want := map[string]any{"timestamp": recent[0]["timestamp"], "cpu_usage": 3.0, "soc_metrics.total_power": 6.0}
+	if !reflect.DeepEqual(recent[0], want) {
+		t.Errorf("selected fields = %v, want %v", recent[0], want)
+	}
+
+	var ignored any
+	if code := get("/api/v1/history?fields=soc_metrics.nope", &ignored); code != http.StatusBadRequest {
+		t.Errorf("unknown field = %d, want 400", code)
+	}
+	if code := get("/api/v1/history?since=soon", &ignored); code != http.StatusBadRequest {
+		t.Errorf("bad since = %d, want 400", code)
+	}
+
+	var current HeadlessOutput
+	if code := get("/api/v1/current", &current); code != http.StatusOK || current.CPUUsage != 4 {
+		t.Errorf("current = %d %+v", code, current)
+	}
+}
+
+func TestProcessesAPI(t *testing.T) {
+	hub := newSampleHub()
+	published := []ProcessMetrics{
+		{PID: 10, User: "root", Command: "kernel_task", CPU: 12, Memory: 0.5},
+		{PID: 200, User: "dev", Command: "Xcode", CPU: 85, Memory: 9},
+		{PID: 3000, User: "dev", Command: "bash", CPU: 0.1, Memory: 0.1},
+	}
+	hub.publishProcesses(published)
+	sortProcesses(published, "PID", true)
+	srv := httptest.NewServer(dashboardHandler(hub))
+	defer srv.Close()
+
+	tests := []struct {
+		query string
+		code  int
+		pids  []int
+	}{
+		{"", http.StatusOK, []int{200, 10, 3000}},
+		{"?sort=cpu&limit=2", http.StatusOK, []int{200, 10}},
+		{"?sort=mem&reverse=true", http.StatusOK, []int{3000, 10, 200}},
+		{"?sort=cmd", http.StatusOK, []int{3000, 10, 200}},
+		{"?sort=pid&limit=0", http.StatusOK, []int{}},
+		{"?sort=power", http.StatusBadRequest, nil},
+		{"?limit=-1", http.StatusBadRequest, nil},
+	}
+	for _, tt := range tests {
+		resp, err := http.Get(srv.URL + "/api/v1/processes" + tt.query)
+		if err != nil {
+			t.Fatal(err)
+		}
+		var processes []APIProcess
+		if resp.StatusCode == http.StatusOK {
+			json.NewDecoder(resp.Body).Decode(&processes)
+		}
+		resp.Body.Close()
+		if resp.StatusCode != tt.code {
+			t.Errorf("%s: status %d, want %d", tt.query, resp.StatusCode, tt.code)
+			continue
+		}
+		if tt.pids == nil {
+			continue
+		}
+		pids := []int{}
+		for _, p := range processes {
+			pids = append(pids, p.PID)
+		}
+		if !reflect.DeepEqual(pids, tt.pids) {
+			t.Errorf("%s: pids %v, want %v", tt.query, pids, tt.pids)
+		}
+	}
+}
@@ -1,8 +1,12 @@
 package app
 
 import (
+	"image"
 	"testing"
 	"time"
+
+	ui "github.com/gizak/termui/v3"
+	"github.com/shirou/gopsutil/v4/net"
 )
 
 func TestFormatBytes(t *testing.T) {
@@ -155,3 +159,171 @@ func TestEventThrottler(t *testing.T) {
 		// OK
 	}
 }
+
+func TestDeviceFilter(t *testing.T) {
+	tests := []struct {
+		name   string
+		filter DeviceFilter
+		device string
+		want   bool
+	}{
+		{"Empty filter allows all", DeviceFilter{}, "en0", true},
+		{"Exclude exact", DeviceFilter{Exclude: []string{"lo0"}}, "lo0", false},
+		{"Exclude glob", DeviceFilter{Exclude: []string{"utun*"}}, "utun3", false},
+		{"Exclude glob miss", DeviceFilter{Exclude: []string{"utun*"}}, "en0", true},
+		{"Include only", DeviceFilter{Include: []string{"en*"}}, "awdl0", false},
+		{"Include match", DeviceFilter{Include: []string{"en*"}}, "en1", true},
+		{"Exclude wins", DeviceFilter{Include: []string{"en*"}, Exclude: []string{"en5"}}, "en5", false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := tt.filter.Allows(tt.device); got != tt.want {
+				t.Errorf("Allows(%q) = %v, want %v", tt.device, got, tt.want)
+			}
+		})
+	}
+
+	if got := parseDeviceList(" lo0, utun*,,"); len(got) != 2 || got[0] != "lo0" || got[1] != "utun*" {
+		t.Errorf("parseDeviceList() = %v", got)
+	}
+}
+
+func TestComputeInterfaceRates(t *testing.T) {
+	last := map[string]net.IOCountersStat{
+		"en0":   {Name: "en0", BytesRecv: 1000, BytesSent: 500},
+		"utun0": {Name: "utun0", BytesRecv: 100, BytesSent: 100},
+		"en1":   {Name: "en1", BytesRecv: 5000},
+	}
+	current := []net.IOCountersStat{
+		{Name: "utun0", BytesRecv: 300, BytesSent: 200},
+		{Name: "en0", BytesRecv: 3000, BytesSent: 1500},
+		{Name: "en1", BytesRecv: 10}, // counter reset
+		{Name: "en2", BytesRecv: 42}, // new interface
+	}
+	got := computeInterfaceRates(last, current, 2, DeviceFilter{Exclude: []string{"utun*"}})
+
+	if len(got) != 3 {
+		t.Fatalf("expected 3 interfaces, got %d: %+v", len(got), got)
+	}
+	if got[0].Name != "en0" || got[0].InBytesPerSec != 1000 || got[0].OutBytesPerSec != 500 {
+		t.Errorf("unexpected en0 rates: %+v", got[0])
+	}
+	if got[1].Name != "en1" || got[1].InBytesPerSec != 0 {
+		t.Errorf("counter reset should report 0, got %+v", got[1])
+	}
+	if got[2].Name != "en2" || got[2].InBytesPerSec != 0 {
+		t.Errorf("new interface should report 0, got %+v", got[2])
+	}
+}
+
+func TestMetricHistory(t *testing.T) {
+	h := newMetricHistory(4)
+	if peak, avg := h.Stats(); peak != 0 || avg != 0 {
+		t.Errorf("empty history stats = %v, %v", peak, avg)
+	}
+
+	h.Push(2)
+	h.Push(6)
+	if peak, avg := h.Stats(); peak != 6 || avg != 4 {
+		t.Errorf("Stats() = %v, %v, want 6, 4", peak, avg)
+	}
+
+	for _, v := range []float64{1, 1, 1, 1} {
+		h.Push(v)
+	}
+	if peak, avg := h.Stats(); peak != 1 || avg != 1 {
+		t.Errorf("old samples should scroll out, got %v, %v", peak, avg)
+	}
+	if h.values[len(h.values)-1] != 1 {
+		t.Errorf("newest sample should be last")
+	}
+}
+
+func TestTempChartBands(t *testing.T) {
+	chart := NewTempChartWidget(8)
+	for i := 0; i < 8; i++ {
+		state := 0
+		if i >= 6 {
+			state = 3
+		}
+		chart.Push(50+float64(i), 40, state)
+	}
+	chart.SetRect(0, 0, 20, 6)
+	buf := ui.NewBuffer(chart.GetRect())
+	chart.Draw(buf)
+
+	// The newest two samples share the right-most cell and are Critical
+	if bg := buf.GetCell(image.Pt(chart.Inner.Max.X-1, chart.Inner.Min.Y)).Style.Bg; bg != thermalBandColors[2] {
+		t.Errorf("expected critical band at the right border, got bg %v", bg)
+	}
+	if bg := buf.GetCell(image.Pt(chart.Inner.Max.X-2, chart.Inner.Min.Y)).Style.Bg; bg != ui.ColorClear {
+		t.Errorf("nominal samples should not be shaded, got bg %v", bg)
+	}
+
+	if low, high := tempRange([]float64{0, 60, 61}); high-low < 10 || low > 60 || high < 61 {
+		t.Errorf("tempRange() = %v, %v", low, high)
+	}
+}
+
+func TestCoreHeatmapRows(t *testing.T) {
+	info := SystemInfo{Name: "Apple M3 Ultra", PCoreCount: 24, ECoreCount: 8, IsUltra: true}
+	groups := coreHeatmapGroups(GetCoreTopology(info), 2)
+
+	wantLabels := []string{"E0", "P0", "E1", "P1"}
+	if len(groups) != len(wantLabels) {
+		t.Fatalf("expected %d groups, got %+v", len(wantLabels), groups)
+	}
+	for i, g := range groups {
+		if g.Label != wantLabels[i] {
+			t.Errorf("group %d label = %s, want %s", i, g.Label, wantLabels[i])
+		}
+	}
+	if groups[1].Indices[0] != 4 || len(groups[1].Indices) != 12 {
+		t.Errorf("unexpected P0 group: %v", groups[1].Indices)
+	}
+
+	if rows := heatmapRows(groups, 40); len(rows) != 32 {
+		t.Errorf("expected one row per core, got %d rows", len(rows))
+	}
+
+	rows := heatmapRows(groups, 12)
+	if len(rows) > 12 {
+		t.Fatalf("rows overflow the available height: %d", len(rows))
+	}
+	covered := 0
+	for _, row := range rows {
+		covered += len(row.Indices)
+	}
+	if covered != 32 {
+		t.Errorf("buckets should cover all 32 cores, covered %d", covered)
+	}
+	if rows[0].Label != "E0 0-2" {
+		t.Errorf("first bucket label = %q", rows[0].Label)
+	}
+}
+
+func TestANEUtilization(t *testing.T) {
+	tests := []struct {
+		name       string
+		metrics    CPUMetrics
+		chip       string
+		wantUtil   float64
+		wantMethod string
+	}{
+		{"Residency preferred", CPUMetrics{ANEW: 4, ANEActive: 25, ANEResidency: true}, "Apple M1", 25, ANEMethodResidency},
+		{"M1 power table", CPUMetrics{ANEW: 4}, "Apple M1", 50, ANEMethodPowerTable},
+		{"Ultra beats base entry", CPUMetrics{ANEW: 4}, "Apple M1 Ultra", 25, ANEMethodPowerTable},
+		{"Unknown chip uses default", CPUMetrics{ANEW: 2}, "Apple M9", 25, ANEMethodPowerTable},
+		{"Clamped", CPUMetrics{ANEW: 50}, "Apple M2", 100, ANEMethodPowerTable},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			util, method := aneUtilization(tt.metrics, tt.chip)
+			if util != tt.wantUtil || method != tt.wantMethod {
+				t.Errorf("aneUtilization() = %v, %s, want %v, %s", util, method, tt.wantUtil, tt.wantMethod)
+			}
+		})
+	}
+}
@@ -0,0 +1,122 @@
+package app
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"text/tabwriter"
+)
+
+// notAvailable is shown in place of readings whose source didn't initialise
+const notAvailable = "n/a"
+
+// SocCapabilities records which metric sources initialised, so missing data
+// can be reported as unavailable rather than as zero
+type SocCapabilities struct {
+	EnergyModel   bool   `json:"energy_model"`
+	GPUStats      bool   `json:"gpu_stats"`
+	SMCTempKeys   int    `json:"smc_temp_keys"`
+	HIDSensors    int    `json:"hid_sensors"`
+	SystemPower   bool   `json:"system_power"`
+	IOReportError string `json:"ioreport_error,omitempty"`
+}
+
+// Temperature reports whether any temperature source is available
+func (c SocCapabilities) Temperature() bool {
+	return c.SMCTempKeys > 0 || c.HIDSensors > 0
+}
+
+// Power reports whether any power reading is available
+func (c SocCapabilities) Power() bool {
+	return c.EnergyModel || c.SystemPower
+}
+
+// initMetricSources brings up IOReport, SMC and HID and records which of them
+// are usable. A failed IOReport subscription is not fatal.
+func initMetricSources() {
+	err := initSocMetrics()
+	capabilities = getSocCapabilities()
+	if err != nil {
+		capabilities.IOReportError = err.Error()
+		stderrLogger.Printf("power metrics unavailable: %v\n", err)
+	}
+}
+
+func optional[T any](value T, ok bool) *T {
+	if !ok {
+		return nil
+	}
+	return &value
+}
+
+func wattsText(watts float64, ok bool) string {
+	if !ok {
+		return notAvailable
+	}
+	return fmt.Sprintf("%.2f W", watts)
+}
+
+// tempText formats a temperature, or n/a when no sensor produced a reading
+func tempText(celsius float64) string {
+	if !capabilities.Temperature() || celsius <= 0 {
+		return notAvailable
+	}
+	return formatTemp(celsius)
+}
+
+type doctorCheck struct {
+	Source string
+	OK     bool
+	Detail string
+}
+
+func doctorChecks(caps SocCapabilities) []doctorCheck {
+	energyDetail := "IOReport subscription active"
+	if caps.IOReportError != "" {
+		energyDetail = caps.IOReportError
+	} else if !caps.EnergyModel {
+		energyDetail = "Energy Model channels not published"
+	}
+	gpuDetail := "GPU residency and frequency"
+	if !caps.GPUStats {
+		gpuDetail = "GPU Stats channels not published"
+	}
+	pstrDetail := "SMC key PSTR"
+	if !caps.SystemPower {
+		pstrDetail = "SMC key PSTR not present"
+	}
+	return []doctorCheck{
+		{"Energy Model", caps.EnergyModel, energyDetail},
+		{"GPU Stats", caps.GPUStats, gpuDetail},
+		{"SMC temperature keys", caps.SMCTempKeys > 0, fmt.Sprintf("%d keys", caps.SMCTempKeys)},
+		{"HID sensors", caps.HIDSensors > 0, fmt.Sprintf("%d sensors", caps.HIDSensors)},
+		{"PSTR system power", caps.SystemPower, pstrDetail},
+	}
+}
+
+func writeDoctorReport(out io.Writer, caps SocCapabilities) {
+	tw := tabwriter.NewWriter(out, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(tw, "SOURCE\tSTATUS\tDETAIL")
+	for _, check := range doctorChecks(caps) {
+		status := "ok"
+		if !check.OK {
+			status = "missing"
+		}
+		fmt.Fprintf(tw, "%s\t%s\t%s\n", check.Source, status, check.Detail)
+	}
+	tw.Flush()
+}
+
+// runDoctor prints which metric sources work on this machine
+func runDoctor() {
+	initMetricSources()
+	defer cleanupSocMetrics()
+
+	sysInfo := getSOCInfo()
+	fmt.Printf("%s (%dP/%dE, %d GPU cores)\n", sysInfo.Name, sysInfo.PCoreCount, sysInfo.ECoreCount, sysInfo.GPUCoreCount)
+	fmt.Printf("Core topology: %s\n\n", GetCoreTopology(sysInfo).Description)
+	writeDoctorReport(os.Stdout, capabilities)
+	if !getGPUPerformanceStats().Available {
+		fmt.Println("\nAGX PerformanceStatistics not available, GPU memory figures will be missing")
+	}
+}
@@ -0,0 +1,87 @@
+package app
+
+import (
+	"bytes"
+	"encoding/json"
+	"strings"
+	"testing"
+)
+
+func TestHeadlessSocMetricsNulls(t *testing.T) {
+	m := SocMetrics{CPUPower: 1.5, GPUPower: 2, SystemPower: 3, TotalPower: 6.5, GPUFreqMHz: 1398, CPUTemp: 45, GPUTemp: 0}
+
+	tests := []struct {
+		name     string
+		caps     SocCapabilities
+		nulls    []string
+		nonNulls []string
+	}{
+		{
+			"All sources",
+			SocCapabilities{EnergyModel: true, GPUStats: true, SMCTempKeys: 4, SystemPower: true},
+			[]string{"gpu_temp", "soc_temp"},
+			[]string{"cpu_power", "system_power", "total_power", "gpu_freq_mhz", "cpu_temp"},
+		},
+		{
+			"SMC only",
+			SocCapabilities{SMCTempKeys: 4, SystemPower: true},
+			[]string{"cpu_power", "gpu_power", "ane_power", "gpu_freq_mhz"},
+			[]string{"system_power", "total_power", "cpu_temp"},
+		},
+		{
+			"Nothing",
+			SocCapabilities{},
+			[]string{"cpu_power", "system_power", "total_power", "gpu_freq_mhz", "cpu_temp", "gpu_temp"},
+			nil,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			data, err := json.Marshal(headlessSocMetrics(m, tt.caps))
+			if err != nil {
+				t.Fatal(err)
+			}
+			var fields map[string]any
+			if err := json.Unmarshal(data, &fields); err != nil {
+				t.Fatal(err)
+			}
+			for _, key := range tt.nulls {
+				if v, ok := fields[key]; !ok || v != nil {
+					t.Errorf("%s = %v, want explicit null", key, v)
+				}
+			}
+			for _, key := range tt.nonNulls {
+				if fields[key] == nil {
+					t.Errorf("%s is null, want a value", key)
+				}
+			}
+		})
+	}
+}
+
+func TestWriteDoctorReport(t *testing.T) {
+	var buf bytes.Buffer
+	writeDoctorReport(&buf, SocCapabilities{
+		SMCTempKeys:   12,
+		SystemPower:   true,
+		IOReportError: "IOReport subscription failed (code -1)",
+	})
+	out := buf.String()
+
+	for _, want := range []string{
+		"SOURCE",
+		"Energy Model",
+		"missing",
+		"IOReport subscription failed (code -1)",
+		"12 keys",
+		"0 sensors",
+	} {
+		if !strings.Contains(out, want) {
+			t.Errorf("report missing %q:\n%s", want, out)
+		}
+	}
+	if lines := strings.Count(out, "\n"); lines != 6 {
+		t.Errorf("report has %d lines, want header and 5 sources:\n%s", lines, out)
+	}
+}
@@ -0,0 +1,114 @@
+package app
+
+import (
+	"fmt"
+	"math"
+
+	w "github.com/gizak/termui/v3/widgets"
+)
+
+// metricHistory is a fixed-size scrolling window of samples backing a chart.
+// The newest sample is always the last element of values.
+type metricHistory struct {
+	values []float64
+	filled int
+}
+
+func newMetricHistory(size int) *metricHistory {
+	if size < 1 {
+		size = 1
+	}
+	return &metricHistory{values: make([]float64, size)}
+}
+
+func (h *metricHistory) Push(v float64) {
+	copy(h.values, h.values[1:])
+	h.values[len(h.values)-1] = v
+	if h.filled < len(h.values) {
+		h.filled++
+	}
+}
+
+func (h *metricHistory) Reset() {
+	clear(h.values)
+	h.filled = 0
+}
+
+// Stats returns the peak and average of the samples pushed so far, ignoring
+// the zero padding of a window that has not filled up yet.
+func (h *metricHistory) Stats() (peak, avg float64) {
+	if h.filled == 0 {
+		return 0, 0
+	}
+	var sum float64
+	for _, v := range h.values[len(h.values)-h.filled:] {
+		sum += v
+		if v > peak {
+			peak = v
+		}
+	}
+	return peak, sum / float64(h.filled)
+}
+
+func newHistorySparkline(h *metricHistory) *w.Sparkline {
+	sl := w.NewSparkline()
+	sl.Data = h.values
+	return sl
+}
+
+// scaleSparklines gives all sparklines of a group the same scale so the
+// directions can be compared by eye.
+func scaleSparklines(peak float64, sparklines ...*w.Sparkline) {
+	if peak <= 0 {
+		peak = 1
+	}
+	for _, sl := range sparklines {
+		sl.MaxVal = peak
+	}
+}
+
+func setupNetDiskCharts(numPoints int) {
+	netOutHistory, netInHistory = newMetricHistory(numPoints), newMetricHistory(numPoints)
+	diskReadHistory, diskWriteHistory = newMetricHistory(numPoints), newMetricHistory(numPoints)
+
+	netOutSparkline, netInSparkline = newHistorySparkline(netOutHistory), newHistorySparkline(netInHistory)
+	netSparklineGroup = w.NewSparklineGroup(netOutSparkline, netInSparkline)
+	netSparklineGroup.Title = "Network History"
+
+	diskReadSparkline, diskWriteSparkline = newHistorySparkline(diskReadHistory), newHistorySparkline(diskWriteHistory)
+	diskSparklineGroup = w.NewSparklineGroup(diskReadSparkline, diskWriteSparkline)
+	diskSparklineGroup.Title = "Disk History"
+}
+
+func updateNetDiskCharts(netdiskMetrics NetDiskMetrics) {
+	netOutHistory.Push(netdiskMetrics.OutBytesPerSec)
+	netInHistory.Push(netdiskMetrics.InBytesPerSec)
+	outPeak, outAvg := netOutHistory.Stats()
+	inPeak, inAvg := netInHistory.Stats()
+	scaleSparklines(math.Max(outPeak, inPeak), netOutSparkline, netInSparkline)
+
+	netSparklineGroup.Title = fmt.Sprintf("Network: ↑ %s/s ↓ %s/s",
+		formatBytes(netdiskMetrics.OutBytesPerSec, networkUnit),
+		formatBytes(netdiskMetrics.InBytesPerSec, networkUnit),
+	)
+	netOutSparkline.Title = fmt.Sprintf("↑ Peak: %s/s | Avg: %s/s",
+		formatBytes(outPeak, networkUnit), formatBytes(outAvg, networkUnit))
+	netInSparkline.Title = fmt.Sprintf("↓ Peak: %s/s | Avg: %s/s",
+		formatBytes(inPeak, networkUnit), formatBytes(inAvg, networkUnit))
+
+	// Disk metrics are in KB/s, convert to Bytes for formatBytes
+	diskReadHistory.Push(netdiskMetrics.ReadKBytesPerSec * 1024)
+	diskWriteHistory.Push(netdiskMetrics.WriteKBytesPerSec * 1024)
+	readPeak, readAvg := diskReadHistory.Stats()
+	writePeak, writeAvg := diskWriteHistory.Stats()
+	scaleSparklines(math.Max(readPeak, writePeak), diskReadSparkline, diskWriteSparkline)
+
+	diskSparklineGroup.Title = fmt.Sprintf("Disk: R %s/s W %s/s",
+		formatBytes(netdiskMetrics.ReadKBytesPerSec*1024, diskUnit),
+		formatBytes(netdiskMetrics.WriteKBytesPerSec*1024, diskUnit),
+	)
+	diskReadSparkline.Title = fmt.Sprintf("R Peak: %s/s | Avg: %s/s",
+		formatBytes(readPeak, diskUnit), formatBytes(readAvg, diskUnit))
+	diskWriteSparkline.Title = fmt.Sprintf("W Peak: %s/s | Avg: %s/s",
+		formatBytes(writePeak, diskUnit), formatBytes(writeAvg, diskUnit))
+}
@@ -0,0 +1,181 @@
+package app
+
+import (
+	_ "embed"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+)
+
+//go:embed chips.json
+var builtinChipsJSON []byte
+
+// ChipSpec describes the layout of one Apple Silicon chip. A spec matches
+// when Match is the chip's name, e.g. "M2 Pro" for "Apple M2 Pro", and, if
+// set, the P/E core counts are equal. Order lists the core types in the
+// order the kernel numbers them within each die. GPUCores is the full
+// configuration, only used when the IORegistry doesn't report the count.
+type ChipSpec struct {
+	Name        string   `json:"name"`
+	Match       string   `json:"match"`
+	PCores      int      `json:"p_cores,omitempty"`
+	ECores      int      `json:"e_cores,omitempty"`
+	Dies        int      `json:"dies,omitempty"`
+	Order       []string `json:"order,omitempty"`
+	GPUCores    int      `json:"gpu_cores,omitempty"`
+	ANEMaxWatts float64  `json:"ane_max_watts,omitempty"`
+	Description string   `json:"description,omitempty"`
+}
+
+type chipTableFile struct {
+	Chips []ChipSpec `json:"chips"`
+}
+
+// defaultChipSpec is used when no table entry matches: P-cores first, then E-cores
+var defaultChipSpec = ChipSpec{
+	Name:        "Standard layout",
+	Dies:        1,
+	Order:       []string{"P", "E"},
+	Description: "Standard layout: P-cores first, then E-cores",
+}
+
+var (
+	chipTableOnce sync.Once
+	chipTable     []ChipSpec
+)
+
+func parseChipTable(data []byte) ([]ChipSpec, error) {
+	var file chipTableFile
+	if err := json.Unmarshal(data, &file); err != nil {
+		return nil, err
+	}
+	for i, spec := range file.Chips {
+		if spec.Match == "" {
+			return nil, fmt.Errorf("chip entry %d (%q) has no match pattern", i, spec.Name)
+		}
+		for _, kind := range spec.Order {
+			if kind != "P" && kind != "E" {
+				return nil, fmt.Errorf("chip entry %q has invalid core type %q in order", spec.Name, kind)
+			}
+		}
+	}
+	return file.Chips, nil
+}
+
+// readChipTable returns the overrides from overridePath, if it exists,
+// followed by the built-in table, so user entries take precedence
+func readChipTable(overridePath string) []ChipSpec {
+	builtin, err := parseChipTable(builtinChipsJSON)
+	if err != nil {
+		stderrLogger.Printf("invalid built-in chip table: %v\n", err)
+	}
+	if overridePath == "" {
+		return builtin
+	}
+	data, err := os.ReadFile(overridePath)
+	if err != nil {
+		return builtin
+	}
+	overrides, err := parseChipTable(data)
+	if err != nil {
+		stderrLogger.Printf("ignoring %s: %v\n", overridePath, err)
+		return builtin
+	}
+	return append(overrides, builtin...)
+}
+
+// loadChipTable returns the chip table with the overrides from
+// ~/.mactop/chips.json, read once per process
+func loadChipTable() []ChipSpec {
+	chipTableOnce.Do(func() {
+		var overridePath string
+		if homeDir, err := os.UserHomeDir(); err == nil {
+			overridePath = filepath.Join(homeDir, ".mactop", "chips.json")
+		}
+		chipTable = readChipTable(overridePath)
+	})
+	return chipTable
+}
+
+// chipModel strips the vendor from a brand string, "Apple M2 Pro" is "M2 Pro"
+func chipModel(brandString string) string {
+	return strings.TrimSpace(strings.TrimPrefix(strings.TrimSpace(brandString), "Apple"))
+}
+
+func (c ChipSpec) matches(sysInfo SystemInfo) bool {
+	if c.Match != chipModel(sysInfo.Name) {
+		return false
+	}
+	if c.PCores != 0 && c.PCores != sysInfo.PCoreCount {
+		return false
+	}
+	if c.ECores != 0 && c.ECores != sysInfo.ECoreCount {
+		return false
+	}
+	return true
+}
+
+func findChipSpec(table []ChipSpec, sysInfo SystemInfo) ChipSpec {
+	for _, spec := range table {
+		if spec.matches(sysInfo) {
+			return spec
+		}
+	}
+	return defaultChipSpec
+}
+
+// lookupChipSpec returns the first table entry matching the system
+func lookupChipSpec(sysInfo SystemInfo) ChipSpec {
+	return findChipSpec(loadChipTable(), sysInfo)
+}
+
+// topology lays out the core indices die by die, following the spec's order
+func (c ChipSpec) topology(pCoreCount, eCoreCount int) CoreTopology {
+	dies := max(c.Dies, 1)
+	order := c.Order
+	if len(order) == 0 {
+		order = defaultChipSpec.Order
+	}
+	description := c.Description
+	if description == "" {
+		description = fmt.Sprintf("%s: %s-cores first", c.Name, order[0])
+		if dies > 1 {
+			description += " within each die"
+		}
+	}
+
+	topology := CoreTopology{
+		Description:  description,
+		Dies:         dies,
+		PCoreIndices: make([]int, 0, pCoreCount),
+		ECoreIndices: make([]int, 0, eCoreCount),
+	}
+	pPerDie, ePerDie := pCoreCount/dies, eCoreCount/dies
+	next := 0
+	for die := 0; die < dies; die++ {
+		for _, kind := range order {
+			count := pPerDie
+			indices := &topology.PCoreIndices
+			if kind == "E" {
+				count = ePerDie
+				indices = &topology.ECoreIndices
+			}
+			// The last die picks up any cores that don't split evenly
+			if die == dies-1 {
+				if kind == "E" {
+					count = eCoreCount - ePerDie*(dies-1)
+				} else {
+					count = pCoreCount - pPerDie*(dies-1)
+				}
+			}
+			for i := 0; i < count; i++ {
+				*indices = append(*indices, next)
+				next++
+			}
+		}
+	}
+	return topology
+}
@@ -0,0 +1,154 @@
+package app
+
+import (
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+// TestMain points HOME at an empty directory, so no test picks up the
+// overrides or config of whoever runs them
+func TestMain(m *testing.M) {
+	home, err := os.MkdirTemp("", "mactop-home")
+	if err != nil {
+		panic(err)
+	}
+	os.Setenv("HOME", home)
+	code := m.Run()
+	os.RemoveAll(home)
+	os.Exit(code)
+}
+
+func seq(from, to int) []int {
+	var s []int
+	for i := from; i <= to; i++ {
+		s = append(s, i)
+	}
+	return s
+}
+
+func concat(parts ...[]int) []int {
+	var s []int
+	for _, p := range parts {
+		s = append(s, p...)
+	}
+	return s
+}
+
+func TestChipTableTopology(t *testing.T) {
+	builtin, err := parseChipTable(builtinChipsJSON)
+	if err != nil {
+		t.Fatalf("built-in chip table: %v", err)
+	}
+
+	tests := []struct {
+		name   string
+		chip   string
+		p, e   int
+		wantP  []int
+		wantE  []int
+		dies   int
+		prefix string
+	}{
+		{"M3 Ultra 32-core", "Apple M3 Ultra", 24, 8, concat(seq(4, 15), seq(20, 31)), concat(seq(0, 3), seq(16, 19)), 2, "M3 Ultra 32-core"},
+		{"M3 Ultra 28-core", "Apple M3 Ultra", 20, 8, concat(seq(4, 13), seq(18, 27)), concat(seq(0, 3), seq(14, 17)), 2, "M3 Ultra 28-core"},
+		{"M3 Ultra other", "Apple M3 Ultra", 16, 8, seq(0, 15), seq(16, 23), 1, "Standard layout: P-cores first, then E-cores"},
+		{"M4 Pro", "Apple M4 Pro", 10, 4, seq(4, 13), seq(0, 3), 1, "M4 Pro"},
+		{"M1 Ultra", "Apple M1 Ultra", 16, 4, concat(seq(0, 7), seq(10, 17)), concat(seq(8, 9), seq(18, 19)), 2, "M1/M2 Ultra"},
+		{"M2 Ultra", "Apple M2 Ultra", 16, 8, concat(seq(0, 7), seq(12, 19)), concat(seq(8, 11), seq(20, 23)), 2, "M1/M2 Ultra"},
+		{"M1", "Apple M1", 4, 4, seq(0, 3), seq(4, 7), 1, "Standard layout: P-cores first, then E-cores"},
+		{"M2 Max", "Apple M2 Max", 8, 4, seq(0, 7), seq(8, 11), 1, "Standard layout: P-cores first, then E-cores"},
+		{"M4 Max", "Apple M4 Max", 12, 4, seq(0, 11), seq(12, 15), 1, "Standard layout: P-cores first, then E-cores"},
+		{"Unknown chip", "Apple M9", 6, 6, seq(0, 5), seq(6, 11), 1, "Standard layout"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			sysInfo := SystemInfo{Name: tt.chip, PCoreCount: tt.p, ECoreCount: tt.e}
+			got := findChipSpec(builtin, sysInfo).topology(tt.p, tt.e)
+			if !reflect.DeepEqual(got.PCoreIndices, tt.wantP) {
+				t.Errorf("PCoreIndices = %v, want %v", got.PCoreIndices, tt.wantP)
+			}
+			if !reflect.DeepEqual(got.ECoreIndices, tt.wantE) {
+				t.Errorf("ECoreIndices = %v, want %v", got.ECoreIndices, tt.wantE)
+			}
+			if got.Dies != tt.dies {
+				t.Errorf("Dies = %d, want %d", got.Dies, tt.dies)
+			}
+			if len(got.Description) < len(tt.prefix) || got.Description[:len(tt.prefix)] != tt.prefix {
+				t.Errorf("Description = %q, want prefix %q", got.Description, tt.prefix)
+			}
+		})
+	}
+}
+
+func TestChipTableExactNames(t *testing.T) {
+	builtin, err := parseChipTable(builtinChipsJSON)
+	if err != nil {
+		t.Fatalf("built-in chip table: %v", err)
+	}
+	for _, name := range []string{"M1", "M1 Pro", "M1 Max", "M1 Ultra", "M2", "M2 Pro", "M2 Max", "M2 Ultra",
+		"M3", "M3 Pro", "M3 Max", "M3 Ultra", "M4", "M4 Pro", "M4 Max"} {
+		spec := findChipSpec(builtin, SystemInfo{Name: "Apple " + name})
+		if spec.Name != name {
+			t.Errorf("%s matched %q", name, spec.Name)
+		}
+		if spec.GPUCores == 0 {
+			t.Errorf("%s has no GPU core count", name)
+		}
+	}
+	// A family name is not a prefix of its variants
+	if spec := findChipSpec(builtin, SystemInfo{Name: "Apple M1 Pro Max"}); spec.Name != defaultChipSpec.Name {
+		t.Errorf("unknown variant matched %q", spec.Name)
+	}
+}
+
+func TestReadChipTable(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "chips.json")
+	if got := readChipTable(path); len(got) == 0 || got[0].Name != "M3 Ultra 32-core" {
+		t.Errorf("without overrides the table starts with %+v", got)
+	}
+	if err := os.WriteFile(path, []byte(`{"chips": [{"name": "M5", "match": "M5", "gpu_cores": 10}]}`), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if got := readChipTable(path); got[0].Name != "M5" || findChipSpec(got, SystemInfo{Name: "Apple M5"}).GPUCores != 10 {
+		t.Errorf("override not first: %+v", got[0])
+	}
+}
+
+func TestChipTableOverrides(t *testing.T) {
+	builtin, err := parseChipTable(builtinChipsJSON)
+	if err != nil {
+		t.Fatalf("built-in chip table: %v", err)
+	}
+	overrides, err := parseChipTable([]byte(`{"chips": [
+		{"name": "M5 Pro", "match": "M5 Pro", "order": ["E", "P"], "gpu_cores": 20, "ane_max_watts": 15}
+	]}`))
+	if err != nil {
+		t.Fatalf("override table: %v", err)
+	}
+	table := append(overrides, builtin...)
+
+	spec := findChipSpec(table, SystemInfo{Name: "Apple M5 Pro", PCoreCount: 10, ECoreCount: 4})
+	if spec.Name != "M5 Pro" || spec.GPUCores != 20 {
+		t.Fatalf("override not matched: %+v", spec)
+	}
+	got := spec.topology(10, 4)
+	if !reflect.DeepEqual(got.ECoreIndices, seq(0, 3)) || !reflect.DeepEqual(got.PCoreIndices, seq(4, 13)) {
+		t.Errorf("override topology = %+v", got)
+	}
+	if got.Description != "M5 Pro: E-cores first" {
+		t.Errorf("generated description = %q", got.Description)
+	}
+
+	for _, bad := range []string{
+		`{"chips": [{"name": "no match"}]}`,
+		`{"chips": [{"name": "bad order", "match": "M9", "order": ["X"]}]}`,
+		`not json`,
+	} {
+		if _, err := parseChipTable([]byte(bad)); err == nil {
+			t.Errorf("parseChipTable(%q) succeeded, want error", bad)
+		}
+	}
+}
@@ -7,8 +7,13 @@ import (
 )
 
 type AppConfig struct {
-	DefaultLayout string `json:"default_layout"`
-	Theme         string `json:"theme"`
+	DefaultLayout  string   `json:"default_layout"`
+	Theme          string   `json:"theme"`
+	NetworkInclude []string `json:"network_include,omitempty"`
+	NetworkExclude []string `json:"network_exclude,omitempty"`
+	DiskInclude    []string `json:"disk_include,omitempty"`
+	DiskExclude    []string `json:"disk_exclude,omitempty"`
+	CoreView       string   `json:"core_view,omitempty"`
 }
 
 var currentConfig AppConfig
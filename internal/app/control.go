@@ -0,0 +1,298 @@
+package app
+
+import (
+	"bufio"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net"
+	"os"
+	"syscall"
+	"time"
+
+	ui "github.com/gizak/termui/v3"
+)
+
+// maxControlRequest bounds a request line
+const maxControlRequest = 64 << 10
+
+// controlDialTimeout is how long an existing socket gets to answer before it
+// is taken to be left over from a previous run
+const controlDialTimeout = time.Second
+
+// JSON-RPC 2.0 error codes
+const (
+	rpcParseError     = -32700
+	rpcInvalidRequest = -32600
+	rpcMethodNotFound = -32601
+	rpcInvalidParams  = -32602
+	rpcServerError    = -32000
+)
+
+type rpcRequest struct {
+	JSONRPC string          `json:"jsonrpc"`
+	ID      json.RawMessage `json:"id,omitempty"`
+	Method  string          `json:"method"`
+	Params  json.RawMessage `json:"params,omitempty"`
+}
+
+type rpcResponse struct {
+	JSONRPC string          `json:"jsonrpc"`
+	ID      json.RawMessage `json:"id"`
+	Result  any             `json:"result,omitempty"`
+	Error   *rpcError       `json:"error,omitempty"`
+}
+
+type rpcError struct {
+	Code    int    `json:"code"`
+	Message string `json:"message"`
+}
+
+func (e *rpcError) Error() string {
+	return e.Message
+}
+
+func invalidParams(format string, args ...any) error {
+	return &rpcError{Code: rpcInvalidParams, Message: fmt.Sprintf(format, args...)}
+}
+
+// controlServer answers JSON-RPC 2.0 requests on a Unix socket, one per
+// line, so scripts can drive a running mactop:
+//
+//	echo '{"jsonrpc":"2.0","id":1,"method":"mark","params":{"label":"run 1"}}' | nc -U ~/.mactop/control.sock
+type controlServer struct {
+	hub *sampleHub
+	// tui is whether there is a display to change the layout and theme of
+	tui bool
+	// collectors is the number of collector loops waiting on interruptChan
+	collectors int
+}
+
+var controlMethods = map[string]func(s *controlServer, params json.RawMessage) (any, error){
+	"snapshot":    (*controlServer).snapshot,
+	"setInterval": (*controlServer).setInterval,
+	"setLayout":   (*controlServer).setLayout,
+	"setTheme":    (*controlServer).setTheme,
+	"mark":        (*controlServer).mark,
+	"refresh":     (*controlServer).refresh,
+}
+
+func decodeParams(params json.RawMessage, v any) error {
+	if len(params) == 0 {
+		return invalidParams("missing params")
+	}
+	if err := json.Unmarshal(params, v); err != nil {
+		return invalidParams("invalid params: %v", err)
+	}
+	return nil
+}
+
+// snapshot returns the latest sample in the headless JSON format
+func (s *controlServer) snapshot(json.RawMessage) (any, error) {
+	data := s.hub.snapshot()
+	if data == nil {
+		return nil, errors.New("no sample collected yet")
+	}
+	return json.RawMessage(data), nil
+}
+
+func (s *controlServer) setInterval(params json.RawMessage) (any, error) {
+	var p struct {
+		Interval int `json:"interval"`
+	}
+	if err := decodeParams(params, &p); err != nil {
+		return nil, err
+	}
+	// The same bounds as the +/- keys
+	if p.Interval < minUpdateInterval || p.Interval > maxUpdateInterval {
+		return nil, invalidParams("interval must be between %d and %d ms", minUpdateInterval, maxUpdateInterval)
+	}
+	updateInterval.Store(p.Interval)
+	if s.tui {
+		renderMutex.Lock()
+		updateHelpText()
+		updateModelText()
+		renderMutex.Unlock()
+	}
+	return map[string]int{"interval": updateInterval.Load()}, nil
+}
+
+func (s *controlServer) setLayout(params json.RawMessage) (any, error) {
+	var p struct {
+		Layout string `json:"layout"`
+	}
+	if err := decodeParams(params, &p); err != nil {
+		return nil, err
+	}
+	known := false
+	for _, name := range layoutOrder {
+		known = known || name == p.Layout
+	}
+	if !known {
+		return nil, invalidParams("unknown layout %q, options are %v", p.Layout, layoutOrder)
+	}
+	if !s.tui {
+		return nil, errors.New("no display to change the layout of")
+	}
+	renderMutex.Lock()
+	setLayout(p.Layout)
+	ui.Clear()
+	ui.Render(grid)
+	renderMutex.Unlock()
+	saveConfig()
+	return map[string]string{"layout": p.Layout}, nil
+}
+
+func (s *controlServer) setTheme(params json.RawMessage) (any, error) {
+	var p struct {
+		Theme string `json:"theme"`
+	}
+	if err := decodeParams(params, &p); err != nil {
+		return nil, err
+	}
+	if _, ok := colorMap[p.Theme]; !ok {
+		return nil, invalidParams("unknown theme %q, options are %v", p.Theme, colorNames)
+	}
+	if !s.tui {
+		return nil, errors.New("no display to change the theme of")
+	}
+	renderMutex.Lock()
+	applyTheme(p.Theme, IsLightMode)
+	ui.Clear()
+	ui.Render(grid)
+	renderMutex.Unlock()
+	saveConfig()
+	return map[string]string{"theme": p.Theme}, nil
+}
+
+// mark drops a labelled marker on the timeline, e.g. at the phases of a
+// benchmark
+func (s *controlServer) mark(params json.RawMessage) (any, error) {
+	var p struct {
+		Label string `json:"label"`
+	}
+	if err := decodeParams(params, &p); err != nil {
+		return nil, err
+	}
+	if p.Label == "" {
+		return nil, invalidParams("label must not be empty")
+	}
+	// The display picks it up with the next sample
+	return sessionMarkers.add(p.Label, time.Now()), nil
+}
+
+// refresh wakes the collectors to take a sample now rather than at the end
+// of the interval
+func (s *controlServer) refresh(json.RawMessage) (any, error) {
+	for i := 0; i < s.collectors; i++ {
+		select {
+		case interruptChan <- struct{}{}:
+		default:
+		}
+	}
+	return map[string]bool{"ok": true}, nil
+}
+
+// call runs one request line, returning the response or nil for a
+// notification
+func (s *controlServer) call(line []byte) *rpcResponse {
+	var req rpcRequest
+	if !json.Valid(line) {
+		return &rpcResponse{JSONRPC: "2.0", ID: json.RawMessage("null"), Error: &rpcError{rpcParseError, "parse error"}}
+	}
+	if err := json.Unmarshal(line, &req); err != nil || req.JSONRPC != "2.0" || req.Method == "" {
+		return &rpcResponse{JSONRPC: "2.0", ID: json.RawMessage("null"), Error: &rpcError{rpcInvalidRequest, "invalid request"}}
+	}
+
+	var result any
+	var err error
+	if method, ok := controlMethods[req.Method]; ok {
+		result, err = method(s, req.Params)
+	} else {
+		err = &rpcError{rpcMethodNotFound, fmt.Sprintf("method %q not found", req.Method)}
+	}
+	if req.ID == nil {
+		return nil
+	}
+	resp := &rpcResponse{JSONRPC: "2.0", ID: req.ID, Result: result}
+	if err != nil {
+		var rerr *rpcError
+		if !errors.As(err, &rerr) {
+			rerr = &rpcError{rpcServerError, err.Error()}
+		}
+		resp.Result, resp.Error = nil, rerr
+	}
+	return resp
+}
+
+func (s *controlServer) handle(conn net.Conn) {
+	defer conn.Close()
+	scanner := bufio.NewScanner(conn)
+	scanner.Buffer(make([]byte, 4096), maxControlRequest)
+	enc := json.NewEncoder(conn)
+	for scanner.Scan() {
+		if len(scanner.Bytes()) == 0 {
+			continue
+		}
+		resp := s.call(scanner.Bytes())
+		if resp == nil {
+			continue
+		}
+		conn.SetWriteDeadline(time.Now().Add(remoteWriteTimeout))
+		if err := enc.Encode(resp); err != nil {
+			return
+		}
+	}
+}
+
+// Serve accepts connections on ln until done is closed
+func (s *controlServer) Serve(ln net.Listener, done <-chan struct{}) error {
+	go func() {
+		<-done
+		ln.Close()
+	}()
+	for {
+		conn, err := ln.Accept()
+		if err != nil {
+			select {
+			case <-done:
+				return nil
+			default:
+				return err
+			}
+		}
+		go s.handle(conn)
+	}
+}
+
+// startControlServer listens on the socket at path, which only the current
+// user may connect to. A socket another instance still answers on is left
+// alone.
+func startControlServer(path string, s *controlServer, done <-chan struct{}) error {
+	// A socket left behind by a previous run would make Listen fail
+	if info, err := os.Stat(path); err == nil && info.Mode()&os.ModeSocket != 0 {
+		if conn, err := net.DialTimeout("unix", path, controlDialTimeout); err == nil {
+			conn.Close()
+			return fmt.Errorf("%s is in use by another mactop", path)
+		}
+		os.Remove(path)
+	}
+	// The socket is created with the umask applied, so it is never open to
+	// other users before the chmod
+	umask := syscall.Umask(0077)
+	ln, err := net.Listen("unix", path)
+	syscall.Umask(umask)
+	if err != nil {
+		return err
+	}
+	if err := os.Chmod(path, 0600); err != nil {
+		ln.Close()
+		return err
+	}
+	go func() {
+		if err := s.Serve(ln, done); err != nil {
+			stderrLogger.Printf("Control socket stopped: %v\n", err)
+		}
+	}()
+	return nil
+}
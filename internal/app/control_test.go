@@ -0,0 +1,127 @@
+package app
+
+import (
+	"bufio"
+	"encoding/json"
+	"net"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestControlCall(t *testing.T) {
+	defer updateInterval.Store(updateInterval.Load())
+
+	hub := newSampleHub()
+	s := &controlServer{hub: hub}
+	sessionMarkers.take()
+
+	tests := []struct {
+		name     string
+		request  string
+		wantCode int // 0 for success
+	}{
+		{"parse error", `{"jsonrpc":"2.0",`, rpcParseError},
+		{"not an object", `[1,2]`, rpcInvalidRequest},
+		{"wrong version", `{"jsonrpc":"1.0","id":1,"method":"refresh"}`, rpcInvalidRequest},
+		{"unknown method", `{"jsonrpc":"2.0","id":1,"method":"reboot"}`, rpcMethodNotFound},
+		{"no sample yet", `{"jsonrpc":"2.0","id":1,"method":"snapshot"}`, rpcServerError},
+		{"interval", `{"jsonrpc":"2.0","id":1,"method":"setInterval","params":{"interval":250}}`, 0},
+		{"interval too short", `{"jsonrpc":"2.0","id":1,"method":"setInterval","params":{"interval":10}}`, rpcInvalidParams},
+		{"interval without params", `{"jsonrpc":"2.0","id":1,"method":"setInterval"}`, rpcInvalidParams},
+		{"unknown layout", `{"jsonrpc":"2.0","id":1,"method":"setLayout","params":{"layout":"tiled"}}`, rpcInvalidParams},
+		{"layout without a display", `{"jsonrpc":"2.0","id":1,"method":"setLayout","params":{"layout":"compact"}}`, rpcServerError},
+		{"unknown theme", `{"jsonrpc":"2.0","id":1,"method":"setTheme","params":{"theme":"plaid"}}`, rpcInvalidParams},
+		{"empty label", `{"jsonrpc":"2.0","id":1,"method":"mark","params":{"label":""}}`, rpcInvalidParams},
+		{"mark", `{"jsonrpc":"2.0","id":"m1","method":"mark","params":{"label":"warmup"}}`, 0},
+		{"refresh", `{"jsonrpc":"2.0","id":1,"method":"refresh"}`, 0},
+	}
+	for _, tt := range tests {
+		resp := s.call([]byte(tt.request))
+		if resp == nil {
+			t.Errorf("%s: no response", tt.name)
+			continue
+		}
+		switch {
+		case tt.wantCode == 0 && resp.Error != nil:
+			t.Errorf("%s: error %+v", tt.name, resp.Error)
+		case tt.wantCode != 0 && (resp.Error == nil || resp.Error.Code != tt.wantCode):
+			t.Errorf("%s: error %+v, want code %d", tt.name, resp.Error, tt.wantCode)
+		}
+	}
+
+	if updateInterval.Load() != 250 {
+		t.Errorf("updateInterval = %d, want 250", updateInterval.Load())
+	}
+	if markers := sessionMarkers.take(); len(markers) != 1 || markers[0].Label != "warmup" {
+		t.Errorf("markers = %+v", markers)
+	}
+
+	// Notifications get no response, but still run
+	if resp := s.call([]byte(`{"jsonrpc":"2.0","method":"mark","params":{"label":"run 1"}}`)); resp != nil {
+		t.Errorf("notification answered with %+v", resp)
+	}
+	if markers := sessionMarkers.take(); len(markers) != 1 || markers[0].Label != "run 1" {
+		t.Errorf("markers after notification = %+v", markers)
+	}
+}
+
+func TestControlServer(t *testing.T) {
+	// Socket paths are limited to about 100 bytes, shorter than some TempDirs
+	dir, err := os.MkdirTemp("", "mactop")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "control.sock")
+
+	// A socket left over from a crash is replaced
+	stale, err := net.Listen("unix", path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	stale.(*net.UnixListener).SetUnlinkOnClose(false)
+	stale.Close()
+
+	hub := newSampleHub()
+	hub.publish(HeadlessOutput{CPUUsage: 42.5, ThermalState: "Nominal"})
+	done := make(chan struct{})
+	defer close(done)
+	if err := startControlServer(path, &controlServer{hub: hub}, done); err != nil {
+		t.Fatal(err)
+	}
+	if info, err := os.Stat(path); err != nil || info.Mode().Perm() != 0600 {
+		t.Errorf("socket mode = %v, %v; want 0600", info.Mode(), err)
+	}
+
+	// A socket a running instance answers on is not taken over
+	if err := startControlServer(path, &controlServer{hub: hub}, done); err == nil {
+		t.Error("startControlServer took over a live socket")
+	}
+
+	conn, err := net.Dial("unix", path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	conn.SetDeadline(time.Now().Add(5 * time.Second))
+	conn.Write([]byte(`{"jsonrpc":"2.0","method":"refresh"}` + "\n\n" + `{"jsonrpc":"2.0","id":7,"method":"snapshot"}` + "\n"))
+
+	var resp struct {
+		JSONRPC string         `json:"jsonrpc"`
+		ID      int            `json:"id"`
+		Result  HeadlessOutput `json:"result"`
+		Error   *rpcError      `json:"error"`
+	}
+	line, err := bufio.NewReader(conn).ReadBytes('\n')
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := json.Unmarshal(line, &resp); err != nil {
+		t.Fatalf("%v: %s", err, line)
+	}
+	if resp.JSONRPC != "2.0" || resp.ID != 7 || resp.Error != nil || resp.Result.CPUUsage != 42.5 {
+		t.Errorf("snapshot response = %s", line)
+	}
+}
@@ -0,0 +1,204 @@
+package app
+
+import (
+	"bytes"
+	"encoding/xml"
+	"fmt"
+	"log"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"sort"
+	"strings"
+)
+
+const launchAgentLabel = "com.github.context-labs.mactop"
+
+// daemonEnv are the environment variables mactop reads settings from. Those
+// set when the agent is installed are passed on, as launchd starts it with a
+// bare environment.
+var daemonEnv = []string{
+	"MACTOP_PROMETHEUS_BASIC_AUTH",
+	"MACTOP_PROMETHEUS_BEARER_TOKEN",
+	"OTEL_EXPORTER_OTLP_ENDPOINT",
+	"OTEL_EXPORTER_OTLP_METRICS_ENDPOINT",
+	"OTEL_EXPORTER_OTLP_HEADERS",
+	"MACTOP_REMOTE_WRITE_TOKEN",
+	"MACTOP_MQTT_PASSWORD",
+}
+
+// runDaemon runs the collector with its exporters, metrics server and history
+// recorder, logging to logfile. SIGHUP reopens the log and calls
+// reloadConfig, which rereads config.json; the flags the daemon was started
+// with are fixed for its lifetime.
+func runDaemon(logfile *rotatingLog, reloadConfig func()) {
+	stderrLogger.SetOutput(logfile)
+	stderrLogger.SetFlags(log.LstdFlags)
+	logfile.RedirectStderr()
+	recordHistory = true
+
+	stderrLogger.Printf("mactop %s daemon started, pid %d\n", version, os.Getpid())
+	runCollector(0, nil, func() {
+		if err := logfile.Reopen(); err != nil {
+			stderrLogger.Printf("Failed to reopen log file: %v\n", err)
+		}
+		reloadConfig()
+		stderrLogger.Printf("Reloaded config.json, restart the daemon to change its options\n")
+	})
+	stderrLogger.Printf("mactop daemon stopped\n")
+}
+
+// launchAgent is a LaunchAgent running `mactop daemon`
+type launchAgent struct {
+	Label   string
+	Args    []string
+	Env     map[string]string
+	LogPath string
+}
+
+// newLaunchAgent describes an agent running exe as a daemon with args. The
+// variables of daemonEnv are taken from lookupEnv.
+func newLaunchAgent(exe string, args []string, lookupEnv func(string) (string, bool), home string) launchAgent {
+	agent := launchAgent{
+		Label:   launchAgentLabel,
+		Args:    append([]string{exe, "daemon"}, args...),
+		Env:     make(map[string]string),
+		LogPath: filepath.Join(home, ".mactop", "launchd.log"),
+	}
+	for _, name := range daemonEnv {
+		if value, ok := lookupEnv(name); ok {
+			agent.Env[name] = value
+		}
+	}
+	return agent
+}
+
+func launchAgentPath(home string) string {
+	return filepath.Join(home, "Library", "LaunchAgents", launchAgentLabel+".plist")
+}
+
+// plist renders the agent as a property list. It starts at login and is
+// restarted if it exits with an error, but not after a clean stop.
+func (a launchAgent) plist() []byte {
+	var b bytes.Buffer
+	str := func(indent, s string) {
+		b.WriteString(indent + "<string>")
+		xml.EscapeText(&b, []byte(s))
+		b.WriteString("</string>\n")
+	}
+	key := func(indent, s string) {
+		b.WriteString(indent + "<key>")
+		xml.EscapeText(&b, []byte(s))
+		b.WriteString("</key>\n")
+	}
+
+	b.WriteString(`<?xml version="1.0" encoding="UTF-8"?>
+<!DOCTYPE plist PUBLIC "-//Apple//DTD PLIST 1.0//EN" "http://www.apple.com/DTDs/PropertyList-1.0.dtd">
+<plist version="1.0">
+<dict>
+`)
+	key("\t", "Label")
+	str("\t", a.Label)
+	key("\t", "ProgramArguments")
+	b.WriteString("\t<array>\n")
+	for _, arg := range a.Args {
+		str("\t\t", arg)
+	}
+	b.WriteString("\t</array>\n")
+	if len(a.Env) > 0 {
+		names := make([]string, 0, len(a.Env))
+		for name := range a.Env {
+			names = append(names, name)
+		}
+		sort.Strings(names)
+		key("\t", "EnvironmentVariables")
+		b.WriteString("\t<dict>\n")
+		for _, name := range names {
+			key("\t\t", name)
+			str("\t\t", a.Env[name])
+		}
+		b.WriteString("\t</dict>\n")
+	}
+	key("\t", "RunAtLoad")
+	b.WriteString("\t<true/>\n")
+	key("\t", "KeepAlive")
+	b.WriteString("\t<dict>\n")
+	key("\t\t", "SuccessfulExit")
+	b.WriteString("\t\t<false/>\n")
+	b.WriteString("\t</dict>\n")
+	key("\t", "ThrottleInterval")
+	b.WriteString("\t<integer>30</integer>\n")
+	key("\t", "ProcessType")
+	str("\t", "Background")
+	key("\t", "StandardOutPath")
+	str("\t", a.LogPath)
+	key("\t", "StandardErrorPath")
+	str("\t", a.LogPath)
+	b.WriteString("</dict>\n</plist>\n")
+	return b.Bytes()
+}
+
+func launchctl(args ...string) error {
+	out, err := exec.Command("launchctl", args...).CombinedOutput()
+	if err != nil {
+		return fmt.Errorf("launchctl %s: %v: %s", strings.Join(args, " "), err, strings.TrimSpace(string(out)))
+	}
+	return nil
+}
+
+// installLaunchAgent writes the LaunchAgent for `mactop daemon args...` and
+// (re)starts it
+func installLaunchAgent(args []string) error {
+	exe, err := os.Executable()
+	if err != nil {
+		return err
+	}
+	if resolved, err := filepath.EvalSymlinks(exe); err == nil {
+		exe = resolved
+	}
+	home, err := os.UserHomeDir()
+	if err != nil {
+		return err
+	}
+	agent := newLaunchAgent(exe, args, os.LookupEnv, home)
+	path := launchAgentPath(home)
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		return err
+	}
+	if err := os.MkdirAll(filepath.Dir(agent.LogPath), 0755); err != nil {
+		return err
+	}
+	// The environment may hold credentials
+	if err := os.WriteFile(path, agent.plist(), 0600); err != nil {
+		return err
+	}
+
+	domain := fmt.Sprintf("gui/%d", os.Getuid())
+	// Replace a running agent; failing just means none was loaded
+	launchctl("bootout", domain+"/"+launchAgentLabel)
+	if err := launchctl("bootstrap", domain, path); err != nil {
+		return err
+	}
+	fmt.Printf("Installed %s\nRunning: %s\n", path, strings.Join(agent.Args, " "))
+	return nil
+}
+
+// uninstallLaunchAgent stops the agent and removes its plist
+func uninstallLaunchAgent() error {
+	home, err := os.UserHomeDir()
+	if err != nil {
+		return err
+	}
+	path := launchAgentPath(home)
+	if _, err := os.Stat(path); os.IsNotExist(err) {
+		return fmt.Errorf("no agent installed at %s", path)
+	}
+	if err := launchctl("bootout", fmt.Sprintf("gui/%d/%s", os.Getuid(), launchAgentLabel)); err != nil {
+		stderrLogger.Printf("Agent was not running: %v\n", err)
+	}
+	if err := os.Remove(path); err != nil {
+		return err
+	}
+	fmt.Printf("Removed %s\n", path)
+	return nil
+}
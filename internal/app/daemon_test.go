@@ -0,0 +1,177 @@
+package app
+
+import (
+	"bytes"
+	"encoding/xml"
+	"io"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestLaunchAgentPlist(t *testing.T) {
+	env := map[string]string{
+		"MACTOP_MQTT_PASSWORD":         "s3cret&<x>",
+		"MACTOP_PROMETHEUS_BASIC_AUTH": "user:pass",
+		"HOME":                         "/Users/me",
+	}
+	lookup := func(name string) (string, bool) {
+		v, ok := env[name]
+		return v, ok
+	}
+	agent := newLaunchAgent("/opt/homebrew/bin/mactop", []string{"--prometheus", ":2112", "--mqtt-broker", "tcp://nas&co:1883"}, lookup, "/Users/me")
+
+	if want := map[string]string{"MACTOP_MQTT_PASSWORD": "s3cret&<x>", "MACTOP_PROMETHEUS_BASIC_AUTH": "user:pass"}; !reflect.DeepEqual(agent.Env, want) {
+		t.Errorf("Env = %v, want %v", agent.Env, want)
+	}
+	if agent.LogPath != "/Users/me/.mactop/launchd.log" {
+		t.Errorf("LogPath = %q", agent.LogPath)
+	}
+
+	// Walk the plist as generic XML and collect the ProgramArguments strings
+	// and EnvironmentVariables keys
+	plist := agent.plist()
+	dec := xml.NewDecoder(bytes.NewReader(plist))
+	var args, envKeys, path []string
+	var lastKey string
+	for {
+		tok, err := dec.Token()
+		if err != nil {
+			if err != io.EOF {
+				t.Fatalf("plist is not well-formed: %v\n%s", err, plist)
+			}
+			break
+		}
+		switch tok := tok.(type) {
+		case xml.StartElement:
+			path = append(path, tok.Name.Local)
+		case xml.EndElement:
+			path = path[:len(path)-1]
+		case xml.CharData:
+			text := string(tok)
+			switch strings.Join(path, "/") {
+			case "plist/dict/key":
+				lastKey = text
+			case "plist/dict/array/string":
+				if lastKey == "ProgramArguments" {
+					args = append(args, text)
+				}
+			case "plist/dict/dict/key":
+				if lastKey == "EnvironmentVariables" {
+					envKeys = append(envKeys, text)
+				}
+			}
+		}
+	}
+	if want := []string{"/opt/homebrew/bin/mactop", "daemon", "--prometheus", ":2112", "--mqtt-broker", "tcp://nas&co:1883"}; !reflect.DeepEqual(args, want) {
+		t.Errorf("ProgramArguments = %q, want %q", args, want)
+	}
+	if want := []string{"MACTOP_MQTT_PASSWORD", "MACTOP_PROMETHEUS_BASIC_AUTH"}; !reflect.DeepEqual(envKeys, want) {
+		t.Errorf("EnvironmentVariables keys = %q, want %q", envKeys, want)
+	}
+	for _, want := range []string{"<key>Label</key>\n\t<string>" + launchAgentLabel + "</string>", "<key>RunAtLoad</key>\n\t<true/>", "s3cret&amp;&lt;x&gt;"} {
+		if !bytes.Contains(plist, []byte(want)) {
+			t.Errorf("plist does not contain %q:\n%s", want, plist)
+		}
+	}
+
+	bare := newLaunchAgent("/usr/local/bin/mactop", nil, func(string) (string, bool) { return "", false }, "/Users/me").plist()
+	if bytes.Contains(bare, []byte("EnvironmentVariables")) {
+		t.Errorf("plist without variables has an environment:\n%s", bare)
+	}
+}
+
+func TestRotatingLog(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "mactop.log")
+	l, err := openRotatingLog(path, 10, 2)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer l.Close()
+
+	for _, line := range []string{"one\n", "two\n", "three\n", "four\n", "five\n", "six\n", "seven\n"} {
+		if _, err := l.Write([]byte(line)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	read := func(name string) string {
+		b, err := os.ReadFile(name)
+		if err != nil {
+			return "<" + err.Error() + ">"
+		}
+		return string(b)
+	}
+	// Each file holds what fits in 10 bytes, and "one two" has been dropped
+	want := map[string]string{
+		path:        "six\nseven\n",
+		path + ".1": "four\nfive\n",
+		path + ".2": "three\n",
+	}
+	for name, content := range want {
+		if got := read(name); got != content {
+			t.Errorf("%s = %q, want %q", filepath.Base(name), got, content)
+		}
+	}
+	if _, err := os.Stat(path + ".3"); !os.IsNotExist(err) {
+		t.Errorf("kept a third backup: %v", err)
+	}
+
+	// Reopen after the file was moved away writes to a fresh one
+	if err := os.Rename(path, path+".moved"); err != nil {
+		t.Fatal(err)
+	}
+	if err := l.Reopen(); err != nil {
+		t.Fatal(err)
+	}
+	l.Write([]byte("eight\n"))
+	if got := read(path); got != "eight\n" {
+		t.Errorf("after Reopen log = %q", got)
+	}
+
+	// Opening again appends rather than truncating
+	l2, err := openRotatingLog(path, 100, 2)
+	if err != nil {
+		t.Fatal(err)
+	}
+	l2.Write([]byte("nine\n"))
+	l2.Close()
+	if got := read(path); got != "eight\nnine\n" {
+		t.Errorf("after reopening log = %q", got)
+	}
+}
+
+func TestRotatingLogSharedFile(t *testing.T) {
+	// The TUI and the daemon each have the log open
+	path := filepath.Join(t.TempDir(), "mactop.log")
+	tui, err := openRotatingLog(path, 10, 2)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer tui.Close()
+	daemon, err := openRotatingLog(path, 10, 2)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer daemon.Close()
+
+	tui.Write([]byte("tui1\ntui2\n"))
+	daemon.Write([]byte("d1\n"))
+	// The TUI rotates, then the daemon only follows it to the new file
+	tui.Write([]byte("tui3\n"))
+	daemon.Write([]byte("d2345678\n"))
+
+	want := map[string]string{
+		path:        "tui3\nd2345678\n",
+		path + ".1": "tui1\ntui2\nd1\n",
+	}
+	for name, content := range want {
+		if got, _ := os.ReadFile(name); string(got) != content {
+			t.Errorf("%s = %q, want %q", filepath.Base(name), got, content)
+		}
+	}
+	if _, err := os.Stat(path + ".2"); !os.IsNotExist(err) {
+		t.Errorf("the log was rotated twice: %v", err)
+	}
+}
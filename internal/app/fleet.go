@@ -0,0 +1,424 @@
+package app
+
+import (
+	"flag"
+	"fmt"
+	"os"
+	"os/exec"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	ui "github.com/gizak/termui/v3"
+	w "github.com/gizak/termui/v3/widgets"
+)
+
+// fleetColumns are the columns of the fleet table. All but HISTORY can be
+// sorted on.
+var fleetColumns = []string{"HOST", "MODEL", "CPU", "GPU", "ANE", "POWER", "TEMP", "THERMAL", "STATUS", "HISTORY"}
+
+const (
+	fleetHistoryLen = 20
+	// A host is stale once it missed this many sample intervals
+	fleetStaleIntervals = 3
+)
+
+var sparkRunes = []rune("▁▂▃▄▅▆▇█")
+
+// fleetHost is the latest state of one agent
+type fleetHost struct {
+	Addr      string
+	Latest    *RemoteSample
+	LastSeen  time.Time
+	Connected bool
+	History   []float64
+}
+
+// Fleet tracks the samples of several agents
+type Fleet struct {
+	mu    sync.Mutex
+	hosts []*fleetHost
+}
+
+func newFleet(addrs []string) *Fleet {
+	f := &Fleet{}
+	for _, addr := range addrs {
+		f.hosts = append(f.hosts, &fleetHost{Addr: addr})
+	}
+	return f
+}
+
+func (f *Fleet) update(h *fleetHost, sample RemoteSample, now time.Time) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	h.Latest = &sample
+	h.LastSeen = now
+	h.Connected = true
+	h.History = append(h.History, sample.Sample.CPUUsage)
+	if len(h.History) > fleetHistoryLen {
+		h.History = h.History[len(h.History)-fleetHistoryLen:]
+	}
+}
+
+func (f *Fleet) disconnect(h *fleetHost) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	h.Connected = false
+}
+
+// watch keeps a connection to one agent open, reconnecting after failures.
+// A read deadline makes a hung agent count as disconnected.
+func (f *Fleet) watch(h *fleetHost, interval time.Duration, done <-chan struct{}) {
+	for {
+		client, err := dialAgent(h.Addr)
+		if err == nil {
+			for {
+				client.conn.SetReadDeadline(time.Now().Add(fleetStaleIntervals*interval + remoteDialTimeout))
+				sample, err := client.Next()
+				if err != nil {
+					break
+				}
+				f.update(h, sample, time.Now())
+			}
+			client.Close()
+			f.disconnect(h)
+		}
+		select {
+		case <-done:
+			return
+		case <-time.After(remoteReconnectDelay):
+		}
+	}
+}
+
+// fleetRow is one host as shown in the table. Nil readings are unavailable.
+type fleetRow struct {
+	Addr        string
+	Host, Model string
+	CPU, GPU    *float64
+	ANE, Power  *float64
+	Temp        *float64
+	Thermal     int
+	ThermalName string
+	Seen, Stale bool
+	Age         time.Duration
+	History     []float64
+}
+
+func (f *Fleet) rows(now time.Time, interval time.Duration) []fleetRow {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	rows := make([]fleetRow, 0, len(f.hosts))
+	for _, h := range f.hosts {
+		row := fleetRow{Addr: h.Addr, Host: h.Addr, Stale: true}
+		if h.Latest != nil {
+			out := h.Latest.Sample
+			cpu := out.CPUUsage
+			row.Host = h.Latest.Host
+			if row.Host == "" {
+				row.Host = h.Addr
+			}
+			row.Model = out.SystemInfo.Name
+			row.CPU = &cpu
+			row.GPU = out.GPUUsage
+			row.ANE = out.ANEUsage
+			if out.SocMetrics.TotalPower != nil {
+				power := *out.SocMetrics.TotalPower
+				row.Power = &power
+			}
+			if out.CPUTemp != nil {
+				temp := float64(*out.CPUTemp)
+				row.Temp = &temp
+			}
+			row.Thermal = thermalStateIndex(out.ThermalState)
+			row.ThermalName = out.ThermalState
+			row.Seen = true
+			row.Age = now.Sub(h.LastSeen)
+			row.Stale = !h.Connected || row.Age > fleetStaleIntervals*interval
+			row.History = append([]float64(nil), h.History...)
+		}
+		rows = append(rows, row)
+	}
+	return rows
+}
+
+// lessOptional orders unavailable readings before any value
+func lessOptional(a, b *float64) bool {
+	if a == nil || b == nil {
+		return a == nil && b != nil
+	}
+	return *a < *b
+}
+
+func lessFleetRows(a, b fleetRow, column int) bool {
+	switch fleetColumns[column] {
+	case "MODEL":
+		return a.Model < b.Model
+	case "CPU":
+		return lessOptional(a.CPU, b.CPU)
+	case "GPU":
+		return lessOptional(a.GPU, b.GPU)
+	case "ANE":
+		return lessOptional(a.ANE, b.ANE)
+	case "POWER":
+		return lessOptional(a.Power, b.Power)
+	case "TEMP":
+		return lessOptional(a.Temp, b.Temp)
+	case "THERMAL":
+		return a.Thermal < b.Thermal
+	case "STATUS":
+		if a.Stale != b.Stale {
+			return !a.Stale
+		}
+		return a.Age < b.Age
+	}
+	return a.Host < b.Host
+}
+
+func sortFleetRows(rows []fleetRow, column int, reverse bool) {
+	sort.SliceStable(rows, func(i, j int) bool {
+		if reverse {
+			return lessFleetRows(rows[j], rows[i], column)
+		}
+		return lessFleetRows(rows[i], rows[j], column)
+	})
+}
+
+// sparkString renders values between 0 and maxVal as a row of block characters
+func sparkString(values []float64, maxVal float64) string {
+	var b strings.Builder
+	for _, v := range values {
+		idx := 0
+		if maxVal > 0 {
+			idx = int(v / maxVal * float64(len(sparkRunes)-1))
+		}
+		idx = max(0, min(idx, len(sparkRunes)-1))
+		b.WriteRune(sparkRunes[idx])
+	}
+	return b.String()
+}
+
+func (r fleetRow) cells() []string {
+	percent := func(v *float64) string {
+		if v == nil {
+			return notAvailable
+		}
+		return fmt.Sprintf("%.0f%%", *v)
+	}
+	power, temp, thermal, model := notAvailable, notAvailable, "-", r.Model
+	if r.Power != nil {
+		power = fmt.Sprintf("%.1f W", *r.Power)
+	}
+	if r.Temp != nil && *r.Temp > 0 {
+		temp = formatTemp(*r.Temp)
+	}
+	if r.ThermalName != "" {
+		thermal = r.ThermalName
+	}
+	if model == "" {
+		model = "-"
+	}
+	status := "live"
+	switch {
+	case !r.Seen:
+		status = "unreachable"
+	case r.Stale:
+		status = fmt.Sprintf("stale %s", r.Age.Truncate(time.Second))
+	}
+	return []string{r.Host, model, percent(r.CPU), percent(r.GPU), percent(r.ANE), power, temp, thermal, status, sparkString(r.History, 100)}
+}
+
+// fleetColumnWidths gives the fixed-size columns what they need and splits
+// the rest between host and model
+func fleetColumnWidths(total int) []int {
+	widths := []int{0, 0, 6, 6, 6, 9, 7, 10, 12, fleetHistoryLen + 1}
+	used := 0
+	for _, width := range widths {
+		used += width
+	}
+	flexible := max(total-used, 20)
+	widths[0] = flexible / 2
+	widths[1] = flexible - widths[0]
+	return widths
+}
+
+// fleetView is the sort and selection state of the fleet table
+type fleetView struct {
+	column   int
+	reverse  bool
+	selected string // agent address of the selected row
+	message  string
+}
+
+func (v *fleetView) render(table *w.Table, rows []fleetRow, highlight ui.Color) {
+	sortFleetRows(rows, v.column, v.reverse)
+
+	header := make([]string, len(fleetColumns))
+	for i, name := range fleetColumns {
+		header[i] = name
+		if i == v.column {
+			arrow := "▲"
+			if v.reverse {
+				arrow = "▼"
+			}
+			header[i] = name + " " + arrow
+		}
+	}
+
+	table.Rows = [][]string{header}
+	table.RowStyles = map[int]ui.Style{0: ui.NewStyle(highlight, ui.ColorClear, ui.ModifierBold)}
+	selectedIndex := -1
+	for i, row := range rows {
+		table.Rows = append(table.Rows, row.cells())
+		if row.Stale {
+			table.RowStyles[i+1] = ui.NewStyle(SecondaryTextColor)
+		}
+		if row.Addr == v.selected {
+			selectedIndex = i
+		}
+	}
+	if selectedIndex < 0 && len(rows) > 0 {
+		selectedIndex = 0
+		v.selected = rows[0].Addr
+	}
+	if selectedIndex >= 0 {
+		table.RowStyles[selectedIndex+1] = ui.NewStyle(ui.ColorBlack, highlight)
+	}
+
+	table.Title = fmt.Sprintf("mactop fleet - %d hosts | ↑↓ select  ←→ sort  space reverse  enter open  q quit", len(rows))
+	if v.message != "" {
+		table.Title += " | " + v.message
+	}
+}
+
+// move changes the selection by delta rows in the current sort order
+func (v *fleetView) move(rows []fleetRow, delta int) {
+	sortFleetRows(rows, v.column, v.reverse)
+	for i, row := range rows {
+		if row.Addr == v.selected {
+			next := max(0, min(i+delta, len(rows)-1))
+			v.selected = rows[next].Addr
+			return
+		}
+	}
+}
+
+// fleetForwardedFlags are the flags a host opened from the fleet inherits
+// when the user gave them. The agent token is passed in the environment
+// instead, where other users can't read it.
+var fleetForwardedFlags = map[string]bool{
+	"color":        true,
+	"unit-network": true,
+	"unit-disk":    true,
+	"unit-temp":    true,
+	"agent-tls":    true,
+	"agent-ca":     true,
+}
+
+// fleetConnectArgs are the arguments of "mactop connect" for addr, carrying
+// over the interval and the flags set in fs that also apply to a single host.
+// Values are separate arguments, as --color and --interval are also read
+// before the flags are parsed.
+func fleetConnectArgs(fs *flag.FlagSet, addr string, interval int) []string {
+	args := []string{"connect", addr, "--interval", strconv.Itoa(interval)}
+	fs.Visit(func(f *flag.Flag) {
+		if !fleetForwardedFlags[f.Name] {
+			return
+		}
+		if b, ok := f.Value.(interface{ IsBoolFlag() bool }); ok && b.IsBoolFlag() {
+			args = append(args, "--"+f.Name+"="+f.Value.String())
+			return
+		}
+		args = append(args, "--"+f.Name, f.Value.String())
+	})
+	return args
+}
+
+// openFleetHost hands the terminal to "mactop connect" for the host and
+// takes it back when that exits
+func openFleetHost(addr string) error {
+	exe, err := os.Executable()
+	if err != nil {
+		return err
+	}
+	ui.Close()
+	cmd := exec.Command(exe, fleetConnectArgs(flag.CommandLine, addr, updateInterval.Load())...)
+	cmd.Env = append(os.Environ(), "MACTOP_AGENT_TOKEN="+agentSettings.Token)
+	cmd.Stdin, cmd.Stdout, cmd.Stderr = os.Stdin, os.Stdout, os.Stderr
+	runErr := cmd.Run()
+	if err := ui.Init(); err != nil {
+		stderrLogger.Fatalf("failed to initialize termui: %v", err)
+	}
+	return runErr
+}
+
+func runFleet(addrs []string) {
+	if err := ui.Init(); err != nil {
+		stderrLogger.Fatalf("failed to initialize termui: %v", err)
+	}
+	defer ui.Close()
+
+	interval := updateInterval.Duration()
+	fleet := newFleet(addrs)
+	done := make(chan struct{})
+	defer close(done)
+	for _, h := range fleet.hosts {
+		go fleet.watch(h, interval, done)
+	}
+
+	highlight, ok := colorMap[currentConfig.Theme]
+	if !ok {
+		highlight = ui.ColorGreen
+	}
+	table := w.NewTable()
+	table.RowSeparator = false
+	table.FillRow = true
+	table.TextStyle = ui.NewStyle(ui.ColorClear)
+	table.BorderStyle.Fg = highlight
+	table.TitleStyle.Fg = highlight
+
+	view := &fleetView{}
+	draw := func() {
+		termWidth, termHeight := ui.TerminalDimensions()
+		table.SetRect(0, 0, termWidth, termHeight)
+		table.ColumnWidths = fleetColumnWidths(termWidth - 2)
+		view.render(table, fleet.rows(time.Now(), interval), highlight)
+		ui.Clear()
+		ui.Render(table)
+	}
+	draw()
+
+	events := ui.PollEvents()
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ticker.C:
+		case e := <-events:
+			switch e.ID {
+			case "q", "<C-c>":
+				return
+			case "<Up>", "k":
+				view.move(fleet.rows(time.Now(), interval), -1)
+			case "<Down>", "j":
+				view.move(fleet.rows(time.Now(), interval), 1)
+			case "<Left>":
+				view.column = (view.column + len(fleetColumns) - 2) % (len(fleetColumns) - 1)
+			case "<Right>":
+				view.column = (view.column + 1) % (len(fleetColumns) - 1)
+			case "<Space>":
+				view.reverse = !view.reverse
+			case "<Enter>":
+				view.message = ""
+				if view.selected != "" {
+					if err := openFleetHost(view.selected); err != nil {
+						view.message = fmt.Sprintf("%s: %v", view.selected, err)
+					}
+				}
+			}
+		}
+		draw()
+	}
+}
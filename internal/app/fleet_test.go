@@ -0,0 +1,161 @@
+package app
+
+import (
+	"flag"
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestFleetRowsStale(t *testing.T) {
+	interval := time.Second
+	start := time.Date(2024, 3, 1, 9, 0, 0, 0, time.UTC)
+	fleet := newFleet([]string{"mini-01:7070", "mini-02:7070", "mini-03:7070"})
+	source := &syntheticSampleSource{}
+	fleet.update(fleet.hosts[0], source.Sample(), start)
+	fleet.update(fleet.hosts[1], source.Sample(), start)
+	fleet.update(fleet.hosts[1], source.Sample(), start.Add(interval))
+	fleet.disconnect(fleet.hosts[1])
+
+	tests := []struct {
+		name   string
+		now    time.Time
+		status []string
+	}{
+		{"Fresh", start.Add(interval), []string{"live", "stale 0s", "unreachable"}},
+		{"Missed samples", start.Add(5 * interval), []string{"stale 5s", "stale 4s", "unreachable"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			rows := fleet.rows(tt.now, interval)
+			if len(rows) != 3 {
+				t.Fatalf("got %d rows, want every host kept", len(rows))
+			}
+			for i, row := range rows {
+				if got := row.cells()[8]; got != tt.status[i] {
+					t.Errorf("row %d status = %q, want %q", i, got, tt.status[i])
+				}
+			}
+		})
+	}
+
+	rows := fleet.rows(start, interval)
+	if rows[0].Host != "ci-mini-01" || rows[0].Model != "Apple M2" || rows[0].Thermal != 2 {
+		t.Errorf("row from sample = %+v", rows[0])
+	}
+	if rows[2].Host != "mini-03:7070" || rows[2].CPU != nil {
+		t.Errorf("unreachable row = %+v", rows[2])
+	}
+	if want := []float64{2, 3}; !reflect.DeepEqual(rows[1].History, want) {
+		t.Errorf("history = %v, want %v", rows[1].History, want)
+	}
+}
+
+func TestFleetHistoryBounded(t *testing.T) {
+	fleet := newFleet([]string{"mini-01:7070"})
+	source := &syntheticSampleSource{}
+	for i := 0; i < fleetHistoryLen+5; i++ {
+		fleet.update(fleet.hosts[0], source.Sample(), time.Now())
+	}
+	history := fleet.rows(time.Now(), time.Second)[0].History
+	if len(history) != fleetHistoryLen || history[len(history)-1] != fleetHistoryLen+5 {
+		t.Errorf("history = %v", history)
+	}
+}
+
+func TestSortFleetRows(t *testing.T) {
+	value := func(v float64) *float64 { return &v }
+	rows := []fleetRow{
+		{Addr: "a", Host: "a", CPU: value(40), Power: value(12)},
+		{Addr: "b", Host: "b", CPU: nil},
+		{Addr: "c", Host: "c", CPU: value(90), Power: value(3)},
+		{Addr: "d", Host: "d", CPU: value(10), Stale: true},
+	}
+	column := func(name string) int {
+		for i, c := range fleetColumns {
+			if c == name {
+				return i
+			}
+		}
+		t.Fatalf("no column %s", name)
+		return 0
+	}
+
+	tests := []struct {
+		column  string
+		reverse bool
+		want    []string
+	}{
+		{"HOST", true, []string{"d", "c", "b", "a"}},
+		{"CPU", false, []string{"b", "d", "a", "c"}},
+		{"CPU", true, []string{"c", "a", "d", "b"}},
+		{"POWER", true, []string{"a", "c", "b", "d"}},
+		{"STATUS", false, []string{"a", "b", "c", "d"}},
+	}
+	for _, tt := range tests {
+		sorted := append([]fleetRow(nil), rows...)
+		sortFleetRows(sorted, column(tt.column), tt.reverse)
+		var got []string
+		for _, row := range sorted {
+			got = append(got, row.Host)
+		}
+		if !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("sort by %s (reverse %v) = %v, want %v", tt.column, tt.reverse, got, tt.want)
+		}
+	}
+}
+
+func TestFleetViewMove(t *testing.T) {
+	value := func(v float64) *float64 { return &v }
+	rows := []fleetRow{
+		{Addr: "a:1", Host: "a", CPU: value(40)},
+		{Addr: "b:1", Host: "b", CPU: value(90)},
+		{Addr: "c:1", Host: "c", CPU: value(10)},
+	}
+	view := &fleetView{column: 2, reverse: true, selected: "b:1"}
+	view.move(rows, 1)
+	if view.selected != "a:1" {
+		t.Errorf("selected %q after moving down, want a:1", view.selected)
+	}
+	view.move(rows, 5)
+	if view.selected != "c:1" {
+		t.Errorf("selected %q after moving past the end, want c:1", view.selected)
+	}
+}
+
+func TestSparkString(t *testing.T) {
+	tests := []struct {
+		values []float64
+		maxVal float64
+		want   string
+	}{
+		{[]float64{0, 50, 100}, 100, "▁▄█"},
+		{[]float64{-5, 250}, 100, "▁█"},
+		{[]float64{10}, 0, "▁"},
+		{nil, 100, ""},
+	}
+	for _, tt := range tests {
+		if got := sparkString(tt.values, tt.maxVal); got != tt.want {
+			t.Errorf("sparkString(%v, %v) = %q, want %q", tt.values, tt.maxVal, got, tt.want)
+		}
+	}
+}
+
+func TestFleetConnectArgs(t *testing.T) {
+	fs := flag.NewFlagSet("mactop", flag.ContinueOnError)
+	fs.Int("interval", 1000, "")
+	fs.String("color", "", "")
+	fs.String("unit-temp", "celsius", "")
+	fs.Bool("agent-tls", false, "")
+	fs.String("agent-token", "", "")
+	fs.String("prometheus", "", "")
+	if err := fs.Parse([]string{"--color", "red", "--unit-temp=fahrenheit", "--agent-tls", "--agent-token", "s3cret", "--prometheus", "9090"}); err != nil {
+		t.Fatal(err)
+	}
+
+	got := fleetConnectArgs(fs, "mini-01:7070", 500)
+	want := []string{"connect", "mini-01:7070", "--interval", "500", "--agent-tls=true", "--color", "red", "--unit-temp", "fahrenheit"}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("fleetConnectArgs() = %q, want %q", got, want)
+	}
+}
@@ -17,16 +17,24 @@ var (
 	version                                      = "v0.2.7"
 	cpuGauge, gpuGauge, memoryGauge, aneGauge    *w.Gauge
 	modelText, PowerChart, NetworkInfo, helpText *w.Paragraph
+	NetDeviceInfo, summaryText                   *w.Paragraph
 	grid                                         *ui.Grid
 	processList                                  *w.List
 	sparkline, gpuSparkline                      *w.Sparkline
-	sparklineGroup, gpuSparklineGroup            *w.SparklineGroup
+	sparklineGroup, gpuSparklineGroup            *MarkedSparklineGroup
+	netSparklineGroup, diskSparklineGroup        *w.SparklineGroup
+	netOutSparkline, netInSparkline              *w.Sparkline
+	diskReadSparkline, diskWriteSparkline        *w.Sparkline
+	netOutHistory, netInHistory                  *metricHistory
+	diskReadHistory, diskWriteHistory            *metricHistory
 	cpuCoreWidget                                *CPUCoreWidget
+	tempChart                                    *TempChartWidget
+	coreHeatmap                                  *CoreHeatmapWidget
 	powerValues                                  = make([]float64, 35)
 	lastUpdateTime                               time.Time
 	stderrLogger                                 = log.New(os.Stderr, "", 0)
-	showHelp, partyMode                          = false, false
-	updateInterval                               = 1000
+	showHelp, showSummary, partyMode             = false, false, false
+	updateInterval                               = newMSInterval(1000)
 	done                                         = make(chan struct{})
 	partyTicker                                  *time.Ticker
 	lastCPUTimes                                 []CPUUsage
@@ -40,8 +48,24 @@ var (
 	headless                                     bool
 	headlessCount                                int
 	interruptChan                                = make(chan struct{}, 10)
-	lastNetStats                                 net.IOCountersStat
-	lastDiskStats                                disk.IOCountersStat
+	lastNetStats                                 = make(map[string]net.IOCountersStat)
+	lastDiskStats                                = make(map[string]disk.IOCountersStat)
+	networkFilter, diskFilter                    DeviceFilter
+	capabilities                                 SocCapabilities
+	dashboardHub                                 = newSampleHub()
+	metricsConfig                                metricsServerConfig
+	activeMetricsServer                          *metricsServer
+	otlpSettings                                 otlpConfig
+	remoteWriteSettings                          remoteWriteConfig
+	statsdSettings                               statsdConfig
+	mqttSettings                                 mqttConfig
+	recordHistory                                bool
+	historyDB                                    string
+	controlSocket                                string
+	playback                                     *uiPlayback
+	coreTitle                                    string
+	sessionStats                                 *sessionSummary
+	remoteAddr, remoteHost                       string
 	lastNetDiskTime                              time.Time
 	netDiskMutex                                 sync.Mutex
 	killPending                                  bool
@@ -128,6 +152,46 @@ var (
 		[]string{"operation"},
 	)
 
+	networkInterfaceSpeed = prometheus.NewGaugeVec(
+		prometheus.GaugeOpts{
+			Name: "mactop_network_interface_bytes_per_sec",
+			Help: "Per-interface network speed in bytes/s",
+		},
+		[]string{"interface", "direction"},
+	)
+
+	diskDeviceIOSpeed = prometheus.NewGaugeVec(
+		prometheus.GaugeOpts{
+			Name: "mactop_disk_device_kbytes_per_sec",
+			Help: "Per-device disk I/O speed in KB/s",
+		},
+		[]string{"device", "operation"},
+	)
+
+	gpuMemoryBytes = prometheus.NewGaugeVec(
+		prometheus.GaugeOpts{
+			Name: "mactop_gpu_memory_bytes",
+			Help: "GPU system memory in bytes as reported by the AGX driver",
+		},
+		[]string{"type"},
+	)
+
+	gpuUtilization = prometheus.NewGaugeVec(
+		prometheus.GaugeOpts{
+			Name: "mactop_gpu_utilization_percent",
+			Help: "GPU device, renderer and tiler utilization percentage",
+		},
+		[]string{"unit"},
+	)
+
+	aneUsage = prometheus.NewGaugeVec(
+		prometheus.GaugeOpts{
+			Name: "mactop_ane_usage_percent",
+			Help: "Current ANE usage percentage, labelled with the method used to derive it",
+		},
+		[]string{"method"},
+	)
+
 	totalPowerGauge = prometheus.NewGauge(
 		prometheus.GaugeOpts{
 			Name: "mactop_total_power_watts",
@@ -0,0 +1,98 @@
+package app
+
+import (
+	"fmt"
+	"strconv"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// GPUPerformanceStats holds the memory and utilisation figures the AGX driver
+// publishes in its PerformanceStatistics IORegistry property
+type GPUPerformanceStats struct {
+	Available           bool    `json:"available"`
+	InUseSystemMemory   uint64  `json:"in_use_system_memory"`
+	AllocSystemMemory   uint64  `json:"alloc_system_memory"`
+	DeviceUtilization   float64 `json:"device_utilization"`
+	RendererUtilization float64 `json:"renderer_utilization"`
+	TilerUtilization    float64 `json:"tiler_utilization"`
+}
+
+// statNumber converts a decoded property value to a float64. Numbers arrive as
+// float64 from encoding/json, but older drivers publish some values as strings.
+func statNumber(value any) (float64, bool) {
+	switch v := value.(type) {
+	case float64:
+		return v, true
+	case int:
+		return float64(v), true
+	case int64:
+		return float64(v), true
+	case uint64:
+		return float64(v), true
+	case string:
+		f, err := strconv.ParseFloat(v, 64)
+		return f, err == nil
+	}
+	return 0, false
+}
+
+// parseGPUPerformanceStatistics extracts the metrics mactop reports from the
+// PerformanceStatistics dictionary. Missing keys are left at zero, the result
+// is only marked available if at least one known key was present.
+func parseGPUPerformanceStatistics(stats map[string]any) GPUPerformanceStats {
+	var perf GPUPerformanceStats
+	for key, field := range map[string]*float64{
+		"Device Utilization %":   &perf.DeviceUtilization,
+		"Renderer Utilization %": &perf.RendererUtilization,
+		"Tiler Utilization %":    &perf.TilerUtilization,
+	} {
+		if v, ok := statNumber(stats[key]); ok {
+			*field = v
+			perf.Available = true
+		}
+	}
+	for key, field := range map[string]*uint64{
+		"In use system memory": &perf.InUseSystemMemory,
+		"Alloc system memory":  &perf.AllocSystemMemory,
+	} {
+		if v, ok := statNumber(stats[key]); ok && v >= 0 {
+			*field = uint64(v)
+			perf.Available = true
+		}
+	}
+	return perf
+}
+
+func getGPUPerformanceStats() GPUPerformanceStats {
+	stats, err := getGPUPerformanceStatistics()
+	if err != nil {
+		return GPUPerformanceStats{}
+	}
+	return parseGPUPerformanceStatistics(stats)
+}
+
+// gpuPerformanceLabel is shown inside the GPU gauge bar
+func gpuPerformanceLabel(activePercent float64, perf GPUPerformanceStats) string {
+	if !perf.Available {
+		return fmt.Sprintf("%d%%", int(activePercent))
+	}
+	return fmt.Sprintf("%d%% | Renderer %d%% | Tiler %d%% | Mem %s / %s",
+		int(activePercent),
+		int(perf.RendererUtilization),
+		int(perf.TilerUtilization),
+		formatBytes(float64(perf.InUseSystemMemory), "auto"),
+		formatBytes(float64(perf.AllocSystemMemory), "auto"),
+	)
+}
+
+func updateGPUPerformancePrometheus(perf GPUPerformanceStats) {
+	if !perf.Available {
+		return
+	}
+	gpuMemoryBytes.With(prometheus.Labels{"type": "in_use"}).Set(float64(perf.InUseSystemMemory))
+	gpuMemoryBytes.With(prometheus.Labels{"type": "allocated"}).Set(float64(perf.AllocSystemMemory))
+	gpuUtilization.With(prometheus.Labels{"unit": "device"}).Set(perf.DeviceUtilization)
+	gpuUtilization.With(prometheus.Labels{"unit": "renderer"}).Set(perf.RendererUtilization)
+	gpuUtilization.With(prometheus.Labels{"unit": "tiler"}).Set(perf.TilerUtilization)
+}
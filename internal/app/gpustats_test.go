@@ -0,0 +1,76 @@
+package app
+
+import (
+	"encoding/json"
+	"testing"
+)
+
+// Synthetic PerformanceStatistics dictionaries in the shape
+// copyGPUPerformanceStatistics serialises them. The first follows the keys
+// `ioreg -r -c IOAccelerator -d 1` lists on an M1 Pro; the others cover
+// string-typed numbers, an idle GPU and a dictionary without the keys read.
+const (
+	m1ProPerformanceStatistics = `{"Alloc system memory":1652916224,"Allocated PB Size":205520896,` +
+		`"Device Utilization %":38,"In use system memory":598081536,"In use system memory (driver)":0,` +
+		`"Renderer Utilization %":35,"SplitSceneCount":0,"TiledSceneBytes":1179648,` +
+		`"Tiler Utilization %":9,"lastRecoveryTime":0,"recoveryCount":0}`
+	stringPerformanceStatistics = `{"Alloc system memory":"2147483648","Device Utilization %":"71",` +
+		`"In use system memory":"1073741824","Renderer Utilization %":"70","Tiler Utilization %":"22"}`
+	idlePerformanceStatistics = `{"Alloc system memory":419430400,"Device Utilization %":0,` +
+		`"In use system memory":104857600,"Renderer Utilization %":0,"Tiler Utilization %":0}`
+	unrelatedPerformanceStatistics = `{"recoveryCount":3,"lastRecoveryTime":1700000000}`
+)
+
+func TestParseGPUPerformanceStatistics(t *testing.T) {
+	tests := []struct {
+		name    string
+		fixture string
+		want    GPUPerformanceStats
+	}{
+		{"M1 Pro", m1ProPerformanceStatistics, GPUPerformanceStats{
+			Available:           true,
+			InUseSystemMemory:   598081536,
+			AllocSystemMemory:   1652916224,
+			DeviceUtilization:   38,
+			RendererUtilization: 35,
+			TilerUtilization:    9,
+		}},
+		{"String values", stringPerformanceStatistics, GPUPerformanceStats{
+			Available:           true,
+			InUseSystemMemory:   1073741824,
+			AllocSystemMemory:   2147483648,
+			DeviceUtilization:   71,
+			RendererUtilization: 70,
+			TilerUtilization:    22,
+		}},
+		{"Idle", idlePerformanceStatistics, GPUPerformanceStats{
+			Available:         true,
+			InUseSystemMemory: 104857600,
+			AllocSystemMemory: 419430400,
+		}},
+		{"No known keys", unrelatedPerformanceStatistics, GPUPerformanceStats{}},
+		{"Empty", `{}`, GPUPerformanceStats{}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var stats map[string]any
+			if err := json.Unmarshal([]byte(tt.fixture), &stats); err != nil {
+				t.Fatalf("invalid fixture: %v", err)
+			}
+			if got := parseGPUPerformanceStatistics(stats); got != tt.want {
+				t.Errorf("parseGPUPerformanceStatistics() = %+v, want %+v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestGPUPerformanceLabel(t *testing.T) {
+	if got := gpuPerformanceLabel(42.7, GPUPerformanceStats{}); got != "42%" {
+		t.Errorf("label without stats = %q", got)
+	}
+	perf := GPUPerformanceStats{Available: true, RendererUtilization: 40, TilerUtilization: 12, InUseSystemMemory: 1536, AllocSystemMemory: 2048}
+	if got := gpuPerformanceLabel(42, perf); got != "42% | Renderer 40% | Tiler 12% | Mem 1.5KB / 2.0KB" {
+		t.Errorf("label with stats = %q", got)
+	}
+}
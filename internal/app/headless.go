@@ -3,108 +3,229 @@ package app
 import (
 	"encoding/json"
 	"fmt"
+	"io"
 	"os"
-	"strings"
+	"os/signal"
+	"syscall"
 	"time"
 
 	"github.com/prometheus/client_golang/prometheus"
 )
 
-// Note: strings is still needed for TrimPrefix in startPrometheusServer call
+// HeadlessSocMetrics is SocMetrics as emitted in headless mode. Readings whose
+// source didn't initialise are null rather than zero.
+type HeadlessSocMetrics struct {
+	CPUPower     *float64 `json:"cpu_power"`
+	GPUPower     *float64 `json:"gpu_power"`
+	ANEPower     *float64 `json:"ane_power"`
+	DRAMPower    *float64 `json:"dram_power"`
+	GPUSRAMPower *float64 `json:"gpu_sram_power"`
+	SystemPower  *float64 `json:"system_power"`
+	TotalPower   *float64 `json:"total_power"`
+	GPUFreqMHz   *int32   `json:"gpu_freq_mhz"`
+	SocTemp      *float32 `json:"soc_temp"`
+	CPUTemp      *float32 `json:"cpu_temp"`
+	GPUTemp      *float32 `json:"gpu_temp"`
+}
 
-func runHeadless(count int) {
-	if err := initSocMetrics(); err != nil {
-		fmt.Fprintf(os.Stderr, "Failed to initialize metrics: %v\n", err)
-		os.Exit(1)
+// HeadlessOutput is one JSON sample written by headless mode
+type HeadlessOutput struct {
+	Timestamp    string               `json:"timestamp"`
+	SocMetrics   HeadlessSocMetrics   `json:"soc_metrics"`
+	Memory       MemoryMetrics        `json:"memory"`
+	NetDisk      NetDiskMetrics       `json:"net_disk"`
+	CPUUsage     float64              `json:"cpu_usage"`
+	GPUUsage     *float64             `json:"gpu_usage"`
+	CoreUsages   []float64            `json:"core_usages"`
+	SystemInfo   SystemInfo           `json:"system_info"`
+	ThermalState string               `json:"thermal_state"`
+	CPUTemp      *float32             `json:"cpu_temp"`
+	GPUTemp      *float32             `json:"gpu_temp"`
+	GPUStats     *GPUPerformanceStats `json:"gpu_stats"`
+	ANEUsage     *float64             `json:"ane_usage"`
+	ANEMethod    *string              `json:"ane_method"`
+	Capabilities SocCapabilities      `json:"capabilities"`
+	Markers      []timelineMarker     `json:"markers,omitempty"`
+}
+
+func headlessSocMetrics(m SocMetrics, caps SocCapabilities) HeadlessSocMetrics {
+	energy := caps.EnergyModel
+	temp := func(celsius float32) *float32 {
+		return optional(celsius, caps.Temperature() && celsius > 0)
+	}
+	return HeadlessSocMetrics{
+		CPUPower:     optional(m.CPUPower, energy),
+		GPUPower:     optional(m.GPUPower, energy),
+		ANEPower:     optional(m.ANEPower, energy),
+		DRAMPower:    optional(m.DRAMPower, energy),
+		GPUSRAMPower: optional(m.GPUSRAMPower, energy),
+		SystemPower:  optional(m.SystemPower, caps.SystemPower),
+		TotalPower:   optional(m.TotalPower, caps.Power()),
+		GPUFreqMHz:   optional(m.GPUFreqMHz, caps.GPUStats),
+		SocTemp:      temp(m.SocTemp),
+		CPUTemp:      temp(m.CPUTemp),
+		GPUTemp:      temp(m.GPUTemp),
 	}
+}
+
+// headlessSystemPower is the PSTR whole-system power of a sample, or nil
+// without PSTR. system_power in the output is only the part drawn beyond the
+// SoC components, while total_power is the PSTR reading when there is one
+// and the component sum otherwise.
+func headlessSystemPower(out HeadlessOutput) *float64 {
+	if !out.Capabilities.SystemPower {
+		return nil
+	}
+	return out.SocMetrics.TotalPower
+}
+
+// headlessExporterFlag names the first exporter flag given that only the
+// headless sample loop acts on, or is empty
+func headlessExporterFlag() string {
+	switch {
+	case remoteWriteSettings.URL != "":
+		return "remote-write-url"
+	case statsdSettings.Addr != "":
+		return "statsd"
+	case mqttSettings.Broker != "":
+		return "mqtt-broker"
+	}
+	return ""
+}
+
+func runHeadless(count int) {
+	runCollector(count, os.Stdout, nil)
+}
+
+// runCollector samples until interrupted or count samples were taken,
+// feeding the configured exporters and writing JSON to out unless it is nil.
+// A fixed count writes an array of samples followed, on its own line, by a
+// {"summary": ...} object, so the array stays as consumers expect and the
+// output can be read as a stream of JSON values. reload, if given, is called
+// on SIGHUP.
+func runCollector(count int, out io.Writer, reload func()) {
+	initMetricSources()
 	defer cleanupSocMetrics()
 
 	if prometheusPort != "" {
-		// Use startPrometheusServer to register custom mactop metrics
-		// Strip leading colon if present (CLI passes ":9090" but startPrometheusServer expects "9090")
-		port := strings.TrimPrefix(prometheusPort, ":")
-		startPrometheusServer(port)
+		if err := startPrometheusServer(metricsConfig); err != nil {
+			stderrLogger.Fatalf("failed to start metrics server: %v", err)
+		}
+		defer stopPrometheusServer()
+	}
+	stop := make(chan struct{})
+	defer close(stop)
+	if otlpSettings.enabled() {
+		if err := startOTLPExporter(otlpSettings, stop); err != nil {
+			stderrLogger.Fatalf("failed to start OTLP exporter: %v", err)
+		}
 	}
+	var writer *remoteWriter
+	if remoteWriteSettings.URL != "" {
+		var err error
+		if writer, err = startRemoteWriter(remoteWriteSettings, stop); err != nil {
+			stderrLogger.Fatalf("failed to start remote write: %v", err)
+		}
+		defer writer.flush()
+	}
+	var statsd *statsdEmitter
+	if statsdSettings.Addr != "" {
+		var err error
+		if statsd, err = newStatsdEmitter(statsdSettings, getSOCInfo().Name); err != nil {
+			stderrLogger.Fatalf("failed to start StatsD emitter: %v", err)
+		}
+		defer statsd.Close()
+	}
+	var mqtt *mqttPublisher
+	if mqttSettings.Broker != "" {
+		var err error
+		if mqtt, err = startMQTTPublisher(mqttSettings); err != nil {
+			stderrLogger.Fatalf("failed to start MQTT publisher: %v", err)
+		}
+		defer mqtt.stop()
+	}
+	var recorder *historyRecorder
+	if recordHistory {
+		var err error
+		if recorder, err = startHistoryRecorder(historyDB); err != nil {
+			stderrLogger.Fatalf("failed to open history database: %v", err)
+		}
+		defer recorder.Close()
+	}
+	if controlSocket != "" {
+		if err := startControlServer(controlSocket, &controlServer{hub: dashboardHub, collectors: 1}, stop); err != nil {
+			stderrLogger.Fatalf("failed to start control socket: %v", err)
+		}
+	}
+	exportMetrics := prometheusPort != "" || otlpSettings.enabled() || writer != nil || statsd != nil
 
-	ticker := time.NewTicker(time.Duration(updateInterval) * time.Millisecond)
+	tickerInterval := updateInterval.Duration()
+	ticker := time.NewTicker(tickerInterval)
 	defer ticker.Stop()
-
-	type HeadlessOutput struct {
-		Timestamp    string         `json:"timestamp"`
-		SocMetrics   SocMetrics     `json:"soc_metrics"`
-		Memory       MemoryMetrics  `json:"memory"`
-		NetDisk      NetDiskMetrics `json:"net_disk"`
-		CPUUsage     float64        `json:"cpu_usage"`
-		GPUUsage     float64        `json:"gpu_usage"`
-		CoreUsages   []float64      `json:"core_usages"`
-		SystemInfo   SystemInfo     `json:"system_info"`
-		ThermalState string         `json:"thermal_state"`
-		CPUTemp      float32        `json:"cpu_temp"`
-		GPUTemp      float32        `json:"gpu_temp"`
+	quit := make(chan os.Signal, 1)
+	signal.Notify(quit, os.Interrupt, syscall.SIGTERM)
+	defer signal.Stop(quit)
+	hup := make(chan os.Signal, 1)
+	if reload != nil {
+		signal.Notify(hup, syscall.SIGHUP)
+		defer signal.Stop(hup)
 	}
 
-	encoder := json.NewEncoder(os.Stdout)
+	// Arrays are only written for a fixed count
+	array := out != nil && count > 0
 
-	// Cache static hardware info — these shell out to sysctl/system_profiler
-	// and never change at runtime.
+	// Static hardware info is read natively once and cached, it never
+	// changes at runtime.
 	sysInfo := getSOCInfo()
 	var topology CoreTopology
-	if prometheusPort != "" {
+	if exportMetrics {
 		topology = GetCoreTopology(sysInfo)
 	}
 
 	GetCPUPercentages()
 
-	if count > 0 {
-		fmt.Print("[")
+	var summary *sessionSummary
+	if array {
+		summary = newSessionSummary(GetCoreTopology(sysInfo), time.Now())
+		fmt.Fprint(out, "[")
 	}
-
 	samplesCollected := 0
-	for range ticker.C {
-		m := sampleSocMetrics(updateInterval)
-		mem := getMemoryMetrics()
-		netDisk := getNetDiskMetrics()
-
-		var cpuUsagePercent float64
-		percentages, err := GetCPUPercentages()
-		if err == nil && len(percentages) > 0 {
-			var total float64
-			for _, p := range percentages {
-				total += p
-			}
-			cpuUsagePercent = total / float64(len(percentages))
+	closeArray := func() {
+		fmt.Fprintln(out, "]")
+		if summary != nil && samplesCollected > 0 {
+			json.NewEncoder(out).Encode(struct {
+				Summary SessionSummary `json:"summary"`
+			}{summary.summary()})
 		}
+	}
 
-		thermalStr, _ := getThermalStateString()
-
-		componentSum := m.TotalPower
-		totalPower := m.SystemPower
-
-		if totalPower < componentSum {
-			totalPower = componentSum
+	for {
+		select {
+		case <-quit:
+			if array {
+				closeArray()
+			}
+			return
+		case <-hup:
+			reload()
+			continue
+		case <-ticker.C:
+		case <-interruptChan:
 		}
-
-		residualSystem := totalPower - componentSum
-
-		m.SystemPower = residualSystem
-		m.TotalPower = totalPower
-
-		output := HeadlessOutput{
-			Timestamp:    time.Now().Format(time.RFC3339),
-			SocMetrics:   m,
-			Memory:       mem,
-			NetDisk:      netDisk,
-			CPUUsage:     cpuUsagePercent,
-			GPUUsage:     m.GPUActive,
-			CoreUsages:   percentages,
-			SystemInfo:   sysInfo,
-			ThermalState: thermalStr,
-			CPUTemp:      m.CPUTemp,
-			GPUTemp:      m.GPUTemp,
+		// The interval may have been changed over the control socket
+		if interval := updateInterval.Duration(); interval != tickerInterval {
+			ticker.Reset(interval)
+			tickerInterval = interval
 		}
+		sample := collectHeadlessSample(sysInfo, updateInterval.Load())
+		output, m := sample.Output, sample.Soc
+		percentages, cpuUsagePercent := output.CoreUsages, output.CPUUsage
+		mem, netDisk, thermalStr := output.Memory, output.NetDisk, output.ThermalState
+		gpuPerf, aneUtil, aneMethod := sample.GPUPerf, sample.ANEUsage, sample.ANEMethod
+		output.Markers = sessionMarkers.take()
 
 		// Update Prometheus metrics
-		if prometheusPort != "" && len(percentages) > 0 {
+		if exportMetrics && len(percentages) > 0 {
 			// Use cached topology-aware core mapping
 
 			var ecoreAvg, pcoreAvg float64
@@ -152,25 +273,140 @@ func runHeadless(count int) {
 			memoryUsage.With(prometheus.Labels{"type": "swap_used"}).Set(float64(mem.SwapUsed) / 1024 / 1024 / 1024)
 			memoryUsage.With(prometheus.Labels{"type": "swap_total"}).Set(float64(mem.SwapTotal) / 1024 / 1024 / 1024)
 
-			networkSpeed.With(prometheus.Labels{"direction": "upload"}).Set(netDisk.OutBytesPerSec)
-			networkSpeed.With(prometheus.Labels{"direction": "download"}).Set(netDisk.InBytesPerSec)
-			diskIOSpeed.With(prometheus.Labels{"operation": "read"}).Set(netDisk.ReadKBytesPerSec)
-			diskIOSpeed.With(prometheus.Labels{"operation": "write"}).Set(netDisk.WriteKBytesPerSec)
+			updateNetDiskPrometheus(netDisk)
+			updateGPUPerformancePrometheus(gpuPerf)
+			updateANEPrometheus(aneUtil, aneMethod)
 			totalPowerGauge.Set(m.TotalPower)
+
+			if writer != nil || statsd != nil {
+				if families, err := mactopRegistry().Gather(); err != nil {
+					stderrLogger.Printf("Error gathering metrics: %v\n", err)
+				} else {
+					if writer != nil {
+						writer.append(families, time.Now())
+					}
+					if statsd != nil {
+						statsd.emit(families, percentages, topology)
+					}
+				}
+			}
 		}
 
-		if samplesCollected > 0 && count > 0 {
-			fmt.Print(",")
+		if mqtt != nil {
+			mqtt.offer(output)
+		}
+		if recorder != nil {
+			recorder.record(output, time.Now())
+		}
+		if prometheusPort != "" || controlSocket != "" {
+			dashboardHub.publish(output)
+		}
+		if prometheusPort != "" || summary != nil {
+			if processes, err := getProcessList(); err == nil {
+				if prometheusPort != "" {
+					dashboardHub.publishProcesses(processes)
+				}
+				if summary != nil {
+					summary.recordProcesses(processes)
+				}
+			}
+		}
+		if summary != nil {
+			summary.record(output, time.Now())
 		}
 
-		if err := encoder.Encode(output); err != nil {
-			fmt.Fprintf(os.Stderr, "Error encoding JSON: %v\n", err)
+		if out != nil {
+			if samplesCollected > 0 && array {
+				fmt.Fprint(out, ",")
+			}
+			if err := json.NewEncoder(out).Encode(output); err != nil {
+				fmt.Fprintf(os.Stderr, "Error encoding JSON: %v\n", err)
+			}
 		}
 
 		samplesCollected++
 		if count > 0 && samplesCollected >= count {
-			fmt.Println("]")
+			if array {
+				closeArray()
+			}
 			return
 		}
 	}
 }
+
+// headlessSample is one collected sample: the JSON output plus the raw values
+// the Prometheus metrics are fed from
+type headlessSample struct {
+	Output    HeadlessOutput
+	Soc       SocMetrics
+	GPUPerf   GPUPerformanceStats
+	ANEUsage  float64
+	ANEMethod string
+}
+
+// collectHeadlessSample gathers one sample, blocking for sampleMs while
+// IOReport measures power
+func collectHeadlessSample(sysInfo SystemInfo, sampleMs int) headlessSample {
+	m := sampleSocMetrics(sampleMs)
+	mem := getMemoryMetrics()
+	netDisk := getNetDiskMetrics()
+	gpuPerf := getGPUPerformanceStats()
+
+	var cpuUsagePercent float64
+	percentages, err := GetCPUPercentages()
+	if err == nil && len(percentages) > 0 {
+		var total float64
+		for _, p := range percentages {
+			total += p
+		}
+		cpuUsagePercent = total / float64(len(percentages))
+	}
+
+	thermalStr, _ := getThermalStateString()
+
+	componentSum := m.TotalPower
+	totalPower := m.SystemPower
+
+	if totalPower < componentSum {
+		totalPower = componentSum
+	}
+
+	residualSystem := totalPower - componentSum
+
+	aneUtil, aneMethod := aneUtilization(CPUMetrics{
+		ANEW:         m.ANEPower,
+		ANEActive:    m.ANEActive,
+		ANEResidency: m.ANEResidency,
+	}, sysInfo.Name)
+
+	m.SystemPower = residualSystem
+	m.TotalPower = totalPower
+
+	aneAvailable := m.ANEResidency || capabilities.EnergyModel
+	socMetrics := headlessSocMetrics(m, capabilities)
+	output := HeadlessOutput{
+		Timestamp:    time.Now().Format(time.RFC3339),
+		SocMetrics:   socMetrics,
+		Memory:       mem,
+		NetDisk:      netDisk,
+		CPUUsage:     cpuUsagePercent,
+		GPUUsage:     optional(m.GPUActive, capabilities.GPUStats),
+		CoreUsages:   percentages,
+		SystemInfo:   sysInfo,
+		ThermalState: thermalStr,
+		CPUTemp:      socMetrics.CPUTemp,
+		GPUTemp:      socMetrics.GPUTemp,
+		GPUStats:     optional(gpuPerf, gpuPerf.Available),
+		ANEUsage:     optional(aneUtil, aneAvailable),
+		ANEMethod:    optional(aneMethod, aneAvailable),
+		Capabilities: capabilities,
+	}
+
+	return headlessSample{
+		Output:    output,
+		Soc:       m,
+		GPUPerf:   gpuPerf,
+		ANEUsage:  aneUtil,
+		ANEMethod: aneMethod,
+	}
+}
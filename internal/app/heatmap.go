@@ -0,0 +1,213 @@
+package app
+
+import (
+	"fmt"
+	"image"
+	"sort"
+
+	ui "github.com/gizak/termui/v3"
+)
+
+const (
+	CoreViewBars    = "bars"
+	CoreViewHeatmap = "heatmap"
+)
+
+// coreGroup is a run of cores of the same type on the same die
+type coreGroup struct {
+	Label   string
+	Indices []int
+}
+
+// heatmapRow is one rendered row, averaging one or more cores of a group
+type heatmapRow struct {
+	Label   string
+	Indices []int
+}
+
+// CoreHeatmapWidget draws per-core usage over time: one row per core (or per
+// bucket of cores on large chips), time on the X axis and usage as colour.
+type CoreHeatmapWidget struct {
+	*ui.Block
+	groups  []coreGroup
+	samples [][]float64
+}
+
+var (
+	heatmapPalette      = []ui.Color{236, 23, 29, 35, 71, 107, 143, 179, 173, 167, 161, 196}
+	heatmapPaletteLight = []ui.Color{255, 195, 158, 121, 192, 228, 222, 216, 210, 204, 198, 160}
+)
+
+// coreHeatmapGroups splits the topology into per-die P and E groups, ordered
+// by the first core index of each group so the rows follow the core numbering.
+func coreHeatmapGroups(topology CoreTopology, dies int) []coreGroup {
+	if dies < 1 {
+		dies = 1
+	}
+	var groups []coreGroup
+	for _, cluster := range []struct {
+		kind    string
+		indices []int
+	}{
+		{"P", topology.PCoreIndices},
+		{"E", topology.ECoreIndices},
+	} {
+		if len(cluster.indices) == 0 {
+			continue
+		}
+		indices := append([]int(nil), cluster.indices...)
+		sort.Ints(indices)
+		perDie := (len(indices) + dies - 1) / dies
+		for die := 0; die*perDie < len(indices); die++ {
+			end := min((die+1)*perDie, len(indices))
+			label := cluster.kind
+			if dies > 1 {
+				label = fmt.Sprintf("%s%d", cluster.kind, die)
+			}
+			groups = append(groups, coreGroup{Label: label, Indices: indices[die*perDie : end]})
+		}
+	}
+	sort.SliceStable(groups, func(i, j int) bool {
+		return groups[i].Indices[0] < groups[j].Indices[0]
+	})
+	return groups
+}
+
+// heatmapRows fits the groups into the available rows. When there are more
+// cores than rows, neighbouring cores of a group are averaged into buckets;
+// every group keeps at least one row so the P/E and die split stays visible.
+func heatmapRows(groups []coreGroup, available int) []heatmapRow {
+	total := 0
+	for _, g := range groups {
+		total += len(g.Indices)
+	}
+	if total == 0 || available <= 0 {
+		return nil
+	}
+
+	bucket := 1
+	for {
+		rows := 0
+		for _, g := range groups {
+			rows += (len(g.Indices) + bucket - 1) / bucket
+		}
+		if rows <= available || bucket >= total {
+			break
+		}
+		bucket++
+	}
+
+	var rows []heatmapRow
+	for _, g := range groups {
+		for start := 0; start < len(g.Indices); start += bucket {
+			end := min(start+bucket, len(g.Indices))
+			label := fmt.Sprintf("%s %d", g.Label, g.Indices[start])
+			if end-start > 1 {
+				label = fmt.Sprintf("%s %d-%d", g.Label, g.Indices[start], g.Indices[end-1])
+			}
+			rows = append(rows, heatmapRow{Label: label, Indices: g.Indices[start:end]})
+		}
+	}
+	if len(rows) > available {
+		rows = rows[:available]
+	}
+	return rows
+}
+
+func NewCoreHeatmapWidget(sysInfo SystemInfo, size int) *CoreHeatmapWidget {
+	topology := GetCoreTopology(sysInfo)
+	return &CoreHeatmapWidget{
+		Block:   ui.NewBlock(),
+		groups:  coreHeatmapGroups(topology, topology.Dies),
+		samples: make([][]float64, 0, size),
+	}
+}
+
+func (h *CoreHeatmapWidget) Push(usage []float64) {
+	sample := append([]float64(nil), usage...)
+	if len(h.samples) == cap(h.samples) {
+		copy(h.samples, h.samples[1:])
+		h.samples[len(h.samples)-1] = sample
+		return
+	}
+	h.samples = append(h.samples, sample)
+}
+
+func (h *CoreHeatmapWidget) Reset() {
+	h.samples = h.samples[:0]
+}
+
+func heatmapColor(usage float64, palette []ui.Color) ui.Color {
+	idx := int(usage / 100 * float64(len(palette)-1))
+	if idx < 0 {
+		idx = 0
+	} else if idx >= len(palette) {
+		idx = len(palette) - 1
+	}
+	return palette[idx]
+}
+
+func (h *CoreHeatmapWidget) Draw(buf *ui.Buffer) {
+	h.Block.Draw(buf)
+
+	rows := heatmapRows(h.groups, h.Inner.Dy())
+	labelWidth := 0
+	for _, row := range rows {
+		labelWidth = max(labelWidth, len(row.Label)+1)
+	}
+	width := h.Inner.Dx() - labelWidth
+	if width <= 0 {
+		return
+	}
+
+	palette := heatmapPalette
+	if IsLightMode {
+		palette = heatmapPaletteLight
+	}
+
+	samples := h.samples
+	if len(samples) > width {
+		samples = samples[len(samples)-width:]
+	}
+	// Right-align so the newest sample touches the right border
+	startX := h.Inner.Max.X - len(samples)
+
+	for r, row := range rows {
+		y := h.Inner.Min.Y + r
+		labelColor := h.BorderStyle.Fg
+		if row.Label[0] == 'E' {
+			labelColor = SecondaryTextColor
+		}
+		buf.SetString(row.Label, ui.NewStyle(labelColor), image.Pt(h.Inner.Min.X, y))
+
+		for i, sample := range samples {
+			var sum float64
+			for _, idx := range row.Indices {
+				if idx < len(sample) {
+					sum += sample[idx]
+				}
+			}
+			usage := sum / float64(len(row.Indices))
+			buf.SetCell(ui.NewCell(' ', ui.NewStyle(ui.ColorClear, heatmapColor(usage, palette))),
+				image.Pt(startX+i, y))
+		}
+	}
+}
+
+func toggleCoreView() {
+	if currentConfig.CoreView == CoreViewHeatmap {
+		currentConfig.CoreView = CoreViewBars
+	} else {
+		currentConfig.CoreView = CoreViewHeatmap
+	}
+	applyLayout(currentConfig.DefaultLayout)
+	updateHelpText()
+}
+
+// coreView returns the per-core widget selected in the config
+func coreView() ui.Drawable {
+	if currentConfig.CoreView == CoreViewHeatmap {
+		return coreHeatmap
+	}
+	return cpuCoreWidget
+}
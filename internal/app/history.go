@@ -0,0 +1,463 @@
+package app
+
+import (
+	"flag"
+	"fmt"
+	"math"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	// Raw samples are kept at one per second for historyRawRetention, and
+	// per-minute aggregates for historyRollupRetention
+	historyRawRetention    = time.Hour
+	historyRollupRetention = 30 * 24 * time.Hour
+)
+
+func defaultHistoryDB() string {
+	home, err := os.UserHomeDir()
+	if err != nil {
+		return filepath.Join(os.TempDir(), "mactop-history.db")
+	}
+	return filepath.Join(home, ".mactop", "history.db")
+}
+
+// historyMetric is a value recorded from every sample
+type historyMetric struct {
+	Key         string
+	Unit        string
+	Description string
+	value       func(HeadlessOutput) (float64, bool)
+}
+
+func historyFloat(get func(HeadlessOutput) float64) func(HeadlessOutput) (float64, bool) {
+	return func(out HeadlessOutput) (float64, bool) { return get(out), true }
+}
+
+func historyOptional[T float32 | float64](get func(HeadlessOutput) *T) func(HeadlessOutput) (float64, bool) {
+	return func(out HeadlessOutput) (float64, bool) {
+		v := get(out)
+		if v == nil {
+			return 0, false
+		}
+		return float64(*v), true
+	}
+}
+
+var historyMetrics = []historyMetric{
+	{"cpu_usage", "%", "CPU usage across all cores", historyFloat(func(out HeadlessOutput) float64 { return out.CPUUsage })},
+	{"gpu_usage", "%", "GPU active residency", historyOptional(func(out HeadlessOutput) *float64 { return out.GPUUsage })},
+	{"ane_usage", "%", "Neural Engine usage", historyOptional(func(out HeadlessOutput) *float64 { return out.ANEUsage })},
+	{"cpu_temp", "°C", "CPU temperature", historyOptional(func(out HeadlessOutput) *float32 { return out.CPUTemp })},
+	{"gpu_temp", "°C", "GPU temperature", historyOptional(func(out HeadlessOutput) *float32 { return out.GPUTemp })},
+	{"cpu_power", "W", "CPU power", historyOptional(func(out HeadlessOutput) *float64 { return out.SocMetrics.CPUPower })},
+	{"gpu_power", "W", "GPU power", historyOptional(func(out HeadlessOutput) *float64 { return out.SocMetrics.GPUPower })},
+	{"package_power", "W", "CPU, GPU, ANE and DRAM power", func(out HeadlessOutput) (float64, bool) {
+		soc := out.SocMetrics
+		if soc.CPUPower == nil {
+			return 0, false
+		}
+		return deref(soc.CPUPower) + deref(soc.GPUPower) + deref(soc.ANEPower) + deref(soc.DRAMPower) + deref(soc.GPUSRAMPower), true
+	}},
+	{"system_power", "W", "Whole system power from PSTR, only where available", historyOptional(headlessSystemPower)},
+	{"thermal_state", "", "Thermal pressure: 0 Nominal, 1 Moderate, 2 Heavy, 3 Critical", func(out HeadlessOutput) (float64, bool) {
+		for i, name := range thermalStateNames {
+			if name == out.ThermalState {
+				return float64(i), true
+			}
+		}
+		return 0, false
+	}},
+	{"memory_used", "GiB", "Memory in use", historyFloat(func(out HeadlessOutput) float64 { return float64(out.Memory.Used) / bytesPerGiB })},
+	{"swap_used", "GiB", "Swap in use", historyFloat(func(out HeadlessOutput) float64 { return float64(out.Memory.SwapUsed) / bytesPerGiB })},
+	{"network_in", "B/s", "Network receive rate", historyFloat(func(out HeadlessOutput) float64 { return out.NetDisk.InBytesPerSec })},
+	{"network_out", "B/s", "Network send rate", historyFloat(func(out HeadlessOutput) float64 { return out.NetDisk.OutBytesPerSec })},
+	{"disk_read", "KiB/s", "Disk read rate", historyFloat(func(out HeadlessOutput) float64 { return out.NetDisk.ReadKBytesPerSec })},
+	{"disk_write", "KiB/s", "Disk write rate", historyFloat(func(out HeadlessOutput) float64 { return out.NetDisk.WriteKBytesPerSec })},
+}
+
+func lookupHistoryMetric(key string) (historyMetric, bool) {
+	for _, m := range historyMetrics {
+		if m.Key == key {
+			return m, true
+		}
+	}
+	return historyMetric{}, false
+}
+
+// historyBucket aggregates the samples of a metric from Time (Unix seconds)
+// on. A raw sample is a bucket of one.
+type historyBucket struct {
+	Time  int64
+	Count int64
+	Sum   float64
+	Min   float64
+	Max   float64
+}
+
+func (b *historyBucket) add(v float64) {
+	if b.Count == 0 || v < b.Min {
+		b.Min = v
+	}
+	if b.Count == 0 || v > b.Max {
+		b.Max = v
+	}
+	b.Count++
+	b.Sum += v
+}
+
+func (b historyBucket) mean() float64 {
+	if b.Count == 0 {
+		return 0
+	}
+	return b.Sum / float64(b.Count)
+}
+
+// historyStore persists raw samples and per-minute rollups. Times are Unix
+// seconds, ranges include from and exclude to.
+type historyStore interface {
+	insertSamples(ts int64, values map[string]float64) error
+	upsertRollups(buckets map[string]historyBucket) error
+	prune(rawBefore, rollupsBefore int64) error
+	oldestSample(metric string) (int64, bool, error)
+	samples(metric string, from, to int64) ([]historyBucket, error)
+	rollups(metric string, from, to int64) ([]historyBucket, error)
+	insertMarker(ts int64, label string) error
+	markers(from, to int64) ([]timelineMarker, error)
+	Close() error
+}
+
+// historyRecorder writes samples to a store, downsampling them to one raw
+// sample per second and a rollup per minute
+type historyRecorder struct {
+	mu      sync.Mutex
+	store   historyStore
+	minute  int64
+	buckets map[string]historyBucket
+	lastRaw int64
+	failing bool
+}
+
+func newHistoryRecorder(store historyStore) *historyRecorder {
+	return &historyRecorder{store: store, buckets: make(map[string]historyBucket)}
+}
+
+func (r *historyRecorder) record(out HeadlessOutput, now time.Time) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if r.store == nil {
+		return
+	}
+	err := r.recordLocked(out, now)
+	if err != nil && !r.failing {
+		stderrLogger.Printf("Recording history failed: %v\n", err)
+	} else if err == nil && r.failing {
+		stderrLogger.Printf("Recording history recovered\n")
+	}
+	r.failing = err != nil
+}
+
+func (r *historyRecorder) recordLocked(out HeadlessOutput, now time.Time) error {
+	sec := now.Unix()
+	minute := sec - sec%60
+	if minute != r.minute && len(r.buckets) > 0 {
+		if err := r.flushLocked(); err != nil {
+			return err
+		}
+		if err := r.store.prune(sec-int64(historyRawRetention/time.Second), sec-int64(historyRollupRetention/time.Second)); err != nil {
+			return err
+		}
+	}
+	r.minute = minute
+
+	for _, m := range out.Markers {
+		if err := r.store.insertMarker(m.Time.Unix(), m.Label); err != nil {
+			return err
+		}
+	}
+	values := make(map[string]float64, len(historyMetrics))
+	for _, m := range historyMetrics {
+		v, ok := m.value(out)
+		if !ok || math.IsNaN(v) || math.IsInf(v, 0) {
+			continue
+		}
+		values[m.Key] = v
+		b := r.buckets[m.Key]
+		b.Time = minute
+		b.add(v)
+		r.buckets[m.Key] = b
+	}
+	if sec == r.lastRaw {
+		return nil
+	}
+	r.lastRaw = sec
+	return r.store.insertSamples(sec, values)
+}
+
+// flushLocked writes the current minute's rollups. A partial minute written
+// on exit is merged with the rest of that minute by the store.
+func (r *historyRecorder) flushLocked() error {
+	if len(r.buckets) == 0 {
+		return nil
+	}
+	err := r.store.upsertRollups(r.buckets)
+	r.buckets = make(map[string]historyBucket)
+	return err
+}
+
+func (r *historyRecorder) Close() error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if r.store == nil {
+		return nil
+	}
+	err := r.flushLocked()
+	if cerr := r.store.Close(); err == nil {
+		err = cerr
+	}
+	r.store = nil
+	return err
+}
+
+// startHistoryRecorder opens the database, creating it and its directory on
+// first use
+func startHistoryRecorder(path string) (*historyRecorder, error) {
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		return nil, err
+	}
+	store, err := openSQLiteHistory(path)
+	if err != nil {
+		return nil, err
+	}
+	return newHistoryRecorder(store), nil
+}
+
+// historyPoints returns a metric between from and to, as rollups for the
+// minutes whose raw samples have been pruned and raw samples after that
+func historyPoints(store historyStore, metric string, from, to int64) ([]historyBucket, error) {
+	cutoff := to
+	oldest, ok, err := store.oldestSample(metric)
+	if err != nil {
+		return nil, err
+	}
+	if ok {
+		// The minute the oldest raw sample is in may be partly pruned
+		cutoff = oldest - oldest%60
+		if cutoff < oldest {
+			cutoff += 60
+		}
+	}
+	points, err := store.rollups(metric, from, min(cutoff, to))
+	if err != nil {
+		return nil, err
+	}
+	raw, err := store.samples(metric, max64(from, cutoff), to)
+	if err != nil {
+		return nil, err
+	}
+	return append(points, raw...), nil
+}
+
+// aggregateHistory reduces points to one value. Percentiles are weighted by
+// sample count; for rollups they are taken over the minute means, so spikes
+// shorter than a minute only show in the last hour or with max.
+func aggregateHistory(points []historyBucket, agg string) (float64, error) {
+	if len(points) == 0 {
+		return 0, fmt.Errorf("no samples")
+	}
+	switch agg {
+	case "avg", "mean":
+		var sum float64
+		var count int64
+		for _, p := range points {
+			sum += p.Sum
+			count += p.Count
+		}
+		return sum / float64(count), nil
+	case "min":
+		v := points[0].Min
+		for _, p := range points {
+			v = math.Min(v, p.Min)
+		}
+		return v, nil
+	case "max":
+		v := points[0].Max
+		for _, p := range points {
+			v = math.Max(v, p.Max)
+		}
+		return v, nil
+	case "last":
+		return points[len(points)-1].mean(), nil
+	}
+	q, err := strconv.ParseFloat(strings.TrimPrefix(agg, "p"), 64)
+	if !strings.HasPrefix(agg, "p") || err != nil || q < 0 || q > 100 {
+		return 0, fmt.Errorf("unknown aggregation %q, want avg, min, max, last or a percentile such as p95", agg)
+	}
+	sorted := append([]historyBucket(nil), points...)
+	sort.Slice(sorted, func(i, j int) bool { return sorted[i].mean() < sorted[j].mean() })
+	var total int64
+	for _, p := range sorted {
+		total += p.Count
+	}
+	rank := int64(math.Ceil(q / 100 * float64(total)))
+	var seen int64
+	for _, p := range sorted {
+		seen += p.Count
+		if seen >= rank {
+			return p.mean(), nil
+		}
+	}
+	return sorted[len(sorted)-1].mean(), nil
+}
+
+// historyWindow is the points of one --step interval
+type historyWindow struct {
+	Start  int64
+	Points []historyBucket
+}
+
+// stepHistory splits points into windows of step seconds aligned to from,
+// leaving out windows without samples
+func stepHistory(points []historyBucket, from, step int64) []historyWindow {
+	var windows []historyWindow
+	for _, p := range points {
+		start := from + (p.Time-from)/step*step
+		if len(windows) == 0 || windows[len(windows)-1].Start != start {
+			windows = append(windows, historyWindow{Start: start})
+		}
+		windows[len(windows)-1].Points = append(windows[len(windows)-1].Points, p)
+	}
+	return windows
+}
+
+func formatHistoryValue(metric historyMetric, v float64) string {
+	s := strconv.FormatFloat(v, 'f', 2, 64)
+	if metric.Key == "thermal_state" {
+		return s + " (" + thermalStateName(int(math.Round(v))) + ")"
+	}
+	if metric.Unit != "" {
+		s += " " + metric.Unit
+	}
+	return s
+}
+
+// historyQuery is a `mactop history` invocation
+type historyQuery struct {
+	DB     string
+	Since  string
+	Until  string
+	Metric string
+	Agg    string
+	Step   time.Duration
+}
+
+// parseHistoryArgs parses the flags of the history subcommand, which has a
+// flag set of its own so the TUI and headless mode don't accept them
+func parseHistoryArgs(args []string) (historyQuery, error) {
+	var q historyQuery
+	fs := flag.NewFlagSet("mactop history", flag.ContinueOnError)
+	fs.StringVar(&q.DB, "history-db", defaultHistoryDB(), "History database file")
+	fs.StringVar(&q.Since, "since", "1h", "Start of the history range")
+	fs.StringVar(&q.Until, "until", "", "End of the history range (default: now)")
+	fs.StringVar(&q.Metric, "metric", "", "Metric to query from the history")
+	fs.StringVar(&q.Agg, "agg", "avg", "History aggregation: avg, min, max, last or a percentile such as p95")
+	fs.DurationVar(&q.Step, "step", 0, "Aggregate the history per interval")
+	if err := fs.Parse(args); err != nil {
+		return historyQuery{}, err
+	}
+	if fs.NArg() > 0 {
+		return historyQuery{}, fmt.Errorf("unexpected argument %q", fs.Arg(0))
+	}
+	return q, nil
+}
+
+// runHistory prints the recorded values of a metric, or the metrics that can
+// be queried when none is given
+func runHistory(q historyQuery, now time.Time) error {
+	if q.Metric == "" {
+		fmt.Println("Recorded metrics (use --metric):")
+		for _, m := range historyMetrics {
+			fmt.Printf("  %-14s %-6s %s\n", m.Key, m.Unit, m.Description)
+		}
+		return nil
+	}
+	metric, ok := lookupHistoryMetric(q.Metric)
+	if !ok {
+		return fmt.Errorf("unknown metric %q, run mactop history without --metric for the list", q.Metric)
+	}
+	if q.Step != 0 && q.Step < time.Second {
+		return fmt.Errorf("--step must be at least 1s")
+	}
+	from, err := parseSince(q.Since, now)
+	if err != nil {
+		return err
+	}
+	to := now
+	if q.Until != "" {
+		if to, err = parseSince(q.Until, now); err != nil {
+			return err
+		}
+	}
+	if _, err := os.Stat(q.DB); err != nil {
+		return fmt.Errorf("no history at %s, record some with --record: %w", q.DB, err)
+	}
+	store, err := openSQLiteHistory(q.DB)
+	if err != nil {
+		return err
+	}
+	defer store.Close()
+
+	points, err := historyPoints(store, metric.Key, from.Unix(), to.Unix()+1)
+	if err != nil {
+		return err
+	}
+	if len(points) == 0 {
+		return fmt.Errorf("no %s samples between %s and %s", metric.Key, from.Format(time.DateTime), to.Format(time.DateTime))
+	}
+	markers, err := store.markers(from.Unix(), to.Unix()+1)
+	if err != nil {
+		return err
+	}
+	if q.Step > 0 {
+		step := int64(q.Step / time.Second)
+		for _, window := range stepHistory(points, from.Unix(), step) {
+			v, err := aggregateHistory(window.Points, q.Agg)
+			if err != nil {
+				return err
+			}
+			line := fmt.Sprintf("%s  %s", time.Unix(window.Start, 0).Format("2006-01-02 15:04:05"), formatHistoryValue(metric, v))
+			// Markers dropped during the window
+			var labels []string
+			for _, m := range markers {
+				if ts := m.Time.Unix(); ts >= window.Start && ts < window.Start+step {
+					labels = append(labels, m.Label)
+				}
+			}
+			if len(labels) > 0 {
+				line += "  <- " + strings.Join(labels, ", ")
+			}
+			fmt.Println(line)
+		}
+		return nil
+	}
+	v, err := aggregateHistory(points, q.Agg)
+	if err != nil {
+		return err
+	}
+	var count int64
+	for _, p := range points {
+		count += p.Count
+	}
+	fmt.Printf("%s %s from %s to %s: %s (%d samples)\n", metric.Key, q.Agg,
+		from.Format("2006-01-02 15:04"), to.Format("2006-01-02 15:04"), formatHistoryValue(metric, v), count)
+	for _, m := range markers {
+		fmt.Printf("  marker %s  %s\n", m.Time.Format("2006-01-02 15:04:05"), m.Label)
+	}
+	return nil
+}
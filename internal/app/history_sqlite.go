@@ -0,0 +1,316 @@
+package app
+
+/*
+#cgo LDFLAGS: -lsqlite3
+#include <sqlite3.h>
+#include <stdlib.h>
+
+// SQLITE_TRANSIENT is a function pointer cast cgo can't express
+static int mactop_bind_text(sqlite3_stmt *stmt, int idx, const char *text, int n) {
+	return sqlite3_bind_text(stmt, idx, text, n, SQLITE_TRANSIENT);
+}
+*/
+import "C"
+
+import (
+	"fmt"
+	"time"
+	"unsafe"
+)
+
+const historySchema = `
+PRAGMA journal_mode = WAL;
+CREATE TABLE IF NOT EXISTS samples (
+	metric TEXT NOT NULL,
+	ts INTEGER NOT NULL,
+	value REAL NOT NULL,
+	PRIMARY KEY (metric, ts)
+) WITHOUT ROWID;
+CREATE TABLE IF NOT EXISTS rollups (
+	metric TEXT NOT NULL,
+	ts INTEGER NOT NULL,
+	count INTEGER NOT NULL,
+	sum REAL NOT NULL,
+	min REAL NOT NULL,
+	max REAL NOT NULL,
+	PRIMARY KEY (metric, ts)
+) WITHOUT ROWID;
+CREATE TABLE IF NOT EXISTS markers (
+	ts INTEGER NOT NULL,
+	label TEXT NOT NULL
+);
+CREATE INDEX IF NOT EXISTS markers_ts ON markers (ts);
+`
+
+type sqliteDB struct {
+	db *C.sqlite3
+}
+
+func (d *sqliteDB) err(rc C.int) error {
+	return fmt.Errorf("sqlite: %s (%d)", C.GoString(C.sqlite3_errmsg(d.db)), int(rc))
+}
+
+func openSQLite(path string) (*sqliteDB, error) {
+	cpath := C.CString(path)
+	defer C.free(unsafe.Pointer(cpath))
+	d := &sqliteDB{}
+	rc := C.sqlite3_open_v2(cpath, &d.db, C.SQLITE_OPEN_READWRITE|C.SQLITE_OPEN_CREATE|C.SQLITE_OPEN_FULLMUTEX, nil)
+	if rc != C.SQLITE_OK {
+		err := d.err(rc)
+		C.sqlite3_close(d.db)
+		return nil, err
+	}
+	// The recorder and a history query may use the database at once
+	C.sqlite3_busy_timeout(d.db, 5000)
+	return d, nil
+}
+
+func (d *sqliteDB) exec(sql string) error {
+	csql := C.CString(sql)
+	defer C.free(unsafe.Pointer(csql))
+	var errmsg *C.char
+	if rc := C.sqlite3_exec(d.db, csql, nil, nil, &errmsg); rc != C.SQLITE_OK {
+		defer C.sqlite3_free(unsafe.Pointer(errmsg))
+		return fmt.Errorf("sqlite: %s", C.GoString(errmsg))
+	}
+	return nil
+}
+
+func (d *sqliteDB) Close() error {
+	if rc := C.sqlite3_close(d.db); rc != C.SQLITE_OK {
+		return d.err(rc)
+	}
+	return nil
+}
+
+type sqliteStmt struct {
+	d    *sqliteDB
+	stmt *C.sqlite3_stmt
+}
+
+func (d *sqliteDB) prepare(sql string) (*sqliteStmt, error) {
+	csql := C.CString(sql)
+	defer C.free(unsafe.Pointer(csql))
+	s := &sqliteStmt{d: d}
+	if rc := C.sqlite3_prepare_v2(d.db, csql, -1, &s.stmt, nil); rc != C.SQLITE_OK {
+		return nil, d.err(rc)
+	}
+	return s, nil
+}
+
+// bind resets the statement and binds args, which may be int64, float64 or
+// string
+func (s *sqliteStmt) bind(args ...any) error {
+	C.sqlite3_reset(s.stmt)
+	C.sqlite3_clear_bindings(s.stmt)
+	for i, arg := range args {
+		idx := C.int(i + 1)
+		var rc C.int
+		switch v := arg.(type) {
+		case int64:
+			rc = C.sqlite3_bind_int64(s.stmt, idx, C.sqlite3_int64(v))
+		case float64:
+			rc = C.sqlite3_bind_double(s.stmt, idx, C.double(v))
+		case string:
+			cs := C.CString(v)
+			rc = C.mactop_bind_text(s.stmt, idx, cs, C.int(len(v)))
+			C.free(unsafe.Pointer(cs))
+		default:
+			return fmt.Errorf("sqlite: cannot bind %T", arg)
+		}
+		if rc != C.SQLITE_OK {
+			return s.d.err(rc)
+		}
+	}
+	return nil
+}
+
+// step advances to the next row, returning false when there are no more
+func (s *sqliteStmt) step() (bool, error) {
+	switch rc := C.sqlite3_step(s.stmt); rc {
+	case C.SQLITE_ROW:
+		return true, nil
+	case C.SQLITE_DONE:
+		return false, nil
+	default:
+		return false, s.d.err(rc)
+	}
+}
+
+func (s *sqliteStmt) exec(args ...any) error {
+	if err := s.bind(args...); err != nil {
+		return err
+	}
+	_, err := s.step()
+	return err
+}
+
+func (s *sqliteStmt) int64(col int) int64 {
+	return int64(C.sqlite3_column_int64(s.stmt, C.int(col)))
+}
+
+func (s *sqliteStmt) float64(col int) float64 {
+	return float64(C.sqlite3_column_double(s.stmt, C.int(col)))
+}
+
+func (s *sqliteStmt) text(col int) string {
+	return C.GoString((*C.char)(unsafe.Pointer(C.sqlite3_column_text(s.stmt, C.int(col)))))
+}
+
+func (s *sqliteStmt) close() {
+	C.sqlite3_finalize(s.stmt)
+}
+
+// sqliteHistory is the historyStore in ~/.mactop/history.db
+type sqliteHistory struct {
+	db *sqliteDB
+}
+
+func openSQLiteHistory(path string) (historyStore, error) {
+	db, err := openSQLite(path)
+	if err != nil {
+		return nil, err
+	}
+	if err := db.exec(historySchema); err != nil {
+		db.Close()
+		return nil, fmt.Errorf("creating history tables: %w", err)
+	}
+	return &sqliteHistory{db: db}, nil
+}
+
+// inTx runs the statement for every row of args in one transaction
+func (h *sqliteHistory) inTx(sql string, rows [][]any) (err error) {
+	if err := h.db.exec("BEGIN"); err != nil {
+		return err
+	}
+	defer func() {
+		if err != nil {
+			h.db.exec("ROLLBACK")
+			return
+		}
+		err = h.db.exec("COMMIT")
+	}()
+	stmt, err := h.db.prepare(sql)
+	if err != nil {
+		return err
+	}
+	defer stmt.close()
+	for _, args := range rows {
+		if err := stmt.exec(args...); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (h *sqliteHistory) insertSamples(ts int64, values map[string]float64) error {
+	rows := make([][]any, 0, len(values))
+	for metric, v := range values {
+		rows = append(rows, []any{metric, ts, v})
+	}
+	return h.inTx("INSERT OR REPLACE INTO samples (metric, ts, value) VALUES (?, ?, ?)", rows)
+}
+
+func (h *sqliteHistory) upsertRollups(buckets map[string]historyBucket) error {
+	rows := make([][]any, 0, len(buckets))
+	for metric, b := range buckets {
+		rows = append(rows, []any{metric, b.Time, b.Count, b.Sum, b.Min, b.Max})
+	}
+	return h.inTx(`INSERT INTO rollups (metric, ts, count, sum, min, max) VALUES (?, ?, ?, ?, ?, ?)
+		ON CONFLICT (metric, ts) DO UPDATE SET
+			count = count + excluded.count,
+			sum = sum + excluded.sum,
+			min = MIN(min, excluded.min),
+			max = MAX(max, excluded.max)`, rows)
+}
+
+func (h *sqliteHistory) prune(rawBefore, rollupsBefore int64) error {
+	if err := h.inTx("DELETE FROM samples WHERE ts < ?", [][]any{{rawBefore}}); err != nil {
+		return err
+	}
+	if err := h.inTx("DELETE FROM rollups WHERE ts < ?", [][]any{{rollupsBefore}}); err != nil {
+		return err
+	}
+	return h.inTx("DELETE FROM markers WHERE ts < ?", [][]any{{rollupsBefore}})
+}
+
+func (h *sqliteHistory) insertMarker(ts int64, label string) error {
+	return h.inTx("INSERT INTO markers (ts, label) VALUES (?, ?)", [][]any{{ts, label}})
+}
+
+func (h *sqliteHistory) oldestSample(metric string) (int64, bool, error) {
+	stmt, err := h.db.prepare("SELECT MIN(ts), COUNT(*) FROM samples WHERE metric = ?")
+	if err != nil {
+		return 0, false, err
+	}
+	defer stmt.close()
+	if err := stmt.bind(metric); err != nil {
+		return 0, false, err
+	}
+	if _, err := stmt.step(); err != nil {
+		return 0, false, err
+	}
+	return stmt.int64(0), stmt.int64(1) > 0, nil
+}
+
+func (h *sqliteHistory) query(sql, metric string, from, to int64) ([]historyBucket, error) {
+	stmt, err := h.db.prepare(sql)
+	if err != nil {
+		return nil, err
+	}
+	defer stmt.close()
+	if err := stmt.bind(metric, from, to); err != nil {
+		return nil, err
+	}
+	var buckets []historyBucket
+	for {
+		ok, err := stmt.step()
+		if err != nil {
+			return nil, err
+		}
+		if !ok {
+			return buckets, nil
+		}
+		buckets = append(buckets, historyBucket{
+			Time:  stmt.int64(0),
+			Count: stmt.int64(1),
+			Sum:   stmt.float64(2),
+			Min:   stmt.float64(3),
+			Max:   stmt.float64(4),
+		})
+	}
+}
+
+func (h *sqliteHistory) samples(metric string, from, to int64) ([]historyBucket, error) {
+	return h.query("SELECT ts, 1, value, value, value FROM samples WHERE metric = ? AND ts >= ? AND ts < ? ORDER BY ts", metric, from, to)
+}
+
+func (h *sqliteHistory) rollups(metric string, from, to int64) ([]historyBucket, error) {
+	return h.query("SELECT ts, count, sum, min, max FROM rollups WHERE metric = ? AND ts >= ? AND ts < ? ORDER BY ts", metric, from, to)
+}
+
+func (h *sqliteHistory) markers(from, to int64) ([]timelineMarker, error) {
+	stmt, err := h.db.prepare("SELECT ts, label FROM markers WHERE ts >= ? AND ts < ? ORDER BY ts")
+	if err != nil {
+		return nil, err
+	}
+	defer stmt.close()
+	if err := stmt.bind(from, to); err != nil {
+		return nil, err
+	}
+	var markers []timelineMarker
+	for {
+		ok, err := stmt.step()
+		if err != nil {
+			return nil, err
+		}
+		if !ok {
+			return markers, nil
+		}
+		markers = append(markers, timelineMarker{Time: time.Unix(stmt.int64(0), 0), Label: stmt.text(1)})
+	}
+}
+
+func (h *sqliteHistory) Close() error {
+	return h.db.Close()
+}
@@ -0,0 +1,89 @@
+package app
+
+import (
+	"path/filepath"
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestSQLiteHistory(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "history.db")
+	store, err := openSQLiteHistory(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer func() { store.Close() }()
+	must := func(err error) {
+		t.Helper()
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	for ts := int64(1000); ts < 1010; ts++ {
+		must(store.insertSamples(ts, map[string]float64{"cpu_usage": float64(ts - 1000), "gpu_usage": 50}))
+	}
+	// A sample written twice for the same second keeps the last value
+	must(store.insertSamples(1009, map[string]float64{"cpu_usage": 90}))
+
+	// Ranges include from and exclude to
+	samples, err := store.samples("cpu_usage", 1003, 1006)
+	must(err)
+	want := []historyBucket{
+		{Time: 1003, Count: 1, Sum: 3, Min: 3, Max: 3},
+		{Time: 1004, Count: 1, Sum: 4, Min: 4, Max: 4},
+		{Time: 1005, Count: 1, Sum: 5, Min: 5, Max: 5},
+	}
+	if !reflect.DeepEqual(samples, want) {
+		t.Errorf("samples [1003, 1006) = %+v, want %+v", samples, want)
+	}
+	if samples, _ := store.samples("cpu_usage", 1009, 1010); len(samples) != 1 || samples[0].Sum != 90 {
+		t.Errorf("rewritten sample = %+v, want 90", samples)
+	}
+	if oldest, ok, err := store.oldestSample("cpu_usage"); err != nil || !ok || oldest != 1000 {
+		t.Errorf("oldestSample(cpu_usage) = %d, %v, %v", oldest, ok, err)
+	}
+	if _, ok, err := store.oldestSample("swap_used"); err != nil || ok {
+		t.Errorf("oldestSample(swap_used) = %v, %v, want none", ok, err)
+	}
+
+	// Flushing the same minute again merges into the stored bucket
+	must(store.upsertRollups(map[string]historyBucket{"cpu_usage": {Time: 960, Count: 2, Sum: 10, Min: 4, Max: 6}}))
+	must(store.upsertRollups(map[string]historyBucket{"cpu_usage": {Time: 960, Count: 1, Sum: 9, Min: 9, Max: 9}}))
+	must(store.upsertRollups(map[string]historyBucket{"cpu_usage": {Time: 1020, Count: 1, Sum: 1, Min: 1, Max: 1}}))
+	rollups, err := store.rollups("cpu_usage", 960, 1020)
+	must(err)
+	if want := []historyBucket{{Time: 960, Count: 3, Sum: 19, Min: 4, Max: 9}}; !reflect.DeepEqual(rollups, want) {
+		t.Errorf("rollups [960, 1020) = %+v, want %+v", rollups, want)
+	}
+
+	must(store.insertMarker(900, "warmup"))
+	must(store.insertMarker(1005, "run 1"))
+	markers, err := store.markers(900, 1005)
+	must(err)
+	if want := []timelineMarker{{Time: time.Unix(900, 0), Label: "warmup"}}; !reflect.DeepEqual(markers, want) {
+		t.Errorf("markers [900, 1005) = %+v, want %+v", markers, want)
+	}
+
+	// Raw samples and rollups are pruned separately, markers with the rollups
+	must(store.prune(1005, 1000))
+	if samples, _ := store.samples("cpu_usage", 0, 2000); len(samples) != 5 || samples[0].Time != 1005 {
+		t.Errorf("samples after prune = %+v, want 1005 to 1009", samples)
+	}
+	if rollups, _ := store.rollups("cpu_usage", 0, 2000); len(rollups) != 1 || rollups[0].Time != 1020 {
+		t.Errorf("rollups after prune = %+v, want only 1020", rollups)
+	}
+	if markers, _ := store.markers(0, 2000); len(markers) != 1 || markers[0].Label != "run 1" {
+		t.Errorf("markers after prune = %+v, want only run 1", markers)
+	}
+
+	// The data survives reopening the file
+	must(store.Close())
+	if store, err = openSQLiteHistory(path); err != nil {
+		t.Fatal(err)
+	}
+	if samples, _ := store.samples("gpu_usage", 0, 2000); len(samples) != 5 {
+		t.Errorf("%d gpu_usage samples after reopening, want 5", len(samples))
+	}
+}
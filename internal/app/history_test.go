@@ -0,0 +1,291 @@
+package app
+
+import (
+	"math"
+	"reflect"
+	"sort"
+	"testing"
+	"time"
+)
+
+// memHistory is a historyStore in memory, behaving like the SQLite one
+type memHistory struct {
+	raw     map[string]map[int64]float64
+	minutes map[string]map[int64]historyBucket
+	marks   []historyMarker
+	closed  bool
+}
+
+type historyMarker struct {
+	ts    int64
+	label string
+}
+
+func newMemHistory() *memHistory {
+	return &memHistory{raw: make(map[string]map[int64]float64), minutes: make(map[string]map[int64]historyBucket)}
+}
+
+func (m *memHistory) insertSamples(ts int64, values map[string]float64) error {
+	for metric, v := range values {
+		if m.raw[metric] == nil {
+			m.raw[metric] = make(map[int64]float64)
+		}
+		m.raw[metric][ts] = v
+	}
+	return nil
+}
+
+func (m *memHistory) upsertRollups(buckets map[string]historyBucket) error {
+	for metric, b := range buckets {
+		if m.minutes[metric] == nil {
+			m.minutes[metric] = make(map[int64]historyBucket)
+		}
+		if old, ok := m.minutes[metric][b.Time]; ok {
+			b.Count += old.Count
+			b.Sum += old.Sum
+			b.Min = math.Min(b.Min, old.Min)
+			b.Max = math.Max(b.Max, old.Max)
+		}
+		m.minutes[metric][b.Time] = b
+	}
+	return nil
+}
+
+func (m *memHistory) prune(rawBefore, rollupsBefore int64) error {
+	for _, samples := range m.raw {
+		for ts := range samples {
+			if ts < rawBefore {
+				delete(samples, ts)
+			}
+		}
+	}
+	for _, buckets := range m.minutes {
+		for ts := range buckets {
+			if ts < rollupsBefore {
+				delete(buckets, ts)
+			}
+		}
+	}
+	kept := m.marks[:0]
+	for _, mk := range m.marks {
+		if mk.ts >= rollupsBefore {
+			kept = append(kept, mk)
+		}
+	}
+	m.marks = kept
+	return nil
+}
+
+func (m *memHistory) oldestSample(metric string) (int64, bool, error) {
+	var oldest int64
+	found := false
+	for ts := range m.raw[metric] {
+		if !found || ts < oldest {
+			oldest, found = ts, true
+		}
+	}
+	return oldest, found, nil
+}
+
+func sortedBuckets(buckets []historyBucket) []historyBucket {
+	sort.Slice(buckets, func(i, j int) bool { return buckets[i].Time < buckets[j].Time })
+	return buckets
+}
+
+func (m *memHistory) samples(metric string, from, to int64) ([]historyBucket, error) {
+	var out []historyBucket
+	for ts, v := range m.raw[metric] {
+		if ts >= from && ts < to {
+			out = append(out, historyBucket{Time: ts, Count: 1, Sum: v, Min: v, Max: v})
+		}
+	}
+	return sortedBuckets(out), nil
+}
+
+func (m *memHistory) rollups(metric string, from, to int64) ([]historyBucket, error) {
+	var out []historyBucket
+	for ts, b := range m.minutes[metric] {
+		if ts >= from && ts < to {
+			out = append(out, b)
+		}
+	}
+	return sortedBuckets(out), nil
+}
+
+func (m *memHistory) insertMarker(ts int64, label string) error {
+	m.marks = append(m.marks, historyMarker{ts, label})
+	return nil
+}
+
+func (m *memHistory) markers(from, to int64) ([]timelineMarker, error) {
+	var out []timelineMarker
+	for _, mk := range m.marks {
+		if mk.ts >= from && mk.ts < to {
+			out = append(out, timelineMarker{Time: time.Unix(mk.ts, 0), Label: mk.label})
+		}
+	}
+	sort.SliceStable(out, func(i, j int) bool { return out[i].Time.Before(out[j].Time) })
+	return out, nil
+}
+
+func (m *memHistory) Close() error {
+	m.closed = true
+	return nil
+}
+
+func TestHistoryRecorder(t *testing.T) {
+	store := newMemHistory()
+	rec := newHistoryRecorder(store)
+	start := time.Unix(1709283600, 0) // on a minute boundary
+
+	// Two hours of samples every half second, CPU usage counting up per
+	// second and thermal state Heavy for the first minute only
+	for i := 0; i < 2*3600*2; i++ {
+		now := start.Add(time.Duration(i) * 500 * time.Millisecond)
+		state := "Nominal"
+		if i < 120 {
+			state = "Heavy"
+		}
+		rec.record(HeadlessOutput{CPUUsage: float64(i / 2), ThermalState: state}, now)
+	}
+	if err := rec.Close(); err != nil || !store.closed {
+		t.Fatalf("Close() = %v, store closed %v", err, store.closed)
+	}
+
+	end := start.Unix() + 2*3600
+	if oldest, _, _ := store.oldestSample("cpu_usage"); oldest < end-3600-60 {
+		t.Errorf("raw samples kept from %d, want only the last hour before %d", oldest, end)
+	}
+	if n := len(store.raw["cpu_usage"]); n > 3600+60 {
+		t.Errorf("kept %d raw samples, want one per second for an hour", n)
+	}
+	if _, ok := store.raw["gpu_usage"]; ok {
+		t.Error("recorded GPU usage without a reading")
+	}
+	first := store.minutes["cpu_usage"][start.Unix()]
+	if want := (historyBucket{Time: start.Unix(), Count: 120, Sum: 2 * (59 * 60 / 2), Min: 0, Max: 59}); first != want {
+		t.Errorf("first minute rollup = %+v, want %+v", first, want)
+	}
+	if n := len(store.minutes["cpu_usage"]); n != 120 {
+		t.Errorf("%d minute rollups, want 120", n)
+	}
+
+	// The whole range comes from rollups for the first hour and raw samples
+	// after that, without counting anything twice
+	points, err := historyPoints(store, "cpu_usage", start.Unix(), end)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var count int64
+	for i, p := range points {
+		count += p.Count
+		if i > 0 && p.Time <= points[i-1].Time {
+			t.Fatalf("points out of order at %d: %d after %d", i, p.Time, points[i-1].Time)
+		}
+	}
+	if count != 2*3600*2-int64(len(store.raw["cpu_usage"])) {
+		t.Errorf("counted %d samples from rollups and %d raw", count, len(store.raw["cpu_usage"]))
+	}
+	if v, _ := aggregateHistory(points, "max"); v != 2*3600-1 {
+		t.Errorf("max cpu_usage = %v", v)
+	}
+
+	// Was it throttling yesterday afternoon?
+	thermal, _ := historyPoints(store, "thermal_state", start.Unix(), start.Unix()+300)
+	if v, _ := aggregateHistory(thermal, "max"); v != 2 {
+		t.Errorf("max thermal_state over the first 5 minutes = %v, want 2 (Heavy)", v)
+	}
+}
+
+func TestAggregateHistory(t *testing.T) {
+	// 100 raw samples 1..100 and a minute of 60 samples averaging 200
+	var points []historyBucket
+	for i := 1; i <= 100; i++ {
+		points = append(points, historyBucket{Time: int64(i), Count: 1, Sum: float64(i), Min: float64(i), Max: float64(i)})
+	}
+	withRollup := append([]historyBucket{{Time: 0, Count: 60, Sum: 60 * 200, Min: 150, Max: 250}}, points...)
+
+	tests := []struct {
+		agg    string
+		points []historyBucket
+		want   float64
+	}{
+		{"avg", points, 50.5},
+		{"min", points, 1},
+		{"max", points, 100},
+		{"last", points, 100},
+		{"p50", points, 50},
+		{"p95", points, 95},
+		{"p100", points, 100},
+		{"max", withRollup, 250},
+		{"avg", withRollup, (5050 + 12000) / 160.0},
+		{"p50", withRollup, 80},
+		{"p95", withRollup, 200},
+	}
+	for _, tt := range tests {
+		got, err := aggregateHistory(tt.points, tt.agg)
+		if err != nil || got != tt.want {
+			t.Errorf("%s over %d points = %v, %v; want %v", tt.agg, len(tt.points), got, err, tt.want)
+		}
+	}
+	for _, agg := range []string{"median", "p101", "px"} {
+		if _, err := aggregateHistory(points, agg); err == nil {
+			t.Errorf("aggregation %q accepted", agg)
+		}
+	}
+	if _, err := aggregateHistory(nil, "avg"); err == nil {
+		t.Error("aggregating no points succeeded")
+	}
+}
+
+func TestStepHistory(t *testing.T) {
+	points := []historyBucket{{Time: 100}, {Time: 130}, {Time: 165}, {Time: 400}}
+	var got [][]int64
+	for _, w := range stepHistory(points, 100, 60) {
+		times := []int64{w.Start}
+		for _, p := range w.Points {
+			times = append(times, p.Time)
+		}
+		got = append(got, times)
+	}
+	if want := [][]int64{{100, 100, 130}, {160, 165}, {400, 400}}; !reflect.DeepEqual(got, want) {
+		t.Errorf("windows = %v, want %v", got, want)
+	}
+}
+
+func TestParseHistoryArgs(t *testing.T) {
+	q, err := parseHistoryArgs([]string{"--since", "2h", "--metric", "package_power", "--agg=p95", "--step", "5m", "--history-db", "/tmp/h.db"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := historyQuery{DB: "/tmp/h.db", Since: "2h", Metric: "package_power", Agg: "p95", Step: 5 * time.Minute}
+	if q != want {
+		t.Errorf("parseHistoryArgs() = %+v, want %+v", q, want)
+	}
+
+	for _, args := range [][]string{{"--interval", "500"}, {"--headless"}, {"package_power"}} {
+		if _, err := parseHistoryArgs(args); err == nil {
+			t.Errorf("parseHistoryArgs(%q) accepted flags history doesn't take", args)
+		}
+	}
+}
+
+func TestHistorySystemPower(t *testing.T) {
+	metric, _ := lookupHistoryMetric("system_power")
+	out := HeadlessOutput{
+		SocMetrics: HeadlessSocMetrics{
+			CPUPower:    optional(4.0, true),
+			SystemPower: optional(6.5, true),
+			TotalPower:  optional(14.0, true),
+		},
+		Capabilities: SocCapabilities{EnergyModel: true, SystemPower: true},
+	}
+	if v, ok := metric.value(out); !ok || v != 14 {
+		t.Errorf("system_power with PSTR = %v, %v, want 14", v, ok)
+	}
+	out.Capabilities.SystemPower = false
+	out.SocMetrics.SystemPower = nil
+	if v, ok := metric.value(out); ok {
+		t.Errorf("system_power without PSTR = %v, want none", v)
+	}
+}
@@ -0,0 +1,65 @@
+package app
+
+import (
+	"strconv"
+	"sync/atomic"
+	"time"
+)
+
+// Bounds of the update interval for the +/- keys and the control socket
+const (
+	minUpdateInterval = 100
+	maxUpdateInterval = 5000
+)
+
+// msInterval is an interval in milliseconds that the collectors read while
+// the keyboard and the control socket change it. It is a flag.Value so
+// --interval can set it directly.
+type msInterval struct {
+	ms atomic.Int64
+}
+
+func newMSInterval(ms int) *msInterval {
+	i := &msInterval{}
+	i.Store(ms)
+	return i
+}
+
+func (i *msInterval) Load() int {
+	return int(i.ms.Load())
+}
+
+func (i *msInterval) Store(ms int) {
+	i.ms.Store(int64(ms))
+}
+
+func (i *msInterval) Duration() time.Duration {
+	return time.Duration(i.ms.Load()) * time.Millisecond
+}
+
+// Adjust moves the interval by delta, kept within the allowed bounds
+func (i *msInterval) Adjust(delta int) {
+	for {
+		old := i.ms.Load()
+		next := min(max64(old+int64(delta), minUpdateInterval), maxUpdateInterval)
+		if i.ms.CompareAndSwap(old, next) {
+			return
+		}
+	}
+}
+
+func (i *msInterval) String() string {
+	if i == nil {
+		return "0"
+	}
+	return strconv.Itoa(i.Load())
+}
+
+func (i *msInterval) Set(s string) error {
+	ms, err := strconv.Atoi(s)
+	if err != nil {
+		return err
+	}
+	i.Store(ms)
+	return nil
+}
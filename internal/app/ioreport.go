@@ -13,6 +13,7 @@ package app
 #include <stdint.h>
 #include <string.h>
 #include <stdlib.h>
+#include <sys/sysctl.h>
 
 typedef struct IOReportSubscriptionRef* IOReportSubscriptionRef;
 
@@ -53,15 +54,33 @@ typedef struct {
     float socTemp;
     float cpuTemp;
     float gpuTemp;
+    double aneActive;
+    int aneActiveValid;
 } PowerMetrics;
 
 int initIOReport();
 PowerMetrics samplePowerMetrics(int durationMs);
 void cleanupIOReport();
 int getThermalState();
+typedef struct {
+    int energyModel;
+    int gpuStats;
+    int smcTempKeys;
+    int hidSensors;
+    int pstr;
+} SocCapabilities;
+SocCapabilities getSocCapabilities();
+char *copyGPUPerformanceStatistics();
+int getGPUCoreCount();
 */
 import "C"
 
+import (
+	"encoding/json"
+	"fmt"
+	"unsafe"
+)
+
 type SocMetrics struct {
 	CPUPower     float64 `json:"cpu_power"`
 	GPUPower     float64 `json:"gpu_power"`
@@ -75,11 +94,13 @@ type SocMetrics struct {
 	SocTemp      float32 `json:"soc_temp"`
 	CPUTemp      float32 `json:"cpu_temp"`
 	GPUTemp      float32 `json:"gpu_temp"`
+	ANEActive    float64 `json:"-"`
+	ANEResidency bool    `json:"-"`
 }
 
 func initSocMetrics() error {
 	if ret := C.initIOReport(); ret != 0 {
-		return nil
+		return fmt.Errorf("IOReport subscription failed (code %d)", int(ret))
 	}
 	return nil
 }
@@ -99,6 +120,8 @@ func sampleSocMetrics(durationMs int) SocMetrics {
 		SocTemp:      float32(pm.socTemp),
 		CPUTemp:      float32(pm.cpuTemp),
 		GPUTemp:      float32(pm.gpuTemp),
+		ANEActive:    float64(pm.aneActive),
+		ANEResidency: pm.aneActiveValid != 0,
 	}
 }
 
@@ -106,6 +129,58 @@ func cleanupSocMetrics() {
 	C.cleanupIOReport()
 }
 
+// getSocCapabilities reports which metric sources initialised
+func getSocCapabilities() SocCapabilities {
+	caps := C.getSocCapabilities()
+	return SocCapabilities{
+		EnergyModel: caps.energyModel != 0,
+		GPUStats:    caps.gpuStats != 0,
+		SMCTempKeys: int(caps.smcTempKeys),
+		HIDSensors:  int(caps.hidSensors),
+		SystemPower: caps.pstr != 0,
+	}
+}
+
 func getSocThermalState() int {
 	return int(C.getThermalState())
 }
+
+// getGPUPerformanceStatistics reads the raw PerformanceStatistics property of
+// the AGX accelerator from the IORegistry
+func getGPUPerformanceStatistics() (map[string]any, error) {
+	raw := C.copyGPUPerformanceStatistics()
+	if raw == nil {
+		return nil, fmt.Errorf("AGXAccelerator PerformanceStatistics not available")
+	}
+	defer C.free(unsafe.Pointer(raw))
+
+	var stats map[string]any
+	if err := json.Unmarshal([]byte(C.GoString(raw)), &stats); err != nil {
+		return nil, fmt.Errorf("failed to decode PerformanceStatistics: %v", err)
+	}
+	return stats, nil
+}
+
+// sysctlRaw returns the raw value of a sysctl via sysctlbyname(3)
+func sysctlRaw(name string) ([]byte, error) {
+	cname := C.CString(name)
+	defer C.free(unsafe.Pointer(cname))
+
+	var size C.size_t
+	if ret, err := C.sysctlbyname(cname, nil, &size, nil, 0); ret != 0 {
+		return nil, fmt.Errorf("sysctl %s: %v", name, err)
+	}
+	if size == 0 {
+		return nil, nil
+	}
+	buf := make([]byte, size)
+	if ret, err := C.sysctlbyname(cname, unsafe.Pointer(&buf[0]), &size, nil, 0); ret != 0 {
+		return nil, fmt.Errorf("sysctl %s: %v", name, err)
+	}
+	return buf[:size], nil
+}
+
+// getGPUCoreCount reads gpu-core-count from the AGX accelerator, 0 if unknown
+func getGPUCoreCount() int {
+	return int(C.getGPUCoreCount())
+}
@@ -64,9 +64,15 @@ extern double IOHIDEventGetFloatValue(IOHIDEventRef event, int64_t field);
 #define kHIDUsage_AppleVendor_TemperatureSensor 0x0005
 #define kIOHIDEventTypeTemperature 15
 
+// Where the Neural Engine's performance state channels are published
+#define ANE_STATS_GROUP "SoC Stats"
+#define ANE_STATS_SUBGROUP "Device Power States"
+
 static IOReportSubscriptionRef g_subscription = NULL;
 static CFMutableDictionaryRef g_channels = NULL;
 static io_connect_t g_smcConn = 0;
+static int g_has_energy = 0;
+static int g_has_gpu_stats = 0;
 static uint32_t g_gpu_freqs[64];
 static int g_gpu_freq_count = 0;
 
@@ -224,6 +230,14 @@ int initIOReport() {
     return 0;
   }
 
+  // SMC and HID don't depend on IOReport, bring them up first so
+  // temperatures and PSTR keep working if the subscription fails
+  if (!g_smcConn) {
+    g_smcConn = SMCOpen();
+    loadSMCTempKeys();
+  }
+  initHIDClient();
+
   CFStringRef energyGroup = CFSTR("Energy Model");
   CFStringRef gpuGroup = CFSTR("GPU Stats");
   CFStringRef cpuGroup = CFSTR("CPU Stats");
@@ -232,16 +246,32 @@ int initIOReport() {
       IOReportCopyChannelsInGroup(energyGroup, NULL, 0, 0, 0);
   CFDictionaryRef gpuChan =
       IOReportCopyChannelsInGroup(gpuGroup, NULL, 0, 0, 0);
+  // ANE performance state residency, not published on every chip. Only its
+  // subgroup, the rest of SoC Stats would add to the cost of every sample.
+  CFDictionaryRef socChan =
+      IOReportCopyChannelsInGroup(CFSTR(ANE_STATS_GROUP),
+                                  CFSTR(ANE_STATS_SUBGROUP), 0, 0, 0);
 
   if (energyChan == NULL) {
+    if (gpuChan != NULL)
+      CFRelease(gpuChan);
+    if (socChan != NULL)
+      CFRelease(socChan);
     return -1;
   }
+  g_has_energy = 1;
+  g_has_gpu_stats = gpuChan != NULL;
 
   if (gpuChan != NULL) {
     IOReportMergeChannels(energyChan, gpuChan, NULL);
     CFRelease(gpuChan);
   }
 
+  if (socChan != NULL) {
+    IOReportMergeChannels(energyChan, socChan, NULL);
+    CFRelease(socChan);
+  }
+
   CFIndex size = CFDictionaryGetCount(energyChan);
   g_channels =
       CFDictionaryCreateMutableCopy(kCFAllocatorDefault, size, energyChan);
@@ -263,12 +293,6 @@ int initIOReport() {
 
   loadGpuFrequencies();
 
-  g_smcConn = SMCOpen();
-  loadSMCTempKeys();
-
-  // Pre-initialize the HID client for temperature fallback
-  initHIDClient();
-
   return 0;
 }
 
@@ -284,6 +308,8 @@ typedef struct {
   float socTemp;
   float cpuTemp;
   float gpuTemp;
+  double aneActive;
+  int aneActiveValid;
 } PowerMetrics;
 
 static int cfStringMatch(CFStringRef str, const char *match) {
@@ -483,11 +509,38 @@ static float readSocTemperature(float *outCpuTemp, float *outGpuTemp) {
   return (*outCpuTemp > *outGpuTemp) ? *outCpuTemp : *outGpuTemp;
 }
 
+// Fills in the SMC/HID backed fields: temperatures and PSTR system power
+static void readSensors(PowerMetrics *metrics) {
+  // === Temperature: use cache if fresh (< 9.5s), else re-read ===
+  uint64_t now = currentTimeMs();
+  if (g_temp_cache_time_ms > 0 && (now - g_temp_cache_time_ms) < TEMP_CACHE_TTL_MS) {
+    metrics->cpuTemp = g_cached_cpu_temp;
+    metrics->gpuTemp = g_cached_gpu_temp;
+    metrics->socTemp = g_cached_soc_temp;
+  } else {
+    metrics->socTemp = readSocTemperature(&metrics->cpuTemp, &metrics->gpuTemp);
+    g_cached_cpu_temp = metrics->cpuTemp;
+    g_cached_gpu_temp = metrics->gpuTemp;
+    g_cached_soc_temp = metrics->socTemp;
+    g_temp_cache_time_ms = now;
+  }
+
+  // System power: direct read every cycle (single SMC key, negligible cost)
+  if (g_smcConn) {
+    metrics->systemPower = SMCGetFloatValue(g_smcConn, "PSTR");
+  }
+}
+
 PowerMetrics samplePowerMetrics(int durationMs) {
-  PowerMetrics metrics = {0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0};
+  PowerMetrics metrics = {0};
+  int64_t aneTotalTime = 0;
+  int64_t aneActiveTime = 0;
 
   if (g_subscription == NULL || g_channels == NULL) {
     if (initIOReport() != 0) {
+      // No power data, but SMC/HID sensors may still be readable
+      usleep(durationMs * 1000);
+      readSensors(&metrics);
       return metrics;
     }
   }
@@ -584,27 +637,33 @@ PowerMetrics samplePowerMetrics(int durationMs) {
           }
         }
       }
+    } else if (cfStringMatch(groupRef, ANE_STATS_GROUP) &&
+               cfStringStartsWith(channelRef, "ANE")) {
+      // State channels of the Neural Engine, e.g. "ANE0"
+      CFStringRef subgroupRef = IOReportChannelGetSubGroup(item);
+      if (subgroupRef == NULL ||
+          !cfStringMatch(subgroupRef, ANE_STATS_SUBGROUP))
+        continue;
+      int32_t stateCount = IOReportStateGetCount(item);
+      for (int32_t s = 0; s < stateCount; s++) {
+        int64_t residency = IOReportStateGetResidency(item, s);
+        CFStringRef stateName = IOReportStateGetNameForIndex(item, s);
+        aneTotalTime += residency;
+        if (stateName != NULL && !cfStringMatch(stateName, "OFF") &&
+            !cfStringMatch(stateName, "IDLE") &&
+            !cfStringMatch(stateName, "DOWN")) {
+          aneActiveTime += residency;
+        }
+      }
     }
   }
 
-  // === Temperature: use cache if fresh (< 9.5s), else re-read ===
-  uint64_t now = currentTimeMs();
-  if (g_temp_cache_time_ms > 0 && (now - g_temp_cache_time_ms) < TEMP_CACHE_TTL_MS) {
-    metrics.cpuTemp = g_cached_cpu_temp;
-    metrics.gpuTemp = g_cached_gpu_temp;
-    metrics.socTemp = g_cached_soc_temp;
-  } else {
-    metrics.socTemp = readSocTemperature(&metrics.cpuTemp, &metrics.gpuTemp);
-    g_cached_cpu_temp = metrics.cpuTemp;
-    g_cached_gpu_temp = metrics.gpuTemp;
-    g_cached_soc_temp = metrics.socTemp;
-    g_temp_cache_time_ms = now;
+  if (aneTotalTime > 0) {
+    metrics.aneActive = (double)aneActiveTime / (double)aneTotalTime * 100.0;
+    metrics.aneActiveValid = 1;
   }
 
-  // System power: direct read every cycle (single SMC key, negligible cost)
-  if (g_smcConn) {
-    metrics.systemPower = SMCGetFloatValue(g_smcConn, "PSTR");
-  }
+  readSensors(&metrics);
 
   CFRelease(delta);
 
@@ -632,7 +691,100 @@ void cleanupIOReport() {
   }
 }
 
+typedef struct {
+  int energyModel;
+  int gpuStats;
+  int smcTempKeys;
+  int hidSensors;
+  int pstr;
+} SocCapabilities;
+
+// Reports which metric sources initialised. Call after initIOReport.
+SocCapabilities getSocCapabilities() {
+  SocCapabilities caps = {0};
+  caps.energyModel = g_subscription != NULL && g_has_energy;
+  caps.gpuStats = g_subscription != NULL && g_has_gpu_stats;
+  caps.smcTempKeys = g_smcConn ? g_cpu_key_count + g_gpu_key_count : 0;
+  if (g_hidServices != NULL)
+    caps.hidSensors = (int)CFArrayGetCount(g_hidServices);
+  if (g_smcConn) {
+    SMCKeyData_keyInfo_t keyInfo;
+    caps.pstr = SMCGetKeyInfo(g_smcConn, "PSTR", &keyInfo) == kIOReturnSuccess;
+  }
+  return caps;
+}
+
 int getThermalState() {
   NSProcessInfo *info = [NSProcessInfo processInfo];
   return (int)[info thermalState];
 }
+
+// Serialises the PerformanceStatistics dictionary of the AGX accelerator to
+// JSON so it can be parsed on the Go side. Only number and string values are
+// kept. The caller owns the returned buffer and must free() it.
+char *copyGPUPerformanceStatistics() {
+  char *result = NULL;
+
+  io_iterator_t iterator;
+  CFMutableDictionaryRef matching = IOServiceMatching("AGXAccelerator");
+  if (IOServiceGetMatchingServices(kIOMainPortDefault, matching, &iterator) !=
+      kIOReturnSuccess)
+    return NULL;
+
+  io_object_t entry;
+  while (result == NULL && (entry = IOIteratorNext(iterator)) != 0) {
+    CFTypeRef stats = IORegistryEntryCreateCFProperty(
+        entry, CFSTR("PerformanceStatistics"), kCFAllocatorDefault, 0);
+    if (stats != NULL && CFGetTypeID(stats) == CFDictionaryGetTypeID()) {
+      @autoreleasepool {
+        NSDictionary *dict = (__bridge NSDictionary *)stats;
+        NSMutableDictionary *clean = [NSMutableDictionary dictionary];
+        for (id key in dict) {
+          id value = dict[key];
+          if ([key isKindOfClass:[NSString class]] &&
+              ([value isKindOfClass:[NSNumber class]] ||
+               [value isKindOfClass:[NSString class]])) {
+            clean[key] = value;
+          }
+        }
+        NSData *data = [NSJSONSerialization dataWithJSONObject:clean
+                                                       options:0
+                                                         error:nil];
+        if (data != nil) {
+          result = malloc([data length] + 1);
+          memcpy(result, [data bytes], [data length]);
+          result[[data length]] = '\0';
+        }
+      }
+    }
+    if (stats != NULL)
+      CFRelease(stats);
+    IOObjectRelease(entry);
+  }
+  IOObjectRelease(iterator);
+  return result;
+}
+
+int getGPUCoreCount() {
+  int cores = 0;
+
+  io_iterator_t iterator;
+  CFMutableDictionaryRef matching = IOServiceMatching("AGXAccelerator");
+  if (IOServiceGetMatchingServices(kIOMainPortDefault, matching, &iterator) !=
+      kIOReturnSuccess)
+    return 0;
+
+  io_object_t entry;
+  while (cores == 0 && (entry = IOIteratorNext(iterator)) != 0) {
+    CFTypeRef value = IORegistryEntryCreateCFProperty(
+        entry, CFSTR("gpu-core-count"), kCFAllocatorDefault, 0);
+    if (value != NULL) {
+      if (CFGetTypeID(value) == CFNumberGetTypeID())
+        CFNumberGetValue((CFNumberRef)value, kCFNumberIntType, &cores);
+      CFRelease(value);
+    }
+    IOObjectRelease(entry);
+  }
+  IOObjectRelease(iterator);
+  return cores;
+}
@@ -12,9 +12,11 @@ const (
 	LayoutCompact         = "compact"
 	LayoutDashboard       = "dashboard"
 	LayoutGaugesOnly      = "gauges_only"
+	LayoutNetwork         = "network"
+	LayoutThermal         = "thermal"
 )
 
-var layoutOrder = []string{LayoutDefault, LayoutAlternative, LayoutAlternativeFull, LayoutVertical, LayoutCompact, LayoutDashboard, LayoutGaugesOnly}
+var layoutOrder = []string{LayoutDefault, LayoutAlternative, LayoutAlternativeFull, LayoutVertical, LayoutCompact, LayoutDashboard, LayoutGaugesOnly, LayoutNetwork, LayoutThermal}
 
 func setupGrid() {
 	applyLayout(currentConfig.DefaultLayout)
@@ -29,8 +31,12 @@ func cycleLayout() {
 		}
 	}
 	nextIndex := (currentIndex + 1) % len(layoutOrder)
-	currentConfig.DefaultLayout = layoutOrder[nextIndex]
-	applyLayout(currentConfig.DefaultLayout)
+	setLayout(layoutOrder[nextIndex])
+}
+
+func setLayout(layoutName string) {
+	currentConfig.DefaultLayout = layoutName
+	applyLayout(layoutName)
 	updateHelpText()
 }
 
@@ -42,7 +48,7 @@ func applyLayout(layoutName string) {
 	case LayoutAlternative:
 		grid.Set(
 			ui.NewRow(1.0/2,
-				ui.NewCol(1.0/2, cpuCoreWidget),
+				ui.NewCol(1.0/2, coreView()),
 				ui.NewCol(1.0/2,
 					ui.NewRow(1.0/2, gpuGauge),
 					ui.NewCol(1.0, ui.NewRow(1.0, memoryGauge)),
@@ -61,7 +67,7 @@ func applyLayout(layoutName string) {
 	case LayoutAlternativeFull:
 		grid.Set(
 			ui.NewRow(1.0/4,
-				ui.NewCol(1.0, cpuCoreWidget),
+				ui.NewCol(1.0, coreView()),
 			),
 			ui.NewRow(1.0/4,
 				ui.NewCol(1.0/2, gpuGauge),
@@ -126,7 +132,11 @@ func applyLayout(layoutName string) {
 				ui.NewCol(1.0/2, sparklineGroup),
 				ui.NewCol(1.0/2, gpuSparklineGroup),
 			),
-			ui.NewRow(2.0/4,
+			ui.NewRow(1.0/4,
+				ui.NewCol(1.0/2, netSparklineGroup),
+				ui.NewCol(1.0/2, diskSparklineGroup),
+			),
+			ui.NewRow(1.0/4,
 				ui.NewCol(1.0, processList),
 			),
 		)
@@ -145,6 +155,41 @@ func applyLayout(layoutName string) {
 				ui.NewCol(1.0/2, sparklineGroup),
 			),
 		)
+	case LayoutNetwork:
+		grid.Set(
+			ui.NewRow(1.0/4,
+				ui.NewCol(1.0/2, cpuGauge),
+				ui.NewCol(1.0/2, gpuGauge),
+			),
+			ui.NewRow(1.0/4,
+				ui.NewCol(1.0/3, NetworkInfo),
+				ui.NewCol(2.0/3, NetDeviceInfo),
+			),
+			ui.NewRow(1.0/4,
+				ui.NewCol(1.0/2, netSparklineGroup),
+				ui.NewCol(1.0/2, diskSparklineGroup),
+			),
+			ui.NewRow(1.0/4,
+				ui.NewCol(1.0, processList),
+			),
+		)
+	case LayoutThermal:
+		grid.Set(
+			ui.NewRow(1.0/4,
+				ui.NewCol(1.0/2, cpuGauge),
+				ui.NewCol(1.0/2, gpuGauge),
+			),
+			ui.NewRow(2.0/4,
+				ui.NewCol(2.0/3, tempChart),
+				ui.NewCol(1.0/3,
+					ui.NewRow(1.0/2, PowerChart),
+					ui.NewRow(1.0/2, sparklineGroup),
+				),
+			),
+			ui.NewRow(1.0/4,
+				ui.NewCol(1.0, processList),
+			),
+		)
 	default: // LayoutDefault
 		grid.Set(
 			ui.NewRow(1.0/4,
@@ -0,0 +1,139 @@
+package app
+
+import (
+	"fmt"
+	"os"
+	"sync"
+	"syscall"
+)
+
+const (
+	logMaxBytes = 10 << 20
+	logBackups  = 3
+)
+
+// rotatingLog is an append-only log file that moves to path.1, path.2, ...
+// once it grows past maxBytes, keeping the given number of old files. The
+// TUI and the daemon can share one, so rotation holds a lock on path.lock
+// and a process that finds the file already rotated by another only
+// switches to the new one.
+type rotatingLog struct {
+	path     string
+	maxBytes int64
+	backups  int
+
+	mu     sync.Mutex
+	file   *os.File
+	size   int64
+	stderr bool
+}
+
+func openRotatingLog(path string, maxBytes int64, backups int) (*rotatingLog, error) {
+	l := &rotatingLog{path: path, maxBytes: maxBytes, backups: backups}
+	if err := l.openLocked(); err != nil {
+		return nil, err
+	}
+	return l, nil
+}
+
+func (l *rotatingLog) openLocked() error {
+	f, err := os.OpenFile(l.path, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0660)
+	if err != nil {
+		return fmt.Errorf("failed to open log file: %v", err)
+	}
+	info, err := f.Stat()
+	if err != nil {
+		f.Close()
+		return err
+	}
+	l.file, l.size = f, info.Size()
+	if l.stderr {
+		StderrToLogfile(f)
+	}
+	return nil
+}
+
+func (l *rotatingLog) Write(p []byte) (int, error) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if l.size > 0 && l.size+int64(len(p)) > l.maxBytes {
+		// If rotating fails keep logging to the current file, and don't
+		// retry for another maxBytes
+		if err := l.rotateLocked(); err != nil {
+			l.size = 0
+		}
+	}
+	n, err := l.file.Write(p)
+	l.size += int64(n)
+	return n, err
+}
+
+// lockFile takes an exclusive flock on path, released by closing the file
+func lockFile(path string) (*os.File, error) {
+	f, err := os.OpenFile(path, os.O_CREATE|os.O_RDWR, 0660)
+	if err != nil {
+		return nil, err
+	}
+	if err := syscall.Flock(int(f.Fd()), syscall.LOCK_EX); err != nil {
+		f.Close()
+		return nil, err
+	}
+	return f, nil
+}
+
+func (l *rotatingLog) rotateLocked() error {
+	lock, err := lockFile(l.path + ".lock")
+	if err != nil {
+		return err
+	}
+	defer lock.Close()
+	if current, err := os.Stat(l.path); err == nil {
+		if ours, err := l.file.Stat(); err == nil && !os.SameFile(current, ours) {
+			return l.reopenLocked()
+		}
+	}
+
+	for i := l.backups - 1; i >= 1; i-- {
+		os.Rename(fmt.Sprintf("%s.%d", l.path, i), fmt.Sprintf("%s.%d", l.path, i+1))
+	}
+	if l.backups > 0 {
+		if err := os.Rename(l.path, l.path+".1"); err != nil {
+			return err
+		}
+	} else if err := os.Truncate(l.path, 0); err != nil {
+		return err
+	}
+	return l.reopenLocked()
+}
+
+func (l *rotatingLog) reopenLocked() error {
+	old := l.file
+	if err := l.openLocked(); err != nil {
+		return err
+	}
+	old.Close()
+	return nil
+}
+
+// Reopen switches to a new file at the same path, for when the log has been
+// moved away by an external tool such as newsyslog
+func (l *rotatingLog) Reopen() error {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	return l.reopenLocked()
+}
+
+// RedirectStderr points file descriptor 2 at the log, now and after every
+// rotation, so output of the C libraries ends up there too
+func (l *rotatingLog) RedirectStderr() {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.stderr = true
+	StderrToLogfile(l.file)
+}
+
+func (l *rotatingLog) Close() error {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	return l.file.Close()
+}
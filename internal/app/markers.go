@@ -0,0 +1,212 @@
+package app
+
+import (
+	"image"
+	"strings"
+	"sync"
+	"time"
+
+	ui "github.com/gizak/termui/v3"
+	w "github.com/gizak/termui/v3/widgets"
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// maxMarkers bounds the markers kept for the session
+const maxMarkers = 1000
+
+// timelineMarker is a labelled point in time, such as the start of a
+// benchmark phase
+type timelineMarker struct {
+	Time  time.Time `json:"time"`
+	Label string    `json:"label"`
+}
+
+// markerTimeline holds the markers dropped this session. New markers are
+// pending until the next sample takes them into its output.
+type markerTimeline struct {
+	mu      sync.Mutex
+	markers []timelineMarker
+	pending []timelineMarker
+}
+
+var sessionMarkers markerTimeline
+
+func (t *markerTimeline) add(label string, now time.Time) timelineMarker {
+	m := timelineMarker{Time: now, Label: label}
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if len(t.markers) >= maxMarkers {
+		t.markers = append(t.markers[:0], t.markers[1:]...)
+	}
+	t.markers = append(t.markers, m)
+	if len(t.pending) < maxMarkers {
+		t.pending = append(t.pending, m)
+	}
+	return m
+}
+
+// take returns the markers added since the last call
+func (t *markerTimeline) take() []timelineMarker {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	pending := t.pending
+	t.pending = nil
+	return pending
+}
+
+func (t *markerTimeline) all() []timelineMarker {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	return append([]timelineMarker(nil), t.markers...)
+}
+
+// markCharts puts a marker at the newest sample of the power, GPU and
+// temperature charts
+func markCharts(label string) {
+	sparklineGroup.markers.add(label)
+	gpuSparklineGroup.markers.add(label)
+	tempChart.markers.add(label)
+}
+
+// chartMarker is a marker dropped age samples ago
+type chartMarker struct {
+	age   int
+	label string
+}
+
+// chartMarkers follows the markers on a chart as its samples scroll by
+type chartMarkers struct {
+	markers []chartMarker
+}
+
+func (c *chartMarkers) add(label string) {
+	c.markers = append(c.markers, chartMarker{label: label})
+}
+
+// push ages the markers by one sample, forgetting those that scrolled out
+// of the size samples a chart holds
+func (c *chartMarkers) push(size int) {
+	kept := c.markers[:0]
+	for _, m := range c.markers {
+		if m.age+1 < size {
+			kept = append(kept, chartMarker{m.age + 1, m.label})
+		}
+	}
+	c.markers = kept
+}
+
+// markerColor stands out from the chart drawn in fg
+func markerColor(fg ui.Color) ui.Color {
+	if fg == ui.ColorYellow {
+		return ui.ColorWhite
+	}
+	return ui.ColorYellow
+}
+
+// drawMarkers draws a vertical line through area at each column in xs,
+// oldest first, behind whatever the chart drew there. The labels go on the
+// bottom border, each cut short by the next marker.
+func drawMarkers(buf *ui.Buffer, area image.Rectangle, xs []int, labels []string, color ui.Color) {
+	style := ui.NewStyle(color)
+	for i, x := range xs {
+		if x < area.Min.X || x >= area.Max.X {
+			continue
+		}
+		for y := area.Min.Y; y < area.Max.Y; y++ {
+			p := image.Pt(x, y)
+			// Keep the background, such as the thermal state shading
+			if cell := buf.GetCell(p); cell.Rune == 0 || cell.Rune == ' ' {
+				cell.Rune, cell.Style.Fg = ui.VERTICAL_LINE, color
+				buf.SetCell(cell, p)
+			}
+		}
+		buf.SetCell(ui.NewCell(ui.HORIZONTAL_UP, style), image.Pt(x, area.Max.Y))
+		end := area.Max.X
+		if i+1 < len(xs) && xs[i+1] < end {
+			end = xs[i+1]
+		}
+		if width := end - x - 1; width > 0 {
+			buf.SetString(ui.TrimString(labels[i], width), style, image.Pt(x+1, area.Max.Y))
+		}
+	}
+}
+
+// MarkedSparklineGroup is a sparkline group with markers drawn over its
+// last sparkline
+type MarkedSparklineGroup struct {
+	*w.SparklineGroup
+	markers chartMarkers
+}
+
+func NewMarkedSparklineGroup(sls ...*w.Sparkline) *MarkedSparklineGroup {
+	return &MarkedSparklineGroup{SparklineGroup: w.NewSparklineGroup(sls...)}
+}
+
+func (g *MarkedSparklineGroup) Draw(buf *ui.Buffer) {
+	g.SparklineGroup.Draw(buf)
+	if len(g.markers.markers) == 0 {
+		return
+	}
+	// Sparklines draw their data from the left edge, as far as it fits
+	sl := g.Sparklines[len(g.Sparklines)-1]
+	var xs []int
+	var labels []string
+	for _, m := range g.markers.markers {
+		if i := len(sl.Data) - 1 - m.age; i >= 0 && i < g.Inner.Dx() {
+			xs = append(xs, g.Inner.Min.X+i)
+			labels = append(labels, m.label)
+		}
+	}
+	drawMarkers(buf, g.Inner, xs, labels, markerColor(sl.LineColor))
+}
+
+const (
+	// maxExportedMarkers bounds the marker series, keeping the most recently
+	// used labels
+	maxExportedMarkers = 20
+	// maxMarkerLabelLen bounds an exported label, in runes
+	maxMarkerLabelLen = 64
+)
+
+// markerTimestampDesc exports when each label's latest marker was dropped.
+// The time is the value rather than the sample timestamp, so Prometheus keeps
+// accepting it however old the marker is, and Grafana can still show the
+// markers as annotations.
+var markerTimestampDesc = prometheus.NewDesc(
+	"mactop_marker_timestamp_seconds",
+	"Unix time the latest marker with each label was dropped",
+	[]string{"label"}, nil,
+)
+
+// markerMetricLabel makes a marker label safe and short enough to export
+func markerMetricLabel(label string) string {
+	label = strings.ToValidUTF8(label, "\uFFFD")
+	if runes := []rune(label); len(runes) > maxMarkerLabelLen {
+		label = string(runes[:maxMarkerLabelLen])
+	}
+	return label
+}
+
+type markerCollector struct {
+	timeline *markerTimeline
+}
+
+func (c markerCollector) Describe(ch chan<- *prometheus.Desc) {
+	ch <- markerTimestampDesc
+}
+
+func (c markerCollector) Collect(ch chan<- prometheus.Metric) {
+	// Newest first, so the labels kept are the latest ones
+	markers := c.timeline.all()
+	latest := make(map[string]time.Time)
+	for i := len(markers) - 1; i >= 0 && len(latest) < maxExportedMarkers; i-- {
+		label := markerMetricLabel(markers[i].Label)
+		if _, ok := latest[label]; !ok {
+			latest[label] = markers[i].Time
+		}
+	}
+	for label, t := range latest {
+		ch <- prometheus.MustNewConstMetric(markerTimestampDesc, prometheus.GaugeValue,
+			float64(t.UnixNano())/float64(time.Second), label)
+	}
+}
@@ -0,0 +1,163 @@
+package app
+
+import (
+	"fmt"
+	"image"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	ui "github.com/gizak/termui/v3"
+	w "github.com/gizak/termui/v3/widgets"
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+func TestMarkerTimeline(t *testing.T) {
+	var timeline markerTimeline
+	start := time.Unix(1709283600, 0)
+	timeline.add("warmup", start)
+	timeline.add("run 1", start.Add(time.Minute))
+	if got := timeline.take(); len(got) != 2 || got[0].Label != "warmup" || got[1].Label != "run 1" {
+		t.Errorf("take() = %+v", got)
+	}
+	if got := timeline.take(); got != nil {
+		t.Errorf("second take() = %+v, want nothing new", got)
+	}
+
+	for i := 0; i < maxMarkers-1; i++ {
+		timeline.add("spam", start)
+	}
+	all := timeline.all()
+	if len(all) != maxMarkers || all[0].Label != "run 1" {
+		t.Errorf("kept %d markers starting with %q, want %d starting with the second", len(all), all[0].Label, maxMarkers)
+	}
+}
+
+func TestChartMarkers(t *testing.T) {
+	var c chartMarkers
+	c.add("a")
+	c.push(3)
+	c.add("b")
+	c.push(3)
+	if want := []chartMarker{{2, "a"}, {1, "b"}}; !reflect.DeepEqual(c.markers, want) {
+		t.Errorf("markers = %+v, want %+v", c.markers, want)
+	}
+	c.push(3)
+	if want := []chartMarker{{2, "b"}}; !reflect.DeepEqual(c.markers, want) {
+		t.Errorf("after scrolling out markers = %+v, want %+v", c.markers, want)
+	}
+}
+
+// row returns the runes of a buffer row between x0 and x1
+func row(buf *ui.Buffer, y, x0, x1 int) string {
+	var b strings.Builder
+	for x := x0; x < x1; x++ {
+		r := buf.GetCell(image.Pt(x, y)).Rune
+		if r == 0 {
+			r = ' '
+		}
+		b.WriteRune(r)
+	}
+	return b.String()
+}
+
+func TestMarkedSparklineGroupDraw(t *testing.T) {
+	sl := w.NewSparkline()
+	sl.Data = make([]float64, 20)
+	sl.MaxVal = 8
+	g := NewMarkedSparklineGroup(sl)
+	g.SetRect(0, 0, 12, 5)
+
+	// A marker 12 samples back falls at index 7, one 16 back at 3
+	g.markers.add("warmup")
+	for i := 0; i < 4; i++ {
+		g.markers.push(len(sl.Data))
+	}
+	g.markers.add("run 1")
+	for i := 0; i < 12; i++ {
+		g.markers.push(len(sl.Data))
+	}
+
+	buf := ui.NewBuffer(g.GetRect())
+	g.Draw(buf)
+	if got := row(buf, 1, 1, 11); got != "   │   │  " {
+		t.Errorf("top row = %q", got)
+	}
+	// Labels on the bottom border are cut short by the next marker or the edge
+	if got := row(buf, 4, 1, 11); got != "───┴wa…┴r…" {
+		t.Errorf("bottom border = %q", got)
+	}
+}
+
+func TestMarkerCollector(t *testing.T) {
+	var timeline markerTimeline
+	start := time.Unix(1709283600, 0)
+	timeline.add("run", start)
+	timeline.add("warmup", start.Add(time.Minute))
+	timeline.add("run", start.Add(2*time.Minute))
+
+	registry := prometheus.NewRegistry()
+	registry.MustRegister(markerCollector{&timeline})
+	gather := func() map[string]float64 {
+		t.Helper()
+		families, err := registry.Gather()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(families) != 1 || families[0].GetName() != "mactop_marker_timestamp_seconds" {
+			t.Fatalf("families = %v", families)
+		}
+		got := make(map[string]float64)
+		for _, m := range families[0].GetMetric() {
+			if m.TimestampMs != nil {
+				t.Errorf("marker %s carries an explicit timestamp", m.GetLabel()[0].GetValue())
+			}
+			got[m.GetLabel()[0].GetValue()] = m.GetGauge().GetValue()
+		}
+		return got
+	}
+	want := map[string]float64{
+		"run":    float64(start.Add(2 * time.Minute).Unix()),
+		"warmup": float64(start.Add(time.Minute).Unix()),
+	}
+	if got := gather(); !reflect.DeepEqual(got, want) {
+		t.Errorf("marker timestamps = %v, want %v", got, want)
+	}
+
+	// Long and invalid labels are cut down, and only the latest labels kept
+	timeline.add(strings.Repeat("x", 100)+"\xff", start.Add(3*time.Minute))
+	for i := 0; i < maxExportedMarkers; i++ {
+		timeline.add(fmt.Sprintf("phase %d", i), start.Add(4*time.Minute))
+	}
+	got := gather()
+	if len(got) != maxExportedMarkers {
+		t.Errorf("%d marker series, want %d", len(got), maxExportedMarkers)
+	}
+	if _, ok := got["run"]; ok {
+		t.Error("the oldest label is still exported")
+	}
+	if label := markerMetricLabel(strings.Repeat("x", 100) + "\xff"); label != strings.Repeat("x", maxMarkerLabelLen) {
+		t.Errorf("markerMetricLabel() = %q", label)
+	}
+	if label := markerMetricLabel("run \xff"); label != "run \uFFFD" {
+		t.Errorf("markerMetricLabel() = %q", label)
+	}
+}
+
+func TestHistoryRecorderMarkers(t *testing.T) {
+	store := newMemHistory()
+	rec := newHistoryRecorder(store)
+	now := time.Unix(1709283600, 0)
+	rec.record(HeadlessOutput{Markers: []timelineMarker{{now.Add(-time.Second), "warmup"}}}, now)
+	rec.record(HeadlessOutput{}, now.Add(time.Second))
+	rec.record(HeadlessOutput{Markers: []timelineMarker{{now.Add(time.Second), "run 1"}}}, now.Add(time.Second))
+
+	markers, err := store.markers(now.Unix()-60, now.Unix()+60)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(markers) != 2 || markers[0].Label != "warmup" || markers[1].Label != "run 1" || !markers[1].Time.Equal(now.Add(time.Second)) {
+		t.Errorf("markers = %+v", markers)
+	}
+}
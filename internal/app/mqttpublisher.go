@@ -0,0 +1,286 @@
+package app
+
+import (
+	"encoding/json"
+	"math"
+	"os"
+	"strconv"
+	"strings"
+)
+
+// mqttMessage is one message to publish
+type mqttMessage struct {
+	Topic   string
+	Payload []byte
+	Retain  bool
+}
+
+// mqttSensor is a metric published to <prefix>/<host>/<Key> and announced to
+// Home Assistant as a sensor
+type mqttSensor struct {
+	Key         string
+	Name        string
+	Unit        string
+	DeviceClass string
+	StateClass  string
+	Icon        string
+	Options     []string
+	value       func(HeadlessOutput) (string, bool)
+	// available reports whether the machine can provide the sensor at all,
+	// nil if every machine can
+	available func(SocCapabilities) bool
+}
+
+func mqttNumber(v float64) string {
+	return strconv.FormatFloat(math.Round(v*100)/100, 'f', -1, 64)
+}
+
+func mqttFloat(get func(HeadlessOutput) float64) func(HeadlessOutput) (string, bool) {
+	return func(out HeadlessOutput) (string, bool) { return mqttNumber(get(out)), true }
+}
+
+func mqttOptional[T float32 | float64](get func(HeadlessOutput) *T) func(HeadlessOutput) (string, bool) {
+	return func(out HeadlessOutput) (string, bool) {
+		v := get(out)
+		if v == nil {
+			return "", false
+		}
+		return mqttNumber(float64(*v)), true
+	}
+}
+
+const bytesPerGiB = 1024 * 1024 * 1024
+
+var mqttSensors = []mqttSensor{
+	{Key: "cpu_usage", Name: "CPU Usage", Unit: "%", StateClass: "measurement", Icon: "mdi:cpu-64-bit",
+		value: mqttFloat(func(out HeadlessOutput) float64 { return out.CPUUsage })},
+	{Key: "gpu_usage", Name: "GPU Usage", Unit: "%", StateClass: "measurement", Icon: "mdi:expansion-card",
+		value: mqttOptional(func(out HeadlessOutput) *float64 { return out.GPUUsage })},
+	{Key: "cpu_temperature", Name: "CPU Temperature", Unit: "°C", DeviceClass: "temperature", StateClass: "measurement",
+		value: mqttOptional(func(out HeadlessOutput) *float32 { return out.CPUTemp })},
+	{Key: "gpu_temperature", Name: "GPU Temperature", Unit: "°C", DeviceClass: "temperature", StateClass: "measurement",
+		value: mqttOptional(func(out HeadlessOutput) *float32 { return out.GPUTemp })},
+	{Key: "package_power", Name: "Package Power", Unit: "W", DeviceClass: "power", StateClass: "measurement",
+		value: func(out HeadlessOutput) (string, bool) {
+			soc := out.SocMetrics
+			if soc.CPUPower == nil {
+				return "", false
+			}
+			return mqttNumber(deref(soc.CPUPower) + deref(soc.GPUPower) + deref(soc.ANEPower) + deref(soc.DRAMPower) + deref(soc.GPUSRAMPower)), true
+		}},
+	{Key: "system_power", Name: "System Power", Unit: "W", DeviceClass: "power", StateClass: "measurement",
+		value:     mqttOptional(headlessSystemPower),
+		available: func(caps SocCapabilities) bool { return caps.SystemPower }},
+	{Key: "thermal_state", Name: "Thermal State", DeviceClass: "enum", Icon: "mdi:thermometer-alert",
+		Options: append(append([]string(nil), thermalStateNames...), "Unknown"),
+		value:   func(out HeadlessOutput) (string, bool) { return out.ThermalState, out.ThermalState != "" }},
+	{Key: "memory_used", Name: "Memory Used", Unit: "GiB", DeviceClass: "data_size", StateClass: "measurement",
+		value: mqttFloat(func(out HeadlessOutput) float64 { return float64(out.Memory.Used) / bytesPerGiB })},
+	{Key: "swap_used", Name: "Swap Used", Unit: "GiB", DeviceClass: "data_size", StateClass: "measurement",
+		value: mqttFloat(func(out HeadlessOutput) float64 { return float64(out.Memory.SwapUsed) / bytesPerGiB })},
+	{Key: "network_in", Name: "Network In", Unit: "B/s", DeviceClass: "data_rate", StateClass: "measurement",
+		value: mqttFloat(func(out HeadlessOutput) float64 { return out.NetDisk.InBytesPerSec })},
+	{Key: "network_out", Name: "Network Out", Unit: "B/s", DeviceClass: "data_rate", StateClass: "measurement",
+		value: mqttFloat(func(out HeadlessOutput) float64 { return out.NetDisk.OutBytesPerSec })},
+	{Key: "disk_read", Name: "Disk Read", Unit: "KiB/s", DeviceClass: "data_rate", StateClass: "measurement",
+		value: mqttFloat(func(out HeadlessOutput) float64 { return out.NetDisk.ReadKBytesPerSec })},
+	{Key: "disk_write", Name: "Disk Write", Unit: "KiB/s", DeviceClass: "data_rate", StateClass: "measurement",
+		value: mqttFloat(func(out HeadlessOutput) float64 { return out.NetDisk.WriteKBytesPerSec })},
+}
+
+// Home Assistant MQTT discovery payloads
+type (
+	haDevice struct {
+		Identifiers  []string `json:"identifiers"`
+		Name         string   `json:"name"`
+		Model        string   `json:"model,omitempty"`
+		Manufacturer string   `json:"manufacturer"`
+		SWVersion    string   `json:"sw_version"`
+	}
+	haSensorConfig struct {
+		Name              string   `json:"name"`
+		UniqueID          string   `json:"unique_id"`
+		StateTopic        string   `json:"state_topic"`
+		AvailabilityTopic string   `json:"availability_topic"`
+		Unit              string   `json:"unit_of_measurement,omitempty"`
+		DeviceClass       string   `json:"device_class,omitempty"`
+		StateClass        string   `json:"state_class,omitempty"`
+		Icon              string   `json:"icon,omitempty"`
+		Options           []string `json:"options,omitempty"`
+		Device            haDevice `json:"device"`
+	}
+)
+
+// mqttTopicName makes a host name safe to use as a topic level
+func mqttTopicName(host string) string {
+	host = strings.TrimSuffix(host, ".local")
+	return strings.NewReplacer("/", "_", "+", "_", "#", "_", " ", "_").Replace(host)
+}
+
+// mqttNodeID reduces a host name to the characters Home Assistant allows in
+// discovery topics and unique IDs
+func mqttNodeID(host string) string {
+	return strings.Map(func(r rune) rune {
+		if r >= 'a' && r <= 'z' || r >= 'A' && r <= 'Z' || r >= '0' && r <= '9' || r == '_' || r == '-' {
+			return r
+		}
+		return '_'
+	}, strings.TrimSuffix(host, ".local"))
+}
+
+// mqttPublisher publishes samples to an MQTT broker off the sampling loop,
+// dropping samples while a publish is still in flight
+type mqttPublisher struct {
+	cfg     mqttConfig
+	host    string
+	nodeID  string
+	client  *mqttClient
+	samples chan HeadlessOutput
+	done    chan struct{}
+	stopped chan struct{}
+	failing bool
+}
+
+func newMQTTPublisher(cfg mqttConfig, host string) (*mqttPublisher, error) {
+	p := &mqttPublisher{
+		cfg:     cfg,
+		host:    mqttTopicName(host),
+		nodeID:  mqttNodeID(host),
+		samples: make(chan HeadlessOutput, 1),
+		done:    make(chan struct{}),
+		stopped: make(chan struct{}),
+	}
+	client, err := newMQTTClient(cfg, "mactop-"+p.nodeID, mqttWill{Topic: p.topic("status"), Payload: "offline"})
+	if err != nil {
+		return nil, err
+	}
+	p.client = client
+	return p, nil
+}
+
+func (p *mqttPublisher) topic(key string) string {
+	return p.cfg.TopicPrefix + "/" + p.host + "/" + key
+}
+
+// announce returns the retained messages sent on every new session: the
+// availability and, unless disabled, a discovery config per sensor the
+// machine can provide
+func (p *mqttPublisher) announce(sysInfo SystemInfo, caps SocCapabilities) []mqttMessage {
+	messages := []mqttMessage{{Topic: p.topic("status"), Payload: []byte("online"), Retain: true}}
+	if p.cfg.DiscoveryPrefix == "" {
+		return messages
+	}
+	device := haDevice{
+		Identifiers:  []string{"mactop_" + p.nodeID},
+		Name:         p.host,
+		Model:        sysInfo.Name,
+		Manufacturer: "Apple",
+		SWVersion:    "mactop " + version,
+	}
+	for _, s := range mqttSensors {
+		if s.available != nil && !s.available(caps) {
+			continue
+		}
+		payload, err := json.Marshal(haSensorConfig{
+			Name:              s.Name,
+			UniqueID:          "mactop_" + p.nodeID + "_" + s.Key,
+			StateTopic:        p.topic(s.Key),
+			AvailabilityTopic: p.topic("status"),
+			Unit:              s.Unit,
+			DeviceClass:       s.DeviceClass,
+			StateClass:        s.StateClass,
+			Icon:              s.Icon,
+			Options:           s.Options,
+			Device:            device,
+		})
+		if err != nil {
+			continue
+		}
+		messages = append(messages, mqttMessage{
+			Topic:   p.cfg.DiscoveryPrefix + "/sensor/mactop_" + p.nodeID + "/" + s.Key + "/config",
+			Payload: payload,
+			Retain:  true,
+		})
+	}
+	return messages
+}
+
+// states returns the state message of every sensor with a reading
+func (p *mqttPublisher) states(out HeadlessOutput) []mqttMessage {
+	var messages []mqttMessage
+	for _, s := range mqttSensors {
+		if value, ok := s.value(out); ok {
+			messages = append(messages, mqttMessage{Topic: p.topic(s.Key), Payload: []byte(value)})
+		}
+	}
+	return messages
+}
+
+func (p *mqttPublisher) send(out HeadlessOutput) error {
+	fresh, err := p.client.ensureConnected()
+	if err != nil {
+		return err
+	}
+	messages := p.states(out)
+	if fresh {
+		messages = append(p.announce(out.SystemInfo, out.Capabilities), messages...)
+	}
+	for _, m := range messages {
+		if err := p.client.publish(m.Topic, m.Payload, m.Retain); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// offer hands a sample to the publisher without blocking
+func (p *mqttPublisher) offer(out HeadlessOutput) {
+	select {
+	case p.samples <- out:
+	default:
+	}
+}
+
+// run publishes offered samples until stop is called. Failures are logged
+// once until publishing recovers.
+func (p *mqttPublisher) run() {
+	defer close(p.stopped)
+	for {
+		select {
+		case <-p.done:
+			p.client.publish(p.topic("status"), []byte("offline"), true)
+			p.client.Close()
+			return
+		case out := <-p.samples:
+			err := p.send(out)
+			if err != nil && !p.failing {
+				stderrLogger.Printf("MQTT publish to %s failed: %v\n", p.client.addr, err)
+			} else if err == nil && p.failing {
+				stderrLogger.Printf("MQTT publish to %s recovered\n", p.client.addr)
+			}
+			p.failing = err != nil
+		}
+	}
+}
+
+// stop marks this host offline and disconnects
+func (p *mqttPublisher) stop() {
+	close(p.done)
+	<-p.stopped
+}
+
+// startMQTTPublisher publishes samples to the configured broker in the
+// background
+func startMQTTPublisher(cfg mqttConfig) (*mqttPublisher, error) {
+	host := remoteHost
+	if host == "" {
+		host, _ = os.Hostname()
+	}
+	p, err := newMQTTPublisher(cfg, host)
+	if err != nil {
+		return nil, err
+	}
+	go p.run()
+	stderrLogger.Printf("Publishing samples to MQTT broker %s under %s\n", p.client.addr, p.topic("#"))
+	return p, nil
+}
@@ -0,0 +1,214 @@
+package app
+
+import (
+	"fmt"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/shirou/gopsutil/v4/disk"
+	"github.com/shirou/gopsutil/v4/net"
+)
+
+// DeviceFilter selects network interfaces or disk devices by name using
+// shell glob patterns such as "en*" or "utun*". Exclusions win over inclusions
+// and an empty include list matches everything.
+type DeviceFilter struct {
+	Include []string
+	Exclude []string
+}
+
+func (f DeviceFilter) Allows(name string) bool {
+	for _, pattern := range f.Exclude {
+		if ok, _ := filepath.Match(pattern, name); ok {
+			return false
+		}
+	}
+	if len(f.Include) == 0 {
+		return true
+	}
+	for _, pattern := range f.Include {
+		if ok, _ := filepath.Match(pattern, name); ok {
+			return true
+		}
+	}
+	return false
+}
+
+// parseDeviceList splits a comma separated list of patterns, e.g. "lo0,utun*"
+func parseDeviceList(list string) []string {
+	var patterns []string
+	for _, p := range strings.Split(list, ",") {
+		if p = strings.TrimSpace(p); p != "" {
+			patterns = append(patterns, p)
+		}
+	}
+	return patterns
+}
+
+// setupDeviceFilters builds the network and disk filters from the config file,
+// letting any non-empty command line list replace the configured one.
+func setupDeviceFilters(netInclude, netExclude, diskInclude, diskExclude string) {
+	networkFilter = DeviceFilter{Include: currentConfig.NetworkInclude, Exclude: currentConfig.NetworkExclude}
+	diskFilter = DeviceFilter{Include: currentConfig.DiskInclude, Exclude: currentConfig.DiskExclude}
+	if netInclude != "" {
+		networkFilter.Include = parseDeviceList(netInclude)
+	}
+	if netExclude != "" {
+		networkFilter.Exclude = parseDeviceList(netExclude)
+	}
+	if diskInclude != "" {
+		diskFilter.Include = parseDeviceList(diskInclude)
+	}
+	if diskExclude != "" {
+		diskFilter.Exclude = parseDeviceList(diskExclude)
+	}
+}
+
+// counterRate returns the per-second rate between two counter readings,
+// treating a counter that went backwards (interface reset) as idle.
+func counterRate(current, last uint64, elapsed float64) float64 {
+	if current < last || elapsed <= 0 {
+		return 0
+	}
+	return float64(current-last) / elapsed
+}
+
+func computeInterfaceRates(last map[string]net.IOCountersStat, current []net.IOCountersStat, elapsed float64, filter DeviceFilter) []InterfaceMetrics {
+	interfaces := make([]InterfaceMetrics, 0, len(current))
+	for _, stat := range current {
+		if !filter.Allows(stat.Name) {
+			continue
+		}
+		iface := InterfaceMetrics{Name: stat.Name}
+		if prev, ok := last[stat.Name]; ok {
+			iface.InBytesPerSec = counterRate(stat.BytesRecv, prev.BytesRecv, elapsed)
+			iface.OutBytesPerSec = counterRate(stat.BytesSent, prev.BytesSent, elapsed)
+			iface.InPacketsPerSec = counterRate(stat.PacketsRecv, prev.PacketsRecv, elapsed)
+			iface.OutPacketsPerSec = counterRate(stat.PacketsSent, prev.PacketsSent, elapsed)
+		}
+		interfaces = append(interfaces, iface)
+	}
+	sort.Slice(interfaces, func(i, j int) bool {
+		return interfaces[i].Name < interfaces[j].Name
+	})
+	return interfaces
+}
+
+func computeDiskRates(last, current map[string]disk.IOCountersStat, elapsed float64, filter DeviceFilter) []DiskDeviceMetrics {
+	disks := make([]DiskDeviceMetrics, 0, len(current))
+	for name, stat := range current {
+		if !filter.Allows(name) {
+			continue
+		}
+		d := DiskDeviceMetrics{Name: name}
+		if prev, ok := last[name]; ok {
+			d.ReadKBytesPerSec = counterRate(stat.ReadBytes, prev.ReadBytes, elapsed) / 1024
+			d.WriteKBytesPerSec = counterRate(stat.WriteBytes, prev.WriteBytes, elapsed) / 1024
+			d.ReadOpsPerSec = counterRate(stat.ReadCount, prev.ReadCount, elapsed)
+			d.WriteOpsPerSec = counterRate(stat.WriteCount, prev.WriteCount, elapsed)
+		}
+		disks = append(disks, d)
+	}
+	sort.Slice(disks, func(i, j int) bool {
+		return disks[i].Name < disks[j].Name
+	})
+	return disks
+}
+
+func getNetDiskMetrics() NetDiskMetrics {
+	var metrics NetDiskMetrics
+
+	netDiskMutex.Lock()
+	defer netDiskMutex.Unlock()
+
+	now := time.Now()
+	elapsed := now.Sub(lastNetDiskTime).Seconds()
+	if elapsed <= 0 {
+		elapsed = 1
+	}
+
+	netStats, err := net.IOCounters(true)
+	if err == nil {
+		metrics.Interfaces = computeInterfaceRates(lastNetStats, netStats, elapsed, networkFilter)
+		for _, iface := range metrics.Interfaces {
+			metrics.InBytesPerSec += iface.InBytesPerSec
+			metrics.OutBytesPerSec += iface.OutBytesPerSec
+			metrics.InPacketsPerSec += iface.InPacketsPerSec
+			metrics.OutPacketsPerSec += iface.OutPacketsPerSec
+		}
+		lastNetStats = make(map[string]net.IOCountersStat, len(netStats))
+		for _, stat := range netStats {
+			lastNetStats[stat.Name] = stat
+		}
+	}
+
+	diskStats, err := disk.IOCounters()
+	if err == nil {
+		metrics.Disks = computeDiskRates(lastDiskStats, diskStats, elapsed, diskFilter)
+		for _, d := range metrics.Disks {
+			metrics.ReadKBytesPerSec += d.ReadKBytesPerSec
+			metrics.WriteKBytesPerSec += d.WriteKBytesPerSec
+			metrics.ReadOpsPerSec += d.ReadOpsPerSec
+			metrics.WriteOpsPerSec += d.WriteOpsPerSec
+		}
+		lastDiskStats = diskStats
+	}
+
+	lastNetDiskTime = now
+	return metrics
+}
+
+func updateNetDeviceUI(netdiskMetrics NetDiskMetrics) {
+	var sb strings.Builder
+
+	// Busiest interfaces first; idle ones are only listed while nothing moves
+	interfaces := make([]InterfaceMetrics, len(netdiskMetrics.Interfaces))
+	copy(interfaces, netdiskMetrics.Interfaces)
+	sort.SliceStable(interfaces, func(i, j int) bool {
+		return interfaces[i].InBytesPerSec+interfaces[i].OutBytesPerSec >
+			interfaces[j].InBytesPerSec+interfaces[j].OutBytesPerSec
+	})
+	shown := 0
+	for _, iface := range interfaces {
+		if shown > 0 && iface.InBytesPerSec+iface.OutBytesPerSec == 0 {
+			break
+		}
+		sb.WriteString(fmt.Sprintf("%-8s ↑ %s/s ↓ %s/s\n",
+			iface.Name,
+			formatBytes(iface.OutBytesPerSec, networkUnit),
+			formatBytes(iface.InBytesPerSec, networkUnit),
+		))
+		shown++
+	}
+
+	for _, d := range netdiskMetrics.Disks {
+		sb.WriteString(fmt.Sprintf("%-8s R %s/s W %s/s\n",
+			d.Name,
+			formatBytes(d.ReadKBytesPerSec*1024, diskUnit),
+			formatBytes(d.WriteKBytesPerSec*1024, diskUnit),
+		))
+	}
+	NetDeviceInfo.Text = strings.TrimSuffix(sb.String(), "\n")
+}
+
+func updateNetDiskPrometheus(netdiskMetrics NetDiskMetrics) {
+	networkSpeed.With(prometheus.Labels{"direction": "upload"}).Set(netdiskMetrics.OutBytesPerSec)
+	networkSpeed.With(prometheus.Labels{"direction": "download"}).Set(netdiskMetrics.InBytesPerSec)
+	diskIOSpeed.With(prometheus.Labels{"operation": "read"}).Set(netdiskMetrics.ReadKBytesPerSec)
+	diskIOSpeed.With(prometheus.Labels{"operation": "write"}).Set(netdiskMetrics.WriteKBytesPerSec)
+
+	// Reset so interfaces and disks that disappeared stop being exported
+	networkInterfaceSpeed.Reset()
+	for _, iface := range netdiskMetrics.Interfaces {
+		networkInterfaceSpeed.With(prometheus.Labels{"interface": iface.Name, "direction": "upload"}).Set(iface.OutBytesPerSec)
+		networkInterfaceSpeed.With(prometheus.Labels{"interface": iface.Name, "direction": "download"}).Set(iface.InBytesPerSec)
+	}
+	diskDeviceIOSpeed.Reset()
+	for _, d := range netdiskMetrics.Disks {
+		diskDeviceIOSpeed.With(prometheus.Labels{"device": d.Name, "operation": "read"}).Set(d.ReadKBytesPerSec)
+		diskDeviceIOSpeed.With(prometheus.Labels{"device": d.Name, "operation": "write"}).Set(d.WriteKBytesPerSec)
+	}
+}
@@ -0,0 +1,322 @@
+package app
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	dto "github.com/prometheus/client_model/go"
+)
+
+const (
+	otlpExportInterval = 10 * time.Second
+	otlpExportTimeout  = 10 * time.Second
+	otlpMetricsPath    = "/v1/metrics"
+)
+
+// otlpConfig configures the OTLP/HTTP exporter
+type otlpConfig struct {
+	// Endpoint is the collector's base URL (http://collector:4318)
+	Endpoint string
+	// MetricsEndpoint is the full URL metrics are posted to, used as given
+	// and in place of Endpoint
+	MetricsEndpoint string
+	// Headers are sent with every export, as comma separated key=value pairs
+	Headers string
+}
+
+// enabled reports whether an endpoint to export to is configured
+func (c otlpConfig) enabled() bool {
+	return c.Endpoint != "" || c.MetricsEndpoint != ""
+}
+
+// metricsURL resolves the endpoint to the URL metrics are posted to. As with
+// OTEL_EXPORTER_OTLP_ENDPOINT, the base endpoint gets /v1/metrics appended to
+// whatever path it has. Only OTLP/HTTP is spoken; gRPC endpoints, including
+// anything on the gRPC port 4317, are refused with a pointer to the HTTP port
+// rather than failing on every export.
+func (c otlpConfig) metricsURL() (string, error) {
+	endpoint := c.Endpoint
+	if c.MetricsEndpoint != "" {
+		endpoint = c.MetricsEndpoint
+	}
+	raw := endpoint
+	if !strings.Contains(endpoint, "://") {
+		endpoint = "http://" + endpoint
+	}
+	u, err := url.Parse(endpoint)
+	if err != nil {
+		return "", fmt.Errorf("invalid OTLP endpoint: %w", err)
+	}
+	switch {
+	case u.Scheme == "grpc" || u.Port() == "4317":
+		return "", fmt.Errorf("OTLP/gRPC (port 4317) is not supported, point %q at the collector's OTLP/HTTP (port 4318) endpoint instead, e.g. http://collector:4318", raw)
+	case u.Scheme != "http" && u.Scheme != "https":
+		return "", fmt.Errorf("unsupported OTLP endpoint scheme %q", u.Scheme)
+	case u.Host == "":
+		return "", fmt.Errorf("OTLP endpoint %q has no host", raw)
+	}
+	if c.MetricsEndpoint == "" {
+		u.Path = strings.TrimSuffix(u.Path, "/") + otlpMetricsPath
+		u.RawPath = ""
+	}
+	return u.String(), nil
+}
+
+func (c otlpConfig) headers() (map[string]string, error) {
+	headers := make(map[string]string)
+	for _, pair := range strings.Split(c.Headers, ",") {
+		if strings.TrimSpace(pair) == "" {
+			continue
+		}
+		key, value, ok := strings.Cut(pair, "=")
+		if !ok || strings.TrimSpace(key) == "" {
+			return nil, fmt.Errorf("invalid OTLP header %q, want key=value", pair)
+		}
+		headers[strings.TrimSpace(key)] = strings.TrimSpace(value)
+	}
+	return headers, nil
+}
+
+// The OTLP JSON encoding, reduced to what gauges and sums need. 64 bit
+// integers are strings as in the protobuf JSON mapping.
+type (
+	otlpAnyValue struct {
+		StringValue *string `json:"stringValue,omitempty"`
+		IntValue    *string `json:"intValue,omitempty"`
+	}
+	otlpKeyValue struct {
+		Key   string       `json:"key"`
+		Value otlpAnyValue `json:"value"`
+	}
+	otlpNumberDataPoint struct {
+		Attributes   []otlpKeyValue `json:"attributes,omitempty"`
+		TimeUnixNano string         `json:"timeUnixNano"`
+		AsDouble     float64        `json:"asDouble"`
+	}
+	otlpGauge struct {
+		DataPoints []otlpNumberDataPoint `json:"dataPoints"`
+	}
+	otlpSum struct {
+		DataPoints             []otlpNumberDataPoint `json:"dataPoints"`
+		AggregationTemporality int                   `json:"aggregationTemporality"`
+		IsMonotonic            bool                  `json:"isMonotonic"`
+	}
+	otlpMetric struct {
+		Name        string     `json:"name"`
+		Description string     `json:"description,omitempty"`
+		Unit        string     `json:"unit,omitempty"`
+		Gauge       *otlpGauge `json:"gauge,omitempty"`
+		Sum         *otlpSum   `json:"sum,omitempty"`
+	}
+	otlpScope struct {
+		Name    string `json:"name"`
+		Version string `json:"version,omitempty"`
+	}
+	otlpScopeMetrics struct {
+		Scope   otlpScope    `json:"scope"`
+		Metrics []otlpMetric `json:"metrics"`
+	}
+	otlpResource struct {
+		Attributes []otlpKeyValue `json:"attributes"`
+	}
+	otlpResourceMetrics struct {
+		Resource     otlpResource       `json:"resource"`
+		ScopeMetrics []otlpScopeMetrics `json:"scopeMetrics"`
+	}
+	otlpExportRequest struct {
+		ResourceMetrics []otlpResourceMetrics `json:"resourceMetrics"`
+	}
+)
+
+// otlpAggregationCumulative is AGGREGATION_TEMPORALITY_CUMULATIVE
+const otlpAggregationCumulative = 2
+
+func otlpString(key, value string) otlpKeyValue {
+	return otlpKeyValue{Key: key, Value: otlpAnyValue{StringValue: &value}}
+}
+
+func otlpInt(key string, value int) otlpKeyValue {
+	s := strconv.Itoa(value)
+	return otlpKeyValue{Key: key, Value: otlpAnyValue{IntValue: &s}}
+}
+
+// otlpResourceAttributes describes this machine to the collector
+func otlpResourceAttributes(host string, sysInfo SystemInfo) []otlpKeyValue {
+	return []otlpKeyValue{
+		otlpString("service.name", "mactop"),
+		otlpString("service.version", version),
+		otlpString("host.name", host),
+		otlpString("host.arch", "arm64"),
+		otlpString("os.type", "darwin"),
+		otlpString("host.cpu.model.name", sysInfo.Name),
+		otlpInt("mactop.cpu.cores", sysInfo.CoreCount),
+		otlpInt("mactop.cpu.p_cores", sysInfo.PCoreCount),
+		otlpInt("mactop.cpu.e_cores", sysInfo.ECoreCount),
+		otlpInt("mactop.gpu.cores", sysInfo.GPUCoreCount),
+	}
+}
+
+// otlpUnit derives the UCUM unit from the metric name's suffix
+func otlpUnit(name string) string {
+	for suffix, unit := range map[string]string{
+		"_percent": "%",
+		"_celsius": "Cel",
+		"_watts":   "W",
+		"_mhz":     "MHz",
+		"_bytes":   "By",
+	} {
+		if strings.HasSuffix(name, suffix) {
+			return unit
+		}
+	}
+	return ""
+}
+
+// otlpMetrics converts gathered metric families to OTLP. Gauges and untyped
+// metrics become gauges and counters cumulative sums; mactop registers no
+// histograms or summaries, so those are skipped.
+func otlpMetrics(families []*dto.MetricFamily, now time.Time) []otlpMetric {
+	timestamp := strconv.FormatInt(now.UnixNano(), 10)
+	var metrics []otlpMetric
+	for _, family := range families {
+		var points []otlpNumberDataPoint
+		for _, m := range family.GetMetric() {
+			value, ok := gatheredValue(family.GetType(), m)
+			if !ok {
+				continue
+			}
+			point := otlpNumberDataPoint{TimeUnixNano: timestamp, AsDouble: value}
+			for _, label := range m.GetLabel() {
+				point.Attributes = append(point.Attributes, otlpString(label.GetName(), label.GetValue()))
+			}
+			points = append(points, point)
+		}
+		if len(points) == 0 {
+			continue
+		}
+		metric := otlpMetric{
+			Name:        family.GetName(),
+			Description: family.GetHelp(),
+			Unit:        otlpUnit(family.GetName()),
+		}
+		if family.GetType() == dto.MetricType_COUNTER {
+			metric.Sum = &otlpSum{DataPoints: points, AggregationTemporality: otlpAggregationCumulative, IsMonotonic: true}
+		} else {
+			metric.Gauge = &otlpGauge{DataPoints: points}
+		}
+		metrics = append(metrics, metric)
+	}
+	return metrics
+}
+
+// otlpExporter posts the metrics of a registry to an OTLP/HTTP collector
+type otlpExporter struct {
+	url      string
+	headers  map[string]string
+	resource []otlpKeyValue
+	gatherer prometheus.Gatherer
+	client   *http.Client
+}
+
+func newOTLPExporter(cfg otlpConfig, gatherer prometheus.Gatherer, resource []otlpKeyValue) (*otlpExporter, error) {
+	metricsURL, err := cfg.metricsURL()
+	if err != nil {
+		return nil, err
+	}
+	headers, err := cfg.headers()
+	if err != nil {
+		return nil, err
+	}
+	return &otlpExporter{
+		url:      metricsURL,
+		headers:  headers,
+		resource: resource,
+		gatherer: gatherer,
+		client:   &http.Client{Timeout: otlpExportTimeout},
+	}, nil
+}
+
+func (e *otlpExporter) export(ctx context.Context, now time.Time) error {
+	families, err := e.gatherer.Gather()
+	if err != nil {
+		return fmt.Errorf("gathering metrics: %w", err)
+	}
+	body, err := json.Marshal(otlpExportRequest{ResourceMetrics: []otlpResourceMetrics{{
+		Resource: otlpResource{Attributes: e.resource},
+		ScopeMetrics: []otlpScopeMetrics{{
+			Scope:   otlpScope{Name: "github.com/context-labs/mactop", Version: version},
+			Metrics: otlpMetrics(families, now),
+		}},
+	}}})
+	if err != nil {
+		return err
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, e.url, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	for key, value := range e.headers {
+		req.Header.Set(key, value)
+	}
+	resp, err := e.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode/100 != 2 {
+		msg, _ := io.ReadAll(io.LimitReader(resp.Body, 512))
+		return fmt.Errorf("collector returned %s: %s", resp.Status, strings.TrimSpace(string(msg)))
+	}
+	io.Copy(io.Discard, resp.Body)
+	return nil
+}
+
+// run exports every interval until done is closed. Failures are logged and
+// retried on the next interval.
+func (e *otlpExporter) run(interval time.Duration, done <-chan struct{}) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	failing := false
+	for {
+		select {
+		case <-done:
+			return
+		case now := <-ticker.C:
+			err := e.export(context.Background(), now)
+			if err != nil && !failing {
+				stderrLogger.Printf("OTLP export to %s failed: %v\n", e.url, err)
+			} else if err == nil && failing {
+				stderrLogger.Printf("OTLP export to %s recovered\n", e.url)
+			}
+			failing = err != nil
+		}
+	}
+}
+
+// startOTLPExporter exports mactop's metrics to the configured collector in
+// the background
+func startOTLPExporter(cfg otlpConfig, done <-chan struct{}) error {
+	host := remoteHost
+	if host == "" {
+		host, _ = os.Hostname()
+	}
+	exporter, err := newOTLPExporter(cfg, mactopRegistry(), otlpResourceAttributes(host, getSOCInfo()))
+	if err != nil {
+		return err
+	}
+	go exporter.run(otlpExportInterval, done)
+	stderrLogger.Printf("Exporting metrics to %s\n", exporter.url)
+	return nil
+}
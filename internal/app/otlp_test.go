@@ -0,0 +1,132 @@
+package app
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+func TestOTLPMetricsURL(t *testing.T) {
+	tests := []struct {
+		cfg     otlpConfig
+		want    string
+		wantErr bool
+	}{
+		{otlpConfig{Endpoint: "http://collector:4318"}, "http://collector:4318/v1/metrics", false},
+		{otlpConfig{Endpoint: "collector:4318/"}, "http://collector:4318/v1/metrics", false},
+		{otlpConfig{Endpoint: "http://gw:4318/otlp"}, "http://gw:4318/otlp/v1/metrics", false},
+		{otlpConfig{Endpoint: "https://otel.example.com/custom/path/"}, "https://otel.example.com/custom/path/v1/metrics", false},
+		{otlpConfig{MetricsEndpoint: "https://otel.example.com/custom/path"}, "https://otel.example.com/custom/path", false},
+		{otlpConfig{Endpoint: "http://collector:4318", MetricsEndpoint: "http://gw:4318/metrics"}, "http://gw:4318/metrics", false},
+		{otlpConfig{Endpoint: "http://collector:4317"}, "", true},
+		{otlpConfig{MetricsEndpoint: "http://collector:4317/v1/metrics"}, "", true},
+		{otlpConfig{Endpoint: "grpc://collector"}, "", true},
+		{otlpConfig{Endpoint: "ftp://collector"}, "", true},
+	}
+	for _, tt := range tests {
+		got, err := tt.cfg.metricsURL()
+		if (err != nil) != tt.wantErr {
+			t.Errorf("metricsURL(%+v) error = %v, wantErr %v", tt.cfg, err, tt.wantErr)
+			continue
+		}
+		if got != tt.want {
+			t.Errorf("metricsURL(%+v) = %q, want %q", tt.cfg, got, tt.want)
+		}
+	}
+
+	if _, err := (otlpConfig{Endpoint: "collector:4317"}).metricsURL(); err == nil || !strings.Contains(err.Error(), "OTLP/HTTP (port 4318)") {
+		t.Errorf("gRPC port error = %v, want a pointer to OTLP/HTTP on 4318", err)
+	}
+	if _, err := (otlpConfig{Headers: "authorization"}).headers(); err == nil {
+		t.Error("headers() accepted a pair without a value")
+	}
+}
+
+// fakeCollector records the OTLP export requests it receives
+type fakeCollector struct {
+	requests chan otlpExportRequest
+	headers  chan http.Header
+	status   int
+}
+
+func (c *fakeCollector) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if r.URL.Path != otlpMetricsPath || r.Header.Get("Content-Type") != "application/json" {
+		http.Error(w, "unexpected request", http.StatusBadRequest)
+		return
+	}
+	var req otlpExportRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	c.requests <- req
+	c.headers <- r.Header
+	if c.status != 0 {
+		http.Error(w, "collector overloaded", c.status)
+		return
+	}
+	w.Write([]byte("{}"))
+}
+
+func TestOTLPExport(t *testing.T) {
+	registry := prometheus.NewRegistry()
+	power := prometheus.NewGauge(prometheus.GaugeOpts{Name: "mactop_power_watts", Help: "Power"})
+	memory := prometheus.NewGaugeVec(prometheus.GaugeOpts{Name: "mactop_memory_gb", Help: "Memory"}, []string{"type"})
+	samples := prometheus.NewCounter(prometheus.CounterOpts{Name: "mactop_samples_total", Help: "Samples"})
+	registry.MustRegister(power, memory, samples)
+	power.Set(12.5)
+	memory.WithLabelValues("used").Set(9)
+	memory.WithLabelValues("total").Set(16)
+	samples.Add(3)
+
+	collector := &fakeCollector{requests: make(chan otlpExportRequest, 1), headers: make(chan http.Header, 1)}
+	srv := httptest.NewServer(collector)
+	defer srv.Close()
+
+	sysInfo := SystemInfo{Name: "Apple M3 Pro", CoreCount: 12, PCoreCount: 6, ECoreCount: 6, GPUCoreCount: 18}
+	exporter, err := newOTLPExporter(otlpConfig{Endpoint: srv.URL, Headers: "x-api-key=secret"}, registry, otlpResourceAttributes("ci-mini-01", sysInfo))
+	if err != nil {
+		t.Fatal(err)
+	}
+	now := time.Unix(1709283600, 0)
+	if err := exporter.export(context.Background(), now); err != nil {
+		t.Fatal(err)
+	}
+	req := <-collector.requests
+	if got := (<-collector.headers).Get("X-Api-Key"); got != "secret" {
+		t.Errorf("x-api-key header = %q", got)
+	}
+
+	resource := make(map[string]otlpAnyValue)
+	for _, attr := range req.ResourceMetrics[0].Resource.Attributes {
+		resource[attr.Key] = attr.Value
+	}
+	if deref(resource["host.name"].StringValue) != "ci-mini-01" || deref(resource["host.cpu.model.name"].StringValue) != "Apple M3 Pro" || deref(resource["mactop.gpu.cores"].IntValue) != "18" {
+		t.Errorf("resource attributes = %+v", req.ResourceMetrics[0].Resource.Attributes)
+	}
+
+	metrics := make(map[string]otlpMetric)
+	for _, m := range req.ResourceMetrics[0].ScopeMetrics[0].Metrics {
+		metrics[m.Name] = m
+	}
+	if m := metrics["mactop_power_watts"]; m.Gauge == nil || m.Unit != "W" || m.Gauge.DataPoints[0].AsDouble != 12.5 || m.Gauge.DataPoints[0].TimeUnixNano != "1709283600000000000" {
+		t.Errorf("power metric = %+v", m)
+	}
+	if m := metrics["mactop_memory_gb"]; m.Gauge == nil || len(m.Gauge.DataPoints) != 2 || m.Gauge.DataPoints[0].Attributes[0].Key != "type" {
+		t.Errorf("memory metric = %+v", m)
+	}
+	if m := metrics["mactop_samples_total"]; m.Sum == nil || !m.Sum.IsMonotonic || m.Sum.AggregationTemporality != otlpAggregationCumulative || m.Sum.DataPoints[0].AsDouble != 3 {
+		t.Errorf("counter metric = %+v", m)
+	}
+
+	collector.status = http.StatusServiceUnavailable
+	if err := exporter.export(context.Background(), now); err == nil {
+		t.Error("export succeeded although the collector returned 503")
+	}
+}
@@ -0,0 +1,179 @@
+package app
+
+import (
+	"fmt"
+	"time"
+)
+
+const (
+	// scrubWindow is how far back a paused display can be scrubbed
+	scrubWindow = 10 * time.Minute
+	// scrubStep is how far one press of ← or → moves
+	scrubStep = 5 * time.Second
+)
+
+// scrubKeys maps the keys that scrub a paused display to their direction
+var scrubKeys = map[string]int{
+	"<Left>":  -1,
+	"<Right>": 1,
+}
+
+// uiFrame is what arrived from the collectors on one tick, nil where a kind
+// of metric had nothing new
+type uiFrame struct {
+	Time    time.Time
+	CPU     *CPUMetrics
+	GPU     *GPUMetrics
+	NetDisk *NetDiskMetrics
+	Markers []timelineMarker
+}
+
+func (f uiFrame) empty() bool {
+	return f.CPU == nil && f.GPU == nil && f.NetDisk == nil && len(f.Markers) == 0
+}
+
+// uiPlayback keeps the recent frames so the display can be paused while the
+// collectors carry on, and scrubbed back through the last scrubWindow. A
+// frame in the past is shown by replaying the depth frames leading up to
+// it, which refills every chart as it looked then. Callers hold renderMutex.
+type uiPlayback struct {
+	frames []uiFrame
+	depth  int
+	paused bool
+	// pos is the frame shown while paused
+	pos int
+}
+
+func newUIPlayback(depth int) *uiPlayback {
+	return &uiPlayback{depth: max(depth, 1)}
+}
+
+// playbackDepth is the most samples any chart holds
+func playbackDepth() int {
+	return max(len(powerValues), len(gpuValues), len(tempChart.states),
+		len(netOutHistory.values), cap(coreHeatmap.samples))
+}
+
+// push stores a frame and shows it unless paused
+func (p *uiPlayback) push(frame uiFrame) {
+	p.store(frame)
+	if p.paused {
+		// Only the offset from live changes
+		setCoreTitles()
+		return
+	}
+	p.pos = len(p.frames) - 1
+	applyFrame(frame)
+}
+
+func (p *uiPlayback) store(frame uiFrame) {
+	p.frames = append(p.frames, frame)
+	// Keep the scrub window plus enough earlier frames to replay its start
+	start := len(p.frames) - 1
+	for start > 0 && frame.Time.Sub(p.frames[start-1].Time) <= scrubWindow {
+		start--
+	}
+	if drop := start - p.depth; drop > 0 {
+		p.frames = append(p.frames[:0], p.frames[drop:]...)
+		p.pos = max(p.pos-drop, 0)
+	}
+}
+
+func (p *uiPlayback) togglePause() {
+	if len(p.frames) == 0 {
+		return
+	}
+	p.paused = !p.paused
+	if !p.paused && p.pos != len(p.frames)-1 {
+		p.rebuild(len(p.frames) - 1)
+		return
+	}
+	setCoreTitles()
+}
+
+// scrub moves a paused display one scrubStep back (-1) or forward (1)
+func (p *uiPlayback) scrub(direction int) {
+	if !p.paused || len(p.frames) == 0 {
+		return
+	}
+	if pos := p.scrubPos(direction); pos != p.pos {
+		p.rebuild(pos)
+	}
+}
+
+// scrubPos is the frame a scrub in direction lands on, at least one frame
+// away unless at either end of the scrub window
+func (p *uiPlayback) scrubPos(direction int) int {
+	oldest := p.frames[len(p.frames)-1].Time.Add(-scrubWindow)
+	target := p.frames[p.pos].Time.Add(time.Duration(direction) * scrubStep)
+	pos := p.pos
+	for next := pos + direction; next >= 0 && next < len(p.frames); next += direction {
+		t := p.frames[next].Time
+		if t.Before(oldest) || pos != p.pos && (direction < 0 && t.Before(target) || direction > 0 && t.After(target)) {
+			break
+		}
+		pos = next
+	}
+	return pos
+}
+
+// offset is how far the shown frame is behind the latest one
+func (p *uiPlayback) offset() time.Duration {
+	if len(p.frames) == 0 {
+		return 0
+	}
+	return p.frames[len(p.frames)-1].Time.Sub(p.frames[p.pos].Time)
+}
+
+// status is shown in the title, e.g. "LIVE" or "PAUSED -00:42"
+func (p *uiPlayback) status() string {
+	if p == nil || !p.paused {
+		return "LIVE"
+	}
+	secs := int(p.offset().Round(time.Second) / time.Second)
+	return fmt.Sprintf("PAUSED -%02d:%02d", secs/60, secs%60)
+}
+
+// rebuild clears the charts and replays the frames up to i
+func (p *uiPlayback) rebuild(i int) {
+	clear(powerValues)
+	clear(gpuValues)
+	sparklineGroup.markers = chartMarkers{}
+	gpuSparklineGroup.markers = chartMarkers{}
+	tempChart.Reset()
+	coreHeatmap.Reset()
+	for _, h := range []*metricHistory{netOutHistory, netInHistory, diskReadHistory, diskWriteHistory} {
+		h.Reset()
+	}
+	p.pos = i
+	for _, frame := range p.frames[max(i-p.depth+1, 0) : i+1] {
+		applyFrame(frame)
+	}
+	setCoreTitles()
+}
+
+// applyFrame updates the widgets with a frame
+func applyFrame(frame uiFrame) {
+	if frame.CPU != nil {
+		updateCPUUI(*frame.CPU)
+		updateTotalPowerChart(frame.CPU.PackageW, frame.CPU.ThermalState)
+		updateTempChart(*frame.CPU)
+	}
+	if frame.GPU != nil {
+		updateGPUUI(*frame.GPU)
+	}
+	if frame.NetDisk != nil {
+		updateNetDiskUI(*frame.NetDisk)
+	}
+	for _, m := range frame.Markers {
+		markCharts(m.Label)
+	}
+}
+
+// setCoreTitles shows the core summary with the live or paused status
+func setCoreTitles() {
+	title := coreTitle + " | " + playback.status()
+	cpuGauge.Title = title
+	cpuCoreWidget.Title = title
+	coreHeatmap.Title = title
+}
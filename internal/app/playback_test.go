@@ -0,0 +1,66 @@
+package app
+
+import (
+	"testing"
+	"time"
+)
+
+func TestUIPlaybackStore(t *testing.T) {
+	start := time.Unix(1709283600, 0)
+	p := newUIPlayback(3)
+	p.paused = true
+	for i := 0; i <= 20; i++ {
+		p.store(uiFrame{Time: start.Add(time.Duration(i) * time.Minute)})
+	}
+	// Minutes 10 to 20 are in the window, with three more to replay minute 10
+	if len(p.frames) != 14 || !p.frames[0].Time.Equal(start.Add(7*time.Minute)) {
+		t.Errorf("kept %d frames from %v", len(p.frames), p.frames[0].Time.Sub(start))
+	}
+	if p.pos != 0 {
+		t.Errorf("pos = %d, want 0 after the shown frame was dropped", p.pos)
+	}
+}
+
+func TestUIPlaybackScrub(t *testing.T) {
+	start := time.Unix(1709283600, 0)
+	p := newUIPlayback(1)
+	// A sample a second for 15 minutes
+	for i := 0; i <= 900; i++ {
+		p.store(uiFrame{Time: start.Add(time.Duration(i) * time.Second)})
+	}
+	p.paused = true
+	p.pos = len(p.frames) - 1
+	if got := p.status(); got != "PAUSED -00:00" {
+		t.Errorf("status = %q", got)
+	}
+
+	p.pos = p.scrubPos(-1)
+	if got := p.status(); got != "PAUSED -00:05" {
+		t.Errorf("status after one step back = %q", got)
+	}
+	for i := 0; i < 200; i++ {
+		p.pos = p.scrubPos(-1)
+	}
+	if got := p.status(); got != "PAUSED -10:00" {
+		t.Errorf("status at the start of the window = %q", got)
+	}
+	p.pos = p.scrubPos(1)
+	if got := p.status(); got != "PAUSED -09:55" {
+		t.Errorf("status after one step forward = %q", got)
+	}
+
+	// Samples further apart than a step still move one at a time
+	p = newUIPlayback(1)
+	for i := 0; i < 3; i++ {
+		p.store(uiFrame{Time: start.Add(time.Duration(i) * 20 * time.Second)})
+	}
+	p.paused, p.pos = true, 2
+	if p.pos = p.scrubPos(-1); p.pos != 1 {
+		t.Errorf("pos = %d, want 1", p.pos)
+	}
+
+	p.paused = false
+	if got := p.status(); got != "LIVE" {
+		t.Errorf("live status = %q", got)
+	}
+}
@@ -208,6 +208,7 @@ func getProcessList() ([]ProcessMetrics, error) {
 			State:       state,
 			Started:     "",
 			Time:        timeStr,
+			LastTime:    totalSeconds,
 			LastUpdated: now,
 		})
 	}
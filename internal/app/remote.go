@@ -0,0 +1,559 @@
+package app
+
+import (
+	"bufio"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"os"
+	"os/signal"
+	"strings"
+	"sync"
+	"syscall"
+	"time"
+)
+
+// remoteProtocolVersion is bumped whenever the frames change incompatibly
+const remoteProtocolVersion = 2
+
+// maxFrameSize bounds a single frame so a bad peer can't make us allocate
+// arbitrary amounts of memory
+const maxFrameSize = 16 << 20
+
+// maxHelloSize bounds the hello, which is read before the client has
+// presented its token
+const maxHelloSize = 4 << 10
+
+// maxPendingHandshakes bounds the clients the agent waits on for a hello;
+// further connections are closed until one of them finishes
+const maxPendingHandshakes = 16
+
+const (
+	remoteDialTimeout    = 5 * time.Second
+	remoteWriteTimeout   = 10 * time.Second
+	remoteReconnectDelay = 2 * time.Second
+	// Only this machine can connect unless the agent is told otherwise
+	defaultAgentListenAddr = "127.0.0.1:7070"
+)
+
+// agentSecurity holds the options shared by the agent and its clients
+type agentSecurity struct {
+	// Token is required from clients when set
+	Token   string
+	TLSCert string
+	TLSKey  string
+	// TLS makes clients connect over TLS, verified against CAFile if given
+	// and the system roots otherwise
+	TLS    bool
+	CAFile string
+}
+
+var agentSettings agentSecurity
+
+// agentHello is the first frame a client sends
+type agentHello struct {
+	Version int    `json:"version"`
+	Token   string `json:"token,omitempty"`
+}
+
+// RemoteSample is one frame of the agent protocol: the headless sample plus
+// what the TUI needs that headless mode doesn't output
+type RemoteSample struct {
+	Version   int              `json:"version"`
+	Host      string           `json:"host"`
+	Topology  CoreTopology     `json:"topology"`
+	Sample    HeadlessOutput   `json:"sample"`
+	Processes []ProcessMetrics `json:"processes"`
+	// Error is set instead of a sample when the agent turns a client away
+	Error string `json:"error,omitempty"`
+}
+
+// Frames are a 4 byte big endian length followed by that many bytes of JSON
+func encodeFrame(v any) ([]byte, error) {
+	data, err := json.Marshal(v)
+	if err != nil {
+		return nil, err
+	}
+	if len(data) > maxFrameSize {
+		return nil, fmt.Errorf("frame of %d bytes exceeds limit of %d", len(data), maxFrameSize)
+	}
+	frame := make([]byte, 4+len(data))
+	binary.BigEndian.PutUint32(frame, uint32(len(data)))
+	copy(frame[4:], data)
+	return frame, nil
+}
+
+// readFrame reads one frame of at most limit bytes into v
+func readFrame(r io.Reader, v any, limit uint32) error {
+	var header [4]byte
+	if _, err := io.ReadFull(r, header[:]); err != nil {
+		return err
+	}
+	size := binary.BigEndian.Uint32(header[:])
+	if size > limit {
+		return fmt.Errorf("frame of %d bytes exceeds limit of %d", size, limit)
+	}
+	data := make([]byte, size)
+	if _, err := io.ReadFull(r, data); err != nil {
+		return err
+	}
+	return json.Unmarshal(data, v)
+}
+
+// sampleSource produces the samples an agent streams
+type sampleSource interface {
+	Sample() RemoteSample
+}
+
+// localSampleSource samples this machine the same way headless mode does
+type localSampleSource struct {
+	host     string
+	sysInfo  SystemInfo
+	topology CoreTopology
+}
+
+func (s *localSampleSource) Sample() RemoteSample {
+	sample := collectHeadlessSample(s.sysInfo, max(updateInterval.Load()/2, 100))
+	processes, err := getProcessList()
+	if err != nil {
+		stderrLogger.Printf("Error getting process list: %v\n", err)
+	}
+	return RemoteSample{
+		Version:   remoteProtocolVersion,
+		Host:      s.host,
+		Topology:  s.topology,
+		Sample:    sample.Output,
+		Processes: processes,
+	}
+}
+
+// Agent streams samples from a source to every connected client. Sampling
+// happens once per interval regardless of the number of clients, and a slow
+// client misses frames rather than holding up the others.
+type Agent struct {
+	source   sampleSource
+	interval time.Duration
+	token    string
+
+	// handshakes holds a slot for each client that hasn't sent its hello yet
+	handshakes chan struct{}
+
+	mu      sync.Mutex
+	clients map[chan []byte]struct{}
+	// closed is set once the clients have been closed on shutdown
+	closed bool
+}
+
+// NewAgent creates an agent; a non-empty token is required from every client
+func NewAgent(source sampleSource, interval time.Duration, token string) *Agent {
+	return &Agent{
+		source:     source,
+		interval:   interval,
+		token:      token,
+		handshakes: make(chan struct{}, maxPendingHandshakes),
+		clients:    make(map[chan []byte]struct{}),
+	}
+}
+
+// Serve accepts clients on ln until done is closed
+func (a *Agent) Serve(ln net.Listener, done <-chan struct{}) error {
+	go a.broadcast(done)
+	go func() {
+		<-done
+		ln.Close()
+	}()
+	for {
+		conn, err := ln.Accept()
+		if err != nil {
+			select {
+			case <-done:
+				return nil
+			default:
+				return err
+			}
+		}
+		go a.handle(conn)
+	}
+}
+
+func (a *Agent) broadcast(done <-chan struct{}) {
+	ticker := time.NewTicker(a.interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-done:
+			a.mu.Lock()
+			for ch := range a.clients {
+				close(ch)
+				delete(a.clients, ch)
+			}
+			a.closed = true
+			a.mu.Unlock()
+			return
+		case <-ticker.C:
+		}
+
+		a.mu.Lock()
+		idle := len(a.clients) == 0
+		a.mu.Unlock()
+		if idle {
+			continue
+		}
+
+		frame, err := encodeFrame(a.source.Sample())
+		if err != nil {
+			stderrLogger.Printf("Error encoding sample: %v\n", err)
+			continue
+		}
+		a.mu.Lock()
+		for ch := range a.clients {
+			select {
+			case ch <- frame:
+			default:
+			}
+		}
+		a.mu.Unlock()
+	}
+}
+
+// authenticate reads the client's hello, and tells the client why when it
+// is turned away
+func (a *Agent) authenticate(conn net.Conn) bool {
+	conn.SetReadDeadline(time.Now().Add(remoteDialTimeout))
+	var hello agentHello
+	err := readFrame(conn, &hello, maxHelloSize)
+	conn.SetReadDeadline(time.Time{})
+	reason := ""
+	switch {
+	case err != nil:
+		return false
+	case hello.Version != remoteProtocolVersion:
+		reason = fmt.Sprintf("agent speaks protocol version %d, client %d", remoteProtocolVersion, hello.Version)
+	case a.token != "" && !secureEqual(hello.Token, a.token):
+		reason = "invalid agent token"
+	default:
+		return true
+	}
+	if frame, err := encodeFrame(RemoteSample{Version: remoteProtocolVersion, Error: reason}); err == nil {
+		conn.SetWriteDeadline(time.Now().Add(remoteWriteTimeout))
+		conn.Write(frame)
+	}
+	return false
+}
+
+func (a *Agent) handle(conn net.Conn) {
+	defer conn.Close()
+	select {
+	case a.handshakes <- struct{}{}:
+	default:
+		return
+	}
+	ok := a.authenticate(conn)
+	<-a.handshakes
+	if !ok {
+		return
+	}
+	frames := make(chan []byte, 1)
+	a.mu.Lock()
+	if a.closed {
+		a.mu.Unlock()
+		return
+	}
+	a.clients[frames] = struct{}{}
+	a.mu.Unlock()
+	defer func() {
+		a.mu.Lock()
+		delete(a.clients, frames)
+		a.mu.Unlock()
+	}()
+
+	for frame := range frames {
+		conn.SetWriteDeadline(time.Now().Add(remoteWriteTimeout))
+		if _, err := conn.Write(frame); err != nil {
+			return
+		}
+	}
+}
+
+// remoteClient reads samples from an agent
+type remoteClient struct {
+	conn   net.Conn
+	reader *bufio.Reader
+}
+
+// clientTLSConfig is the TLS configuration clients verify the agent with
+func (s agentSecurity) clientTLSConfig() (*tls.Config, error) {
+	cfg := &tls.Config{MinVersion: tls.VersionTLS12}
+	if s.CAFile != "" {
+		pem, err := os.ReadFile(s.CAFile)
+		if err != nil {
+			return nil, err
+		}
+		cfg.RootCAs = x509.NewCertPool()
+		if !cfg.RootCAs.AppendCertsFromPEM(pem) {
+			return nil, fmt.Errorf("no certificates found in %s", s.CAFile)
+		}
+	}
+	return cfg, nil
+}
+
+// dialAgent connects to an agent with agentSettings and sends the hello
+func dialAgent(addr string) (*remoteClient, error) {
+	dialer := &net.Dialer{Timeout: remoteDialTimeout}
+	var conn net.Conn
+	var err error
+	if agentSettings.TLS || agentSettings.CAFile != "" {
+		cfg, cfgErr := agentSettings.clientTLSConfig()
+		if cfgErr != nil {
+			return nil, cfgErr
+		}
+		conn, err = tls.DialWithDialer(dialer, "tcp", addr, cfg)
+	} else {
+		conn, err = dialer.Dial("tcp", addr)
+	}
+	if err != nil {
+		return nil, err
+	}
+	hello, err := encodeFrame(agentHello{Version: remoteProtocolVersion, Token: agentSettings.Token})
+	if err == nil {
+		conn.SetWriteDeadline(time.Now().Add(remoteWriteTimeout))
+		_, err = conn.Write(hello)
+		conn.SetWriteDeadline(time.Time{})
+	}
+	if err != nil {
+		conn.Close()
+		return nil, err
+	}
+	return &remoteClient{conn: conn, reader: bufio.NewReader(conn)}, nil
+}
+
+func (c *remoteClient) Next() (RemoteSample, error) {
+	var sample RemoteSample
+	if err := readFrame(c.reader, &sample, maxFrameSize); err != nil {
+		return RemoteSample{}, err
+	}
+	if sample.Error != "" {
+		return RemoteSample{}, errors.New(sample.Error)
+	}
+	if sample.Version != remoteProtocolVersion {
+		return RemoteSample{}, fmt.Errorf("agent speaks protocol version %d, expected %d", sample.Version, remoteProtocolVersion)
+	}
+	return sample, nil
+}
+
+func (c *remoteClient) Close() error {
+	return c.conn.Close()
+}
+
+func deref[T any](p *T) T {
+	var zero T
+	if p == nil {
+		return zero
+	}
+	return *p
+}
+
+func thermalStateIndex(name string) int {
+	for i, state := range thermalStateNames {
+		if state == name {
+			return i
+		}
+	}
+	return 0
+}
+
+// remoteMetrics converts a sample into the metrics the TUI update functions take
+func remoteMetrics(s RemoteSample) (CPUMetrics, GPUMetrics) {
+	out := s.Sample
+	soc := out.SocMetrics
+	thermal := thermalStateIndex(out.ThermalState)
+	cpuMetrics := CPUMetrics{
+		CPUW:         deref(soc.CPUPower),
+		GPUW:         deref(soc.GPUPower),
+		ANEW:         deref(soc.ANEPower),
+		DRAMW:        deref(soc.DRAMPower),
+		GPUSRAMW:     deref(soc.GPUSRAMPower),
+		SystemW:      deref(soc.SystemPower),
+		PackageW:     deref(soc.TotalPower),
+		Throttled:    thermal > 0,
+		ThermalState: thermal,
+		// The agent already resolved the ANE method, pass its result through
+		ANEActive:    deref(out.ANEUsage),
+		ANEResidency: out.ANEUsage != nil,
+		CPUTemp:      float64(deref(out.CPUTemp)),
+		GPUTemp:      float64(deref(out.GPUTemp)),
+		CoreUsages:   out.CoreUsages,
+		Memory:       out.Memory,
+	}
+	gpuMetrics := GPUMetrics{
+		FreqMHz:       int(deref(soc.GPUFreqMHz)),
+		ActivePercent: deref(out.GPUUsage),
+		Power:         deref(soc.GPUPower) + deref(soc.GPUSRAMPower),
+		Temp:          deref(out.GPUTemp),
+		Perf:          deref(out.GPUStats),
+	}
+	return cpuMetrics, gpuMetrics
+}
+
+// useRemoteSystem makes the static system description come from the agent.
+// Must run before anything calls getSOCInfo or GetCoreTopology.
+func useRemoteSystem(sample RemoteSample) {
+	socInfoOnce.Do(func() { socInfo = sample.Sample.SystemInfo })
+	detectedTopologyOnce.Do(func() { detectedTopology = sample.Topology })
+	capabilities = sample.Sample.Capabilities
+	remoteHost = sample.Host
+	if remoteHost == "" {
+		remoteHost = "remote"
+	}
+}
+
+// connectAgent dials the agent and waits for its first sample
+func connectAgent(addr string) (*remoteClient, RemoteSample, error) {
+	client, err := dialAgent(addr)
+	if err != nil {
+		return nil, RemoteSample{}, err
+	}
+	first, err := client.Next()
+	if err != nil {
+		client.Close()
+		return nil, RemoteSample{}, err
+	}
+	return client, first, nil
+}
+
+func sendRemoteSample(sample RemoteSample, cpumetricsChan chan CPUMetrics, gpumetricsChan chan GPUMetrics, netdiskMetricsChan chan NetDiskMetrics, processMetricsChan chan []ProcessMetrics) {
+	cpuMetrics, gpuMetrics := remoteMetrics(sample)
+	select {
+	case cpumetricsChan <- cpuMetrics:
+	default:
+	}
+	select {
+	case gpumetricsChan <- gpuMetrics:
+	default:
+	}
+	select {
+	case netdiskMetricsChan <- sample.Sample.NetDisk:
+	default:
+	}
+	select {
+	case processMetricsChan <- sample.Processes:
+	default:
+	}
+}
+
+// collectRemoteMetrics feeds the TUI channels from an agent, reconnecting if
+// the stream drops
+func collectRemoteMetrics(done chan struct{}, client *remoteClient, cpumetricsChan chan CPUMetrics, gpumetricsChan chan GPUMetrics, netdiskMetricsChan chan NetDiskMetrics, processMetricsChan chan []ProcessMetrics) {
+	defer func() {
+		if client != nil {
+			client.Close()
+		}
+	}()
+	for {
+		select {
+		case <-done:
+			return
+		default:
+		}
+
+		if client == nil {
+			var err error
+			if client, err = dialAgent(remoteAddr); err != nil {
+				time.Sleep(remoteReconnectDelay)
+				continue
+			}
+		}
+
+		sample, err := client.Next()
+		if err != nil {
+			stderrLogger.Printf("Lost connection to %s: %v\n", remoteAddr, err)
+			client.Close()
+			client = nil
+			renderMutex.Lock()
+			modelText.Title = remoteHost + " (disconnected)"
+			renderMutex.Unlock()
+			continue
+		}
+
+		renderMutex.Lock()
+		capabilities = sample.Sample.Capabilities
+		modelText.Title = remoteHost
+		renderMutex.Unlock()
+		sendRemoteSample(sample, cpumetricsChan, gpumetricsChan, netdiskMetricsChan, processMetricsChan)
+	}
+}
+
+// parseSubcommand splits a leading command such as "agent", "connect <addr>"
+// or "fleet <addr>..." off the arguments so the remaining flags can be parsed
+// as usual
+func parseSubcommand(args []string) (command string, addrs []string, rest []string, err error) {
+	if len(args) == 0 {
+		return "", nil, args, nil
+	}
+	switch args[0] {
+	case "agent":
+		return "agent", nil, args[1:], nil
+	case "history", "daemon", "install-agent", "uninstall-agent":
+		return args[0], nil, args[1:], nil
+	case "connect":
+		if len(args) < 2 || strings.HasPrefix(args[1], "-") {
+			return "", nil, nil, fmt.Errorf("connect requires an agent address, e.g. mactop connect host:7070")
+		}
+		return "connect", args[1:2], args[2:], nil
+	case "fleet":
+		n := 1
+		for n < len(args) && !strings.HasPrefix(args[n], "-") {
+			n++
+		}
+		if n == 1 {
+			return "", nil, nil, fmt.Errorf("fleet requires at least one agent address, e.g. mactop fleet host1:7070 host2:7070")
+		}
+		return "fleet", args[1:n], args[n:], nil
+	}
+	return "", nil, args, nil
+}
+
+// runAgent serves samples of this machine until interrupted
+func runAgent(listenAddr string) {
+	initMetricSources()
+	defer cleanupSocMetrics()
+
+	if (agentSettings.TLSCert == "") != (agentSettings.TLSKey == "") {
+		stderrLogger.Fatalf("agent TLS needs both a certificate and a key")
+	}
+	ln, err := net.Listen("tcp", listenAddr)
+	if err != nil {
+		stderrLogger.Fatalf("failed to listen on %s: %v", listenAddr, err)
+	}
+	if agentSettings.TLSCert != "" {
+		cfg, err := serverTLSConfig(agentSettings.TLSCert, agentSettings.TLSKey)
+		if err != nil {
+			stderrLogger.Fatalf("%v", err)
+		}
+		ln = tls.NewListener(ln, cfg)
+	}
+
+	host, _ := os.Hostname()
+	sysInfo := getSOCInfo()
+	source := &localSampleSource{host: host, sysInfo: sysInfo, topology: GetCoreTopology(sysInfo)}
+	GetCPUPercentages()
+
+	stop := make(chan struct{})
+	quit := make(chan os.Signal, 1)
+	signal.Notify(quit, os.Interrupt, syscall.SIGTERM)
+	go func() {
+		<-quit
+		close(stop)
+	}()
+
+	stderrLogger.Printf("mactop agent listening on %s\n", ln.Addr())
+	agent := NewAgent(source, updateInterval.Duration(), agentSettings.Token)
+	if err := agent.Serve(ln, stop); err != nil {
+		stderrLogger.Fatalf("agent stopped: %v", err)
+	}
+}
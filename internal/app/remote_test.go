@@ -0,0 +1,266 @@
+package app
+
+import (
+	"bytes"
+	"crypto/tls"
+	"encoding/binary"
+	"io"
+	"net"
+	"path/filepath"
+	"reflect"
+	"testing"
+	"time"
+)
+
+// syntheticSampleSource produces deterministic samples without touching the
+// hardware, so the protocol can be exercised anywhere
+type syntheticSampleSource struct {
+	n int
+}
+
+func (s *syntheticSampleSource) Sample() RemoteSample {
+	s.n++
+	usage := float64(s.n)
+	return RemoteSample{
+		Version:  remoteProtocolVersion,
+		Host:     "ci-mini-01",
+		Topology: ChipSpec{Order: []string{"E", "P"}}.topology(4, 4),
+		Sample: HeadlessOutput{
+			SocMetrics: HeadlessSocMetrics{
+				CPUPower:   optional(2.5, true),
+				GPUPower:   optional(1.0, true),
+				TotalPower: optional(6.0, true),
+				GPUFreqMHz: optional(int32(1398), true),
+			},
+			CPUUsage:     usage,
+			GPUUsage:     optional(usage*2, true),
+			CoreUsages:   []float64{usage, usage, 0, 0, 100, 100, 50, 50},
+			SystemInfo:   SystemInfo{Name: "Apple M2", CoreCount: 8, PCoreCount: 4, ECoreCount: 4},
+			ThermalState: "Heavy",
+			CPUTemp:      optional(float32(52), true),
+			Capabilities: SocCapabilities{EnergyModel: true, GPUStats: true, SMCTempKeys: 8},
+		},
+		Processes: []ProcessMetrics{{PID: 100 + s.n, Command: "xcodebuild", CPU: usage}},
+	}
+}
+
+func TestFrameRoundTrip(t *testing.T) {
+	want := (&syntheticSampleSource{}).Sample()
+	frame, err := encodeFrame(want)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var got RemoteSample
+	if err := readFrame(bytes.NewReader(frame), &got, maxFrameSize); err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("round trip mismatch:\ngot  %+v\nwant %+v", got, want)
+	}
+
+	var oversized [4]byte
+	binary.BigEndian.PutUint32(oversized[:], maxFrameSize+1)
+	if err := readFrame(bytes.NewReader(oversized[:]), &got, maxFrameSize); err == nil {
+		t.Error("readFrame accepted an oversized frame")
+	}
+	if err := readFrame(bytes.NewReader(frame), &got, uint32(len(frame)-5)); err == nil {
+		t.Error("readFrame ignored its limit")
+	}
+	if err := readFrame(bytes.NewReader(frame[:len(frame)-1]), &got, maxFrameSize); err == nil {
+		t.Error("readFrame accepted a truncated frame")
+	}
+}
+
+func TestAgentLoopback(t *testing.T) {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	done := make(chan struct{})
+	served := make(chan error, 1)
+	agent := NewAgent(&syntheticSampleSource{}, 10*time.Millisecond, "")
+	go func() { served <- agent.Serve(ln, done) }()
+
+	client, err := dialAgent(ln.Addr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer client.Close()
+	client.conn.SetReadDeadline(time.Now().Add(5 * time.Second))
+
+	last := 0.0
+	for i := 0; i < 3; i++ {
+		sample, err := client.Next()
+		if err != nil {
+			t.Fatalf("sample %d: %v", i, err)
+		}
+		if sample.Sample.CPUUsage <= last {
+			t.Errorf("sample %d not newer than the previous one: %v <= %v", i, sample.Sample.CPUUsage, last)
+		}
+		last = sample.Sample.CPUUsage
+
+		cpuMetrics, gpuMetrics := remoteMetrics(sample)
+		if cpuMetrics.ThermalState != 2 || !cpuMetrics.Throttled {
+			t.Errorf("thermal state = %d, throttled = %v", cpuMetrics.ThermalState, cpuMetrics.Throttled)
+		}
+		if cpuMetrics.CPUW != 2.5 || cpuMetrics.PackageW != 6 || cpuMetrics.CPUTemp != 52 || len(cpuMetrics.CoreUsages) != 8 {
+			t.Errorf("cpu metrics = %+v", cpuMetrics)
+		}
+		if gpuMetrics.FreqMHz != 1398 || gpuMetrics.ActivePercent != last*2 || gpuMetrics.Temp != 0 {
+			t.Errorf("gpu metrics = %+v", gpuMetrics)
+		}
+		if len(sample.Processes) != 1 || sample.Processes[0].Command != "xcodebuild" {
+			t.Errorf("processes = %+v", sample.Processes)
+		}
+	}
+
+	close(done)
+	select {
+	case err := <-served:
+		if err != nil {
+			t.Errorf("Serve() = %v", err)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("agent did not stop")
+	}
+}
+
+func TestAgentTokenAndTLS(t *testing.T) {
+	dir := t.TempDir()
+	certFile, keyFile := filepath.Join(dir, "cert.pem"), filepath.Join(dir, "key.pem")
+	writeTestCert(t, certFile, keyFile)
+	cfg, err := serverTLSConfig(certFile, keyFile)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	done := make(chan struct{})
+	defer close(done)
+	agent := NewAgent(&syntheticSampleSource{}, 10*time.Millisecond, "s3cret")
+	go agent.Serve(tls.NewListener(ln, cfg), done)
+
+	saved := agentSettings
+	defer func() { agentSettings = saved }()
+	next := func(settings agentSecurity) error {
+		t.Helper()
+		agentSettings = settings
+		client, err := dialAgent(ln.Addr().String())
+		if err != nil {
+			return err
+		}
+		defer client.Close()
+		client.conn.SetReadDeadline(time.Now().Add(5 * time.Second))
+		_, err = client.Next()
+		return err
+	}
+
+	if err := next(agentSecurity{Token: "s3cret", CAFile: certFile}); err != nil {
+		t.Errorf("with token: %v", err)
+	}
+	if err := next(agentSecurity{Token: "wrong", CAFile: certFile}); err == nil || err.Error() != "invalid agent token" {
+		t.Errorf("wrong token: %v", err)
+	}
+	if err := next(agentSecurity{Token: "s3cret", TLS: true}); err == nil {
+		t.Error("connected without trusting the agent's certificate")
+	}
+	if err := next(agentSecurity{Token: "s3cret"}); err == nil {
+		t.Error("connected over plain TCP to a TLS agent")
+	}
+}
+
+func TestAgentRejectsClientsAfterShutdown(t *testing.T) {
+	agent := NewAgent(&syntheticSampleSource{}, time.Hour, "")
+	done := make(chan struct{})
+	close(done)
+	agent.broadcast(done)
+
+	server, client := net.Pipe()
+	handled := make(chan struct{})
+	go func() {
+		agent.handle(server)
+		close(handled)
+	}()
+	hello, _ := encodeFrame(agentHello{Version: remoteProtocolVersion})
+	client.Write(hello)
+	client.SetReadDeadline(time.Now().Add(5 * time.Second))
+	if _, err := client.Read(make([]byte, 1)); err != io.EOF {
+		t.Errorf("read after shutdown = %v, want EOF", err)
+	}
+	<-handled
+	if len(agent.clients) != 0 {
+		t.Errorf("%d clients registered after shutdown", len(agent.clients))
+	}
+}
+
+func TestAgentHandshakeLimits(t *testing.T) {
+	agent := NewAgent(&syntheticSampleSource{}, time.Hour, "s3cret")
+	expectClosed := func(client net.Conn) {
+		t.Helper()
+		client.SetReadDeadline(time.Now().Add(5 * time.Second))
+		if _, err := client.Read(make([]byte, 1)); err != io.EOF {
+			t.Errorf("read = %v, want EOF", err)
+		}
+	}
+
+	// A hello larger than maxHelloSize is refused before its body is read
+	server, client := net.Pipe()
+	go agent.handle(server)
+	var header [4]byte
+	binary.BigEndian.PutUint32(header[:], maxHelloSize+1)
+	client.Write(header[:])
+	expectClosed(client)
+
+	// Clients that never send a hello hold every handshake slot
+	var idle []net.Conn
+	defer func() {
+		for _, conn := range idle {
+			conn.Close()
+		}
+	}()
+	for i := 0; i < maxPendingHandshakes; i++ {
+		server, client := net.Pipe()
+		idle = append(idle, client)
+		go agent.handle(server)
+	}
+	for len(agent.handshakes) < maxPendingHandshakes {
+		time.Sleep(time.Millisecond)
+	}
+	server, client = net.Pipe()
+	go agent.handle(server)
+	expectClosed(client)
+}
+
+func TestParseSubcommand(t *testing.T) {
+	tests := []struct {
+		args    []string
+		command string
+		addrs   []string
+		rest    []string
+		wantErr bool
+	}{
+		{[]string{"--headless"}, "", nil, []string{"--headless"}, false},
+		{[]string{"agent", "--listen", ":7070"}, "agent", nil, []string{"--listen", ":7070"}, false},
+		{[]string{"connect", "mini-01:7070", "-i", "500"}, "connect", []string{"mini-01:7070"}, []string{"-i", "500"}, false},
+		{[]string{"connect"}, "", nil, nil, true},
+		{[]string{"connect", "--color", "red"}, "", nil, nil, true},
+		{[]string{"fleet", "mini-01:7070", "mini-02:7070", "-i", "500"}, "fleet", []string{"mini-01:7070", "mini-02:7070"}, []string{"-i", "500"}, false},
+		{[]string{"fleet", "-i", "500"}, "", nil, nil, true},
+		{[]string{"daemon", "--prometheus", ":2112"}, "daemon", nil, []string{"--prometheus", ":2112"}, false},
+		{[]string{"install-agent", "--record"}, "install-agent", nil, []string{"--record"}, false},
+		{[]string{"uninstall-agent"}, "uninstall-agent", nil, []string{}, false},
+		{nil, "", nil, nil, false},
+	}
+	for _, tt := range tests {
+		command, addrs, rest, err := parseSubcommand(tt.args)
+		if (err != nil) != tt.wantErr {
+			t.Errorf("parseSubcommand(%q) error = %v, wantErr %v", tt.args, err, tt.wantErr)
+			continue
+		}
+		if command != tt.command || !reflect.DeepEqual(addrs, tt.addrs) || !reflect.DeepEqual(rest, tt.rest) {
+			t.Errorf("parseSubcommand(%q) = %q, %q, %q", tt.args, command, addrs, rest)
+		}
+	}
+}
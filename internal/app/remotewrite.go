@@ -0,0 +1,312 @@
+package app
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"math"
+	"net/http"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/klauspost/compress/snappy"
+	dto "github.com/prometheus/client_model/go"
+	"google.golang.org/protobuf/encoding/protowire"
+)
+
+const (
+	remoteWriteFlushInterval = 15 * time.Second
+	// A batch is flushed early once it holds this many samples
+	remoteWriteMaxBatchSamples = 5000
+	remoteWritePushTimeout     = 30 * time.Second
+	remoteWriteMinBackoff      = time.Second
+	remoteWriteMaxBackoff      = 2 * time.Minute
+	remoteWriteSpoolBytes      = 64 << 20
+)
+
+// remoteWriteConfig configures push mode
+type remoteWriteConfig struct {
+	URL         string
+	BearerToken string
+	SpoolDir    string
+}
+
+func defaultRemoteWriteSpoolDir() string {
+	home, err := os.UserHomeDir()
+	if err != nil {
+		return filepath.Join(os.TempDir(), "mactop-remote-write")
+	}
+	return filepath.Join(home, ".mactop", "remote_write")
+}
+
+type promLabel struct {
+	Name, Value string
+}
+
+type promSample struct {
+	Value     float64
+	Timestamp int64 // milliseconds
+}
+
+type promSeries struct {
+	Labels  []promLabel
+	Samples []promSample
+}
+
+// encodeWriteRequest encodes series as a remote_write WriteRequest protobuf:
+//
+//	WriteRequest { repeated TimeSeries timeseries = 1; }
+//	TimeSeries   { repeated Label labels = 1; repeated Sample samples = 2; }
+//	Label        { string name = 1; string value = 2; }
+//	Sample       { double value = 1; int64 timestamp = 2; }
+func encodeWriteRequest(series []promSeries) []byte {
+	var req []byte
+	for _, s := range series {
+		var ts []byte
+		for _, l := range s.Labels {
+			var label []byte
+			label = protowire.AppendTag(label, 1, protowire.BytesType)
+			label = protowire.AppendString(label, l.Name)
+			label = protowire.AppendTag(label, 2, protowire.BytesType)
+			label = protowire.AppendString(label, l.Value)
+			ts = protowire.AppendTag(ts, 1, protowire.BytesType)
+			ts = protowire.AppendBytes(ts, label)
+		}
+		for _, smp := range s.Samples {
+			var sample []byte
+			sample = protowire.AppendTag(sample, 1, protowire.Fixed64Type)
+			sample = protowire.AppendFixed64(sample, math.Float64bits(smp.Value))
+			sample = protowire.AppendTag(sample, 2, protowire.VarintType)
+			sample = protowire.AppendVarint(sample, uint64(smp.Timestamp))
+			ts = protowire.AppendTag(ts, 2, protowire.BytesType)
+			ts = protowire.AppendBytes(ts, sample)
+		}
+		req = protowire.AppendTag(req, 1, protowire.BytesType)
+		req = protowire.AppendBytes(req, ts)
+	}
+	return req
+}
+
+// seriesKey identifies a series by its sorted labels
+func seriesKey(labels []promLabel) string {
+	var b strings.Builder
+	for _, l := range labels {
+		b.WriteString(l.Name)
+		b.WriteByte(0)
+		b.WriteString(l.Value)
+		b.WriteByte(0)
+	}
+	return b.String()
+}
+
+// remoteWriteError is a failed push. Retryable errors are kept and resent,
+// others mean the receiver rejected the data and it is dropped.
+type remoteWriteError struct {
+	err       error
+	retryable bool
+}
+
+func (e *remoteWriteError) Error() string { return e.err.Error() }
+
+// remoteWriter batches samples and pushes them with the remote_write
+// protocol. Batches go through a spool on disk, so an outage or restart
+// doesn't lose data and batches are always sent oldest first.
+type remoteWriter struct {
+	url         string
+	bearerToken string
+	extra       []promLabel
+	client      *http.Client
+	spool       *spool
+	minBackoff  time.Duration
+	maxBackoff  time.Duration
+
+	mu      sync.Mutex
+	batch   map[string]*promSeries
+	order   []string
+	samples int
+	wake    chan struct{}
+}
+
+// newRemoteWriter creates a writer whose series carry the extra labels
+func newRemoteWriter(cfg remoteWriteConfig, extra []promLabel) (*remoteWriter, error) {
+	if !strings.HasPrefix(cfg.URL, "http://") && !strings.HasPrefix(cfg.URL, "https://") {
+		return nil, fmt.Errorf("remote write URL must start with http:// or https://")
+	}
+	dir := cfg.SpoolDir
+	if dir == "" {
+		dir = defaultRemoteWriteSpoolDir()
+	}
+	sp, err := openSpool(dir, remoteWriteSpoolBytes)
+	if err != nil {
+		return nil, fmt.Errorf("opening spool: %w", err)
+	}
+	return &remoteWriter{
+		url:         cfg.URL,
+		bearerToken: cfg.BearerToken,
+		extra:       extra,
+		client:      &http.Client{Timeout: remoteWritePushTimeout},
+		spool:       sp,
+		minBackoff:  remoteWriteMinBackoff,
+		maxBackoff:  remoteWriteMaxBackoff,
+		batch:       make(map[string]*promSeries),
+		wake:        make(chan struct{}, 1),
+	}, nil
+}
+
+// append adds the current value of every gathered metric to the batch
+func (w *remoteWriter) append(families []*dto.MetricFamily, now time.Time) {
+	ts := now.UnixMilli()
+	w.mu.Lock()
+	for _, family := range families {
+		for _, m := range family.GetMetric() {
+			value, ok := gatheredValue(family.GetType(), m)
+			if !ok {
+				continue
+			}
+			labels := append([]promLabel{{"__name__", family.GetName()}}, w.extra...)
+			for _, l := range m.GetLabel() {
+				labels = append(labels, promLabel{l.GetName(), l.GetValue()})
+			}
+			sort.Slice(labels, func(i, j int) bool { return labels[i].Name < labels[j].Name })
+
+			key := seriesKey(labels)
+			s, ok := w.batch[key]
+			if !ok {
+				s = &promSeries{Labels: labels}
+				w.batch[key] = s
+				w.order = append(w.order, key)
+			}
+			s.Samples = append(s.Samples, promSample{Value: value, Timestamp: ts})
+			w.samples++
+		}
+	}
+	full := w.samples >= remoteWriteMaxBatchSamples
+	w.mu.Unlock()
+	if full {
+		w.flush()
+	}
+}
+
+// flush moves the batch to the spool and wakes the sender
+func (w *remoteWriter) flush() {
+	w.mu.Lock()
+	if w.samples == 0 {
+		w.mu.Unlock()
+		return
+	}
+	series := make([]promSeries, 0, len(w.order))
+	for _, key := range w.order {
+		series = append(series, *w.batch[key])
+	}
+	w.batch = make(map[string]*promSeries)
+	w.order = nil
+	w.samples = 0
+	w.mu.Unlock()
+
+	dropped, err := w.spool.push(snappy.Encode(nil, encodeWriteRequest(series)))
+	if err != nil {
+		stderrLogger.Printf("Remote write: failed to spool batch: %v\n", err)
+		return
+	}
+	if dropped > 0 {
+		stderrLogger.Printf("Remote write: spool full, dropped %d oldest batches\n", dropped)
+	}
+	select {
+	case w.wake <- struct{}{}:
+	default:
+	}
+}
+
+// send pushes one snappy compressed WriteRequest
+func (w *remoteWriter) send(payload []byte) error {
+	req, err := http.NewRequest(http.MethodPost, w.url, bytes.NewReader(payload))
+	if err != nil {
+		return &remoteWriteError{err: err}
+	}
+	req.Header.Set("Content-Encoding", "snappy")
+	req.Header.Set("Content-Type", "application/x-protobuf")
+	req.Header.Set("X-Prometheus-Remote-Write-Version", "0.1.0")
+	req.Header.Set("User-Agent", "mactop/"+version)
+	if w.bearerToken != "" {
+		req.Header.Set("Authorization", "Bearer "+w.bearerToken)
+	}
+	resp, err := w.client.Do(req)
+	if err != nil {
+		return &remoteWriteError{err: err, retryable: true}
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode/100 == 2 {
+		io.Copy(io.Discard, resp.Body)
+		return nil
+	}
+	msg, _ := io.ReadAll(io.LimitReader(resp.Body, 512))
+	return &remoteWriteError{
+		err: fmt.Errorf("receiver returned %s: %s", resp.Status, strings.TrimSpace(string(msg))),
+		// Server errors and rate limiting are worth retrying, other client
+		// errors will fail the same way again
+		retryable: resp.StatusCode/100 == 5 || resp.StatusCode == http.StatusTooManyRequests,
+	}
+}
+
+// run flushes the batch every interval and sends spooled batches oldest
+// first, backing off while the receiver is unavailable
+func (w *remoteWriter) run(interval time.Duration, done <-chan struct{}) {
+	flushTicker := time.NewTicker(interval)
+	defer flushTicker.Stop()
+	backoff := w.minBackoff
+	var retry <-chan time.Time
+	for {
+		if retry == nil {
+			for {
+				name, payload, ok := w.spool.oldest()
+				if !ok {
+					break
+				}
+				err := w.send(payload)
+				var rwErr *remoteWriteError
+				if err != nil && errors.As(err, &rwErr) && rwErr.retryable {
+					stderrLogger.Printf("Remote write failed, retrying in %s: %v\n", backoff, err)
+					retry = time.After(backoff)
+					backoff = min(backoff*2, w.maxBackoff)
+					break
+				}
+				if err != nil {
+					stderrLogger.Printf("Remote write rejected, dropping batch: %v\n", err)
+				}
+				w.spool.remove(name)
+				backoff = w.minBackoff
+			}
+		}
+
+		select {
+		case <-done:
+			return
+		case <-flushTicker.C:
+			w.flush()
+		case <-w.wake:
+		case <-retry:
+			retry = nil
+		}
+	}
+}
+
+// startRemoteWriter pushes mactop's metrics to the configured URL in the
+// background. Callers append samples and flush on exit, anything unsent stays
+// spooled for the next run.
+func startRemoteWriter(cfg remoteWriteConfig, done <-chan struct{}) (*remoteWriter, error) {
+	host, _ := os.Hostname()
+	w, err := newRemoteWriter(cfg, []promLabel{{"instance", host}, {"job", "mactop"}})
+	if err != nil {
+		return nil, err
+	}
+	if n := w.spool.len(); n > 0 {
+		stderrLogger.Printf("Remote write: resending %d spooled batches\n", n)
+	}
+	go w.run(remoteWriteFlushInterval, done)
+	return w, nil
+}
@@ -0,0 +1,226 @@
+package app
+
+import (
+	"fmt"
+	"io"
+	"math"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/klauspost/compress/snappy"
+	"github.com/prometheus/client_golang/prometheus"
+	"google.golang.org/protobuf/encoding/protowire"
+)
+
+// decodeWriteRequest is the inverse of encodeWriteRequest, it stands in for
+// the receiver's protobuf decoder
+func decodeWriteRequest(t *testing.T, data []byte) []promSeries {
+	t.Helper()
+	fields := func(b []byte, fn func(num protowire.Number, typ protowire.Type, b []byte) int) {
+		for len(b) > 0 {
+			num, typ, n := protowire.ConsumeTag(b)
+			if n < 0 {
+				t.Fatalf("bad tag: %v", protowire.ParseError(n))
+			}
+			b = b[n:]
+			n = fn(num, typ, b)
+			if n < 0 {
+				t.Fatalf("bad field %d: %v", num, protowire.ParseError(n))
+			}
+			b = b[n:]
+		}
+	}
+
+	var series []promSeries
+	fields(data, func(_ protowire.Number, _ protowire.Type, b []byte) int {
+		tsBytes, n := protowire.ConsumeBytes(b)
+		var s promSeries
+		fields(tsBytes, func(num protowire.Number, _ protowire.Type, b []byte) int {
+			msg, n := protowire.ConsumeBytes(b)
+			switch num {
+			case 1:
+				var l promLabel
+				fields(msg, func(num protowire.Number, _ protowire.Type, b []byte) int {
+					v, n := protowire.ConsumeString(b)
+					if num == 1 {
+						l.Name = v
+					} else {
+						l.Value = v
+					}
+					return n
+				})
+				s.Labels = append(s.Labels, l)
+			case 2:
+				var smp promSample
+				fields(msg, func(num protowire.Number, typ protowire.Type, b []byte) int {
+					if num == 1 {
+						v, n := protowire.ConsumeFixed64(b)
+						smp.Value = math.Float64frombits(v)
+						return n
+					}
+					v, n := protowire.ConsumeVarint(b)
+					smp.Timestamp = int64(v)
+					return n
+				})
+				s.Samples = append(s.Samples, smp)
+			}
+			return n
+		})
+		series = append(series, s)
+		return n
+	})
+	return series
+}
+
+func TestEncodeWriteRequest(t *testing.T) {
+	series := []promSeries{
+		{
+			Labels:  []promLabel{{"__name__", "mactop_cpu_usage_percent"}, {"instance", "mini"}},
+			Samples: []promSample{{12.5, 1709283600000}, {-3, 1709283601000}},
+		},
+		{
+			Labels:  []promLabel{{"__name__", "mactop_memory_usage_gb"}, {"type", "used"}},
+			Samples: []promSample{{9.25, 1709283600000}},
+		},
+	}
+	if got := decodeWriteRequest(t, encodeWriteRequest(series)); !reflect.DeepEqual(got, series) {
+		t.Errorf("round trip = %+v, want %+v", got, series)
+	}
+}
+
+// fakeReceiver is a remote_write endpoint that fails the first requests
+// with the given statuses
+type fakeReceiver struct {
+	t        *testing.T
+	mu       sync.Mutex
+	failures []int
+	received [][]promSeries
+	requests int
+}
+
+func (f *fakeReceiver) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.requests++
+	if r.Header.Get("Content-Encoding") != "snappy" || r.Header.Get("X-Prometheus-Remote-Write-Version") == "" {
+		http.Error(w, "missing remote write headers", http.StatusBadRequest)
+		return
+	}
+	if len(f.failures) > 0 {
+		status := f.failures[0]
+		f.failures = f.failures[1:]
+		http.Error(w, "try later", status)
+		return
+	}
+	body, _ := io.ReadAll(r.Body)
+	data, err := snappy.Decode(nil, body)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	f.received = append(f.received, decodeWriteRequest(f.t, data))
+}
+
+func (f *fakeReceiver) batches() [][]promSeries {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return append([][]promSeries(nil), f.received...)
+}
+
+func waitFor(t *testing.T, what string, cond func() bool) {
+	t.Helper()
+	deadline := time.Now().Add(5 * time.Second)
+	for !cond() {
+		if time.Now().After(deadline) {
+			t.Fatalf("timed out waiting for %s", what)
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+}
+
+func TestRemoteWriterRetries(t *testing.T) {
+	receiver := &fakeReceiver{t: t, failures: []int{http.StatusServiceUnavailable, http.StatusTooManyRequests, http.StatusBadRequest}}
+	srv := httptest.NewServer(receiver)
+	defer srv.Close()
+
+	writer, err := newRemoteWriter(remoteWriteConfig{URL: srv.URL, SpoolDir: t.TempDir()}, []promLabel{{"instance", "mini"}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	writer.minBackoff, writer.maxBackoff = time.Millisecond, 5*time.Millisecond
+
+	registry := prometheus.NewRegistry()
+	gauge := prometheus.NewGauge(prometheus.GaugeOpts{Name: "mactop_cpu_usage_percent", Help: "CPU"})
+	registry.MustRegister(gauge)
+	start := time.UnixMilli(1709283600000)
+	for i := 0; i < 4; i++ {
+		gauge.Set(float64(i))
+		families, _ := registry.Gather()
+		writer.append(families, start.Add(time.Duration(i)*time.Second))
+		if i%2 == 1 {
+			writer.flush()
+		}
+	}
+
+	done := make(chan struct{})
+	defer close(done)
+	go writer.run(time.Hour, done)
+
+	// Two 5xx/429 retries, then the first batch is rejected with 400 and
+	// dropped; the second goes through
+	waitFor(t, "the second batch", func() bool { return len(receiver.batches()) == 1 })
+	waitFor(t, "the spool to drain", func() bool { return writer.spool.len() == 0 })
+	want := []promSeries{{
+		Labels:  []promLabel{{"__name__", "mactop_cpu_usage_percent"}, {"instance", "mini"}},
+		Samples: []promSample{{2, 1709283602000}, {3, 1709283603000}},
+	}}
+	if got := receiver.batches()[0]; !reflect.DeepEqual(got, want) {
+		t.Errorf("received %+v, want %+v", got, want)
+	}
+	receiver.mu.Lock()
+	defer receiver.mu.Unlock()
+	if receiver.requests != 4 {
+		t.Errorf("receiver saw %d requests, want 4", receiver.requests)
+	}
+}
+
+func TestSpool(t *testing.T) {
+	dir := t.TempDir()
+	sp, err := openSpool(dir, 25)
+	if err != nil {
+		t.Fatal(err)
+	}
+	dropped := 0
+	for i := 0; i < 5; i++ {
+		n, err := sp.push([]byte(fmt.Sprintf("batch-%03d", i)))
+		if err != nil {
+			t.Fatal(err)
+		}
+		dropped += n
+	}
+	if dropped != 3 || sp.len() != 2 {
+		t.Errorf("dropped %d, kept %d; want the newest 2 within 25 bytes", dropped, sp.len())
+	}
+
+	// A new run picks up where the last one stopped
+	sp, err = openSpool(dir, 25)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var got []string
+	for {
+		name, data, ok := sp.oldest()
+		if !ok {
+			break
+		}
+		got = append(got, string(data))
+		sp.remove(name)
+	}
+	if want := []string{"batch-003", "batch-004"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("spool after reopening = %v, want %v", got, want)
+	}
+}
@@ -0,0 +1,201 @@
+package app
+
+import (
+	"context"
+	"crypto/subtle"
+	"crypto/tls"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"os"
+	"strings"
+	"time"
+)
+
+const (
+	metricsReadHeaderTimeout = 5 * time.Second
+	metricsReadTimeout       = 15 * time.Second
+	metricsWriteTimeout      = 30 * time.Second
+	metricsIdleTimeout       = 2 * time.Minute
+	metricsShutdownTimeout   = 5 * time.Second
+)
+
+// metricsServerConfig describes where and how the metrics server listens
+type metricsServerConfig struct {
+	// Addr is a port ("9090"), host:port (":9090", "127.0.0.1:9090") or unix
+	// socket path ("/tmp/mactop.sock" or "unix:mactop.sock")
+	Addr        string
+	TLSCert     string
+	TLSKey      string
+	BasicAuth   string // user:password
+	BearerToken string
+}
+
+// listenAddress splits Addr into the network and address to listen on
+func (c metricsServerConfig) listenAddress() (network, address string) {
+	switch {
+	case strings.HasPrefix(c.Addr, "unix:"):
+		return "unix", strings.TrimPrefix(c.Addr, "unix:")
+	case strings.ContainsRune(c.Addr, '/'):
+		return "unix", c.Addr
+	case !strings.Contains(c.Addr, ":"):
+		return "tcp", ":" + c.Addr
+	}
+	return "tcp", c.Addr
+}
+
+func (c metricsServerConfig) validate() error {
+	if (c.TLSCert == "") != (c.TLSKey == "") {
+		return errors.New("TLS needs both a certificate and a key")
+	}
+	if c.BasicAuth != "" && !strings.Contains(c.BasicAuth, ":") {
+		return errors.New("basic auth must be given as user:password")
+	}
+	return nil
+}
+
+// url is where the server can be reached from this machine, for log messages
+func (c metricsServerConfig) url(addr net.Addr) string {
+	network, address := c.listenAddress()
+	if network == "unix" {
+		return "unix:" + address
+	}
+	scheme := "http"
+	if c.TLSCert != "" {
+		scheme = "https"
+	}
+	host, port, err := net.SplitHostPort(addr.String())
+	if err != nil {
+		return scheme + "://" + addr.String()
+	}
+	if ip := net.ParseIP(host); ip == nil || ip.IsUnspecified() {
+		host = "localhost"
+	}
+	return scheme + "://" + net.JoinHostPort(host, port)
+}
+
+func secureEqual(a, b string) bool {
+	return subtle.ConstantTimeCompare([]byte(a), []byte(b)) == 1
+}
+
+// requireAuth lets a request through when it carries the configured bearer
+// token or basic auth credentials. Without either configured it's a no-op.
+func requireAuth(next http.Handler, cfg metricsServerConfig) http.Handler {
+	if cfg.BasicAuth == "" && cfg.BearerToken == "" {
+		return next
+	}
+	wantUser, wantPass, _ := strings.Cut(cfg.BasicAuth, ":")
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if cfg.BearerToken != "" {
+			if token, ok := strings.CutPrefix(r.Header.Get("Authorization"), "Bearer "); ok && secureEqual(token, cfg.BearerToken) {
+				next.ServeHTTP(w, r)
+				return
+			}
+		}
+		if cfg.BasicAuth != "" {
+			if user, pass, ok := r.BasicAuth(); ok && secureEqual(user, wantUser) && secureEqual(pass, wantPass) {
+				next.ServeHTTP(w, r)
+				return
+			}
+			w.Header().Set("WWW-Authenticate", `Basic realm="mactop"`)
+		} else {
+			w.Header().Set("WWW-Authenticate", `Bearer realm="mactop"`)
+		}
+		http.Error(w, "unauthorized", http.StatusUnauthorized)
+	})
+}
+
+// serverTLSConfig loads a certificate and key to serve TLS with
+func serverTLSConfig(certFile, keyFile string) (*tls.Config, error) {
+	cert, err := tls.LoadX509KeyPair(certFile, keyFile)
+	if err != nil {
+		return nil, fmt.Errorf("loading TLS certificate: %w", err)
+	}
+	return &tls.Config{Certificates: []tls.Certificate{cert}, MinVersion: tls.VersionTLS12}, nil
+}
+
+// metricsServer is a running metrics server
+type metricsServer struct {
+	srv    *http.Server
+	ln     net.Listener
+	cancel context.CancelFunc
+}
+
+// serveMetrics starts serving handler according to cfg. Listen and TLS
+// errors are returned rather than logged from the serving goroutine.
+func serveMetrics(cfg metricsServerConfig, handler http.Handler) (*metricsServer, error) {
+	if err := cfg.validate(); err != nil {
+		return nil, err
+	}
+	network, address := cfg.listenAddress()
+	if network == "unix" {
+		// A socket left behind by a previous run would make Listen fail,
+		// but one that still answers belongs to a running server
+		if info, err := os.Stat(address); err == nil && info.Mode()&os.ModeSocket != 0 {
+			if conn, err := net.DialTimeout("unix", address, controlDialTimeout); err == nil {
+				conn.Close()
+				return nil, fmt.Errorf("%s: address already in use", address)
+			}
+			os.Remove(address)
+		}
+	}
+	ln, err := net.Listen(network, address)
+	if err != nil {
+		return nil, err
+	}
+	if cfg.TLSCert != "" {
+		tlsConfig, err := serverTLSConfig(cfg.TLSCert, cfg.TLSKey)
+		if err != nil {
+			ln.Close()
+			return nil, err
+		}
+		ln = tls.NewListener(ln, tlsConfig)
+	}
+
+	// Cancelled on shutdown so long-lived event streams end
+	ctx, cancel := context.WithCancel(context.Background())
+	s := &metricsServer{
+		srv: &http.Server{
+			Handler:           requireAuth(handler, cfg),
+			ReadHeaderTimeout: metricsReadHeaderTimeout,
+			ReadTimeout:       metricsReadTimeout,
+			WriteTimeout:      metricsWriteTimeout,
+			IdleTimeout:       metricsIdleTimeout,
+			ErrorLog:          stderrLogger,
+			BaseContext:       func(net.Listener) context.Context { return ctx },
+		},
+		ln:     ln,
+		cancel: cancel,
+	}
+	go func() {
+		if err := s.srv.Serve(ln); err != nil && !errors.Is(err, http.ErrServerClosed) {
+			stderrLogger.Printf("Metrics server stopped: %v\n", err)
+		}
+	}()
+	return s, nil
+}
+
+func (s *metricsServer) Addr() net.Addr {
+	return s.ln.Addr()
+}
+
+// Shutdown stops accepting connections and waits briefly for requests in
+// flight. A unix socket is removed when its listener closes.
+func (s *metricsServer) Shutdown() error {
+	s.cancel()
+	ctx, cancel := context.WithTimeout(context.Background(), metricsShutdownTimeout)
+	defer cancel()
+	return s.srv.Shutdown(ctx)
+}
+
+// stopPrometheusServer shuts down the metrics server if one is running
+func stopPrometheusServer() {
+	if activeMetricsServer == nil {
+		return
+	}
+	if err := activeMetricsServer.Shutdown(); err != nil {
+		stderrLogger.Printf("Error stopping metrics server: %v\n", err)
+	}
+	activeMetricsServer = nil
+}
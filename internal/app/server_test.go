@@ -0,0 +1,251 @@
+package app
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/pem"
+	"io"
+	"math/big"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestMetricsListenAddress(t *testing.T) {
+	tests := []struct {
+		addr    string
+		network string
+		address string
+	}{
+		{"9090", "tcp", ":9090"},
+		{":9090", "tcp", ":9090"},
+		{"127.0.0.1:9090", "tcp", "127.0.0.1:9090"},
+		{"[::1]:9090", "tcp", "[::1]:9090"},
+		{"/tmp/mactop.sock", "unix", "/tmp/mactop.sock"},
+		{"unix:mactop.sock", "unix", "mactop.sock"},
+	}
+	for _, tt := range tests {
+		network, address := metricsServerConfig{Addr: tt.addr}.listenAddress()
+		if network != tt.network || address != tt.address {
+			t.Errorf("listenAddress(%q) = %s %s, want %s %s", tt.addr, network, address, tt.network, tt.address)
+		}
+	}
+}
+
+func TestMetricsConfigValidate(t *testing.T) {
+	tests := []struct {
+		name    string
+		cfg     metricsServerConfig
+		wantErr bool
+	}{
+		{"Plain", metricsServerConfig{Addr: "9090"}, false},
+		{"TLS", metricsServerConfig{TLSCert: "cert.pem", TLSKey: "key.pem"}, false},
+		{"Cert without key", metricsServerConfig{TLSCert: "cert.pem"}, true},
+		{"Basic auth", metricsServerConfig{BasicAuth: "prom:secret"}, false},
+		{"Basic auth without password", metricsServerConfig{BasicAuth: "prom"}, true},
+	}
+	for _, tt := range tests {
+		if err := tt.cfg.validate(); (err != nil) != tt.wantErr {
+			t.Errorf("%s: validate() = %v, wantErr %v", tt.name, err, tt.wantErr)
+		}
+	}
+}
+
+func TestRequireAuth(t *testing.T) {
+	ok := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {})
+	request := func(setup func(r *http.Request)) *http.Request {
+		r := httptest.NewRequest(http.MethodGet, "/metrics", nil)
+		if setup != nil {
+			setup(r)
+		}
+		return r
+	}
+	basic := func(user, pass string) func(r *http.Request) {
+		return func(r *http.Request) { r.SetBasicAuth(user, pass) }
+	}
+	bearer := func(token string) func(r *http.Request) {
+		return func(r *http.Request) { r.Header.Set("Authorization", "Bearer "+token) }
+	}
+
+	tests := []struct {
+		name  string
+		cfg   metricsServerConfig
+		setup func(r *http.Request)
+		code  int
+	}{
+		{"No auth configured", metricsServerConfig{}, nil, http.StatusOK},
+		{"Basic ok", metricsServerConfig{BasicAuth: "prom:s3:cret"}, basic("prom", "s3:cret"), http.StatusOK},
+		{"Basic wrong password", metricsServerConfig{BasicAuth: "prom:s3:cret"}, basic("prom", "nope"), http.StatusUnauthorized},
+		{"Basic missing", metricsServerConfig{BasicAuth: "prom:s3:cret"}, nil, http.StatusUnauthorized},
+		{"Bearer ok", metricsServerConfig{BearerToken: "abc123"}, bearer("abc123"), http.StatusOK},
+		{"Bearer wrong", metricsServerConfig{BearerToken: "abc123"}, bearer("abc124"), http.StatusUnauthorized},
+		{"Either accepted", metricsServerConfig{BasicAuth: "prom:pw", BearerToken: "abc123"}, basic("prom", "pw"), http.StatusOK},
+	}
+	for _, tt := range tests {
+		rec := httptest.NewRecorder()
+		requireAuth(ok, tt.cfg).ServeHTTP(rec, request(tt.setup))
+		if rec.Code != tt.code {
+			t.Errorf("%s: status %d, want %d", tt.name, rec.Code, tt.code)
+		}
+		if rec.Code == http.StatusUnauthorized && rec.Header().Get("WWW-Authenticate") == "" {
+			t.Errorf("%s: 401 without WWW-Authenticate", tt.name)
+		}
+	}
+}
+
+func TestServeMetricsUnixSocket(t *testing.T) {
+	dir, err := os.MkdirTemp("", "mactop")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	// Short path, unix socket paths are limited to about 100 bytes
+	socket := filepath.Join(dir, "m.sock")
+
+	hub := newSampleHub()
+	hub.publish((&syntheticSampleSource{}).Sample().Sample)
+	srv, err := serveMetrics(metricsServerConfig{Addr: socket}, dashboardHandler(hub))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if second, err := serveMetrics(metricsServerConfig{Addr: socket}, dashboardHandler(hub)); err == nil {
+		second.Shutdown()
+		t.Fatal("second server took over a live socket")
+	}
+	client := &http.Client{Transport: &http.Transport{
+		DialContext: func(ctx context.Context, _, _ string) (net.Conn, error) {
+			return (&net.Dialer{}).DialContext(ctx, "unix", socket)
+		},
+	}}
+
+	resp, err := client.Get("http://mactop/api/v1/current")
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		t.Errorf("current over unix socket = %d", resp.StatusCode)
+	}
+
+	// An open event stream must not hold up shutdown
+	stream, err := client.Get("http://mactop/api/v1/stream")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer stream.Body.Close()
+	shutdown := make(chan error, 1)
+	go func() { shutdown <- srv.Shutdown() }()
+	select {
+	case err := <-shutdown:
+		if err != nil {
+			t.Errorf("Shutdown() = %v", err)
+		}
+	case <-time.After(metricsShutdownTimeout + time.Second):
+		t.Fatal("shutdown did not finish")
+	}
+	io.Copy(io.Discard, stream.Body)
+	if _, err := os.Stat(socket); !os.IsNotExist(err) {
+		t.Errorf("socket left behind after shutdown: %v", err)
+	}
+
+	// A socket nothing listens on any more is replaced
+	ln, err := net.Listen("unix", socket)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ln.(*net.UnixListener).SetUnlinkOnClose(false)
+	ln.Close()
+	srv, err = serveMetrics(metricsServerConfig{Addr: socket}, dashboardHandler(hub))
+	if err != nil {
+		t.Fatalf("stale socket: %v", err)
+	}
+	srv.Shutdown()
+}
+
+// writeTestCert writes a self-signed certificate for 127.0.0.1 and returns
+// the pool that trusts it
+func writeTestCert(t *testing.T, certFile, keyFile string) *x509.CertPool {
+	t.Helper()
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	template := &x509.Certificate{
+		SerialNumber:          big.NewInt(1),
+		NotBefore:             time.Now().Add(-time.Hour),
+		NotAfter:              time.Now().Add(time.Hour),
+		IPAddresses:           []net.IP{net.ParseIP("127.0.0.1")},
+		KeyUsage:              x509.KeyUsageDigitalSignature | x509.KeyUsageCertSign,
+		ExtKeyUsage:           []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth},
+		BasicConstraintsValid: true,
+		IsCA:                  true,
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	keyDER, err := x509.MarshalECPrivateKey(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(certFile, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der}), 0600); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(keyFile, pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: keyDER}), 0600); err != nil {
+		t.Fatal(err)
+	}
+	cert, err := x509.ParseCertificate(der)
+	if err != nil {
+		t.Fatal(err)
+	}
+	pool := x509.NewCertPool()
+	pool.AddCert(cert)
+	return pool
+}
+
+func TestServeMetricsTLSAndAuth(t *testing.T) {
+	dir := t.TempDir()
+	certFile, keyFile := filepath.Join(dir, "cert.pem"), filepath.Join(dir, "key.pem")
+	pool := writeTestCert(t, certFile, keyFile)
+
+	cfg := metricsServerConfig{Addr: "127.0.0.1:0", TLSCert: certFile, TLSKey: keyFile, BearerToken: "abc123"}
+	srv, err := serveMetrics(cfg, dashboardHandler(newSampleHub()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer srv.Shutdown()
+
+	url := cfg.url(srv.Addr())
+	client := &http.Client{Transport: &http.Transport{TLSClientConfig: &tls.Config{RootCAs: pool}}}
+	get := func(token string) int {
+		t.Helper()
+		req, _ := http.NewRequest(http.MethodGet, url+"/", nil)
+		if token != "" {
+			req.Header.Set("Authorization", "Bearer "+token)
+		}
+		resp, err := client.Do(req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		resp.Body.Close()
+		return resp.StatusCode
+	}
+	if code := get(""); code != http.StatusUnauthorized {
+		t.Errorf("without token = %d, want 401", code)
+	}
+	if code := get("abc123"); code != http.StatusOK {
+		t.Errorf("with token = %d, want 200", code)
+	}
+
+	if _, err := serveMetrics(metricsServerConfig{Addr: "127.0.0.1:0", TLSCert: keyFile, TLSKey: keyFile}, http.NotFoundHandler()); err == nil {
+		t.Error("serveMetrics accepted a key as certificate")
+	}
+}
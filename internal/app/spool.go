@@ -0,0 +1,136 @@
+package app
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+const spoolExt = ".batch"
+
+// spool is a bounded on-disk FIFO of opaque batches. Entries are files named
+// by their creation time so the order survives restarts. When the spool grows
+// past maxBytes the oldest entries are dropped.
+type spool struct {
+	dir      string
+	maxBytes int64
+
+	mu      sync.Mutex
+	entries []spoolEntry
+	size    int64
+	last    int64
+}
+
+type spoolEntry struct {
+	name string
+	size int64
+}
+
+// openSpool opens dir as a spool, picking up entries left by a previous run
+func openSpool(dir string, maxBytes int64) (*spool, error) {
+	if err := os.MkdirAll(dir, 0700); err != nil {
+		return nil, err
+	}
+	files, err := os.ReadDir(dir)
+	if err != nil {
+		return nil, err
+	}
+	s := &spool{dir: dir, maxBytes: maxBytes}
+	for _, f := range files {
+		if f.IsDir() || !strings.HasSuffix(f.Name(), spoolExt) {
+			continue
+		}
+		info, err := f.Info()
+		if err != nil {
+			continue
+		}
+		s.entries = append(s.entries, spoolEntry{name: f.Name(), size: info.Size()})
+		s.size += info.Size()
+	}
+	sort.Slice(s.entries, func(i, j int) bool { return s.entries[i].name < s.entries[j].name })
+	if n := len(s.entries); n > 0 {
+		fmt.Sscanf(s.entries[n-1].name, "%d", &s.last)
+	}
+	return s, nil
+}
+
+// push stores a batch, dropping the oldest ones if the spool is over its
+// limit. It returns how many batches were dropped.
+func (s *spool) push(data []byte) (int, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	// Names must increase even if the clock doesn't
+	seq := max64(time.Now().UnixNano(), s.last+1)
+	s.last = seq
+	name := fmt.Sprintf("%020d%s", seq, spoolExt)
+	tmp := filepath.Join(s.dir, name+".tmp")
+	if err := os.WriteFile(tmp, data, 0600); err != nil {
+		return 0, err
+	}
+	if err := os.Rename(tmp, filepath.Join(s.dir, name)); err != nil {
+		os.Remove(tmp)
+		return 0, err
+	}
+	s.entries = append(s.entries, spoolEntry{name: name, size: int64(len(data))})
+	s.size += int64(len(data))
+
+	dropped := 0
+	for s.size > s.maxBytes && len(s.entries) > 1 {
+		s.removeLocked(s.entries[0].name)
+		dropped++
+	}
+	return dropped, nil
+}
+
+// oldest returns the name and contents of the oldest batch, ok is false when
+// the spool is empty
+func (s *spool) oldest() (string, []byte, bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for len(s.entries) > 0 {
+		name := s.entries[0].name
+		data, err := os.ReadFile(filepath.Join(s.dir, name))
+		if err == nil {
+			return name, data, true
+		}
+		// Unreadable entries would block the queue forever
+		stderrLogger.Printf("Dropping unreadable spool entry %s: %v\n", name, err)
+		s.removeLocked(name)
+	}
+	return "", nil, false
+}
+
+func (s *spool) remove(name string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.removeLocked(name)
+}
+
+func (s *spool) removeLocked(name string) {
+	for i, e := range s.entries {
+		if e.name == name {
+			os.Remove(filepath.Join(s.dir, name))
+			s.size -= e.size
+			s.entries = append(s.entries[:i], s.entries[i+1:]...)
+			return
+		}
+	}
+}
+
+func (s *spool) len() int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return len(s.entries)
+}
+
+func max64(a, b int64) int64 {
+	if a > b {
+		return a
+	}
+	return b
+}
@@ -0,0 +1,150 @@
+package app
+
+import (
+	"fmt"
+	"net"
+	"strconv"
+	"strings"
+
+	dto "github.com/prometheus/client_model/go"
+)
+
+// statsdMaxPacket keeps datagrams under a typical path MTU, as the DogStatsD
+// clients do
+const statsdMaxPacket = 1432
+
+// statsdConfig configures the DogStatsD emitter
+type statsdConfig struct {
+	Addr   string
+	Prefix string
+	// Tags are added to every metric, as comma separated key:value pairs
+	Tags string
+}
+
+// statsdTag formats a key:value tag, replacing the characters that delimit
+// tags and fields in the DogStatsD format
+func statsdTag(key, value string) string {
+	clean := strings.NewReplacer("|", "_", ",", "_", "#", "_", " ", "_", "\n", "_")
+	return clean.Replace(key) + ":" + clean.Replace(value)
+}
+
+// statsdName derives the metric name from a Prometheus family name
+func statsdName(prefix, family string) string {
+	return prefix + strings.TrimPrefix(family, "mactop_")
+}
+
+func statsdLine(name string, value float64, tags []string) string {
+	line := name + ":" + strconv.FormatFloat(value, 'f', -1, 64) + "|g"
+	if len(tags) > 0 {
+		line += "|#" + strings.Join(tags, ",")
+	}
+	return line
+}
+
+// statsdGauges renders every gathered metric as a DogStatsD gauge. Per-core
+// usage isn't in the registry, so it is added from percentages with the core
+// type taken from the topology.
+func statsdGauges(prefix string, tags []string, families []*dto.MetricFamily, percentages []float64, topology CoreTopology) []string {
+	var lines []string
+	for _, family := range families {
+		for _, m := range family.GetMetric() {
+			value, ok := gatheredValue(family.GetType(), m)
+			if !ok {
+				continue
+			}
+			metricTags := append([]string(nil), tags...)
+			for _, l := range m.GetLabel() {
+				metricTags = append(metricTags, statsdTag(l.GetName(), l.GetValue()))
+			}
+			lines = append(lines, statsdLine(statsdName(prefix, family.GetName()), value, metricTags))
+		}
+	}
+
+	coreTypes := make(map[int]string)
+	for _, idx := range topology.PCoreIndices {
+		coreTypes[idx] = "performance"
+	}
+	for _, idx := range topology.ECoreIndices {
+		coreTypes[idx] = "efficiency"
+	}
+	for i, usage := range percentages {
+		coreTags := append([]string(nil), tags...)
+		coreTags = append(coreTags, statsdTag("core", strconv.Itoa(i)))
+		if coreType, ok := coreTypes[i]; ok {
+			coreTags = append(coreTags, statsdTag("core_type", coreType))
+		}
+		lines = append(lines, statsdLine(prefix+"core_usage_percent", usage, coreTags))
+	}
+	return lines
+}
+
+// statsdPackets joins lines into newline separated datagrams of at most
+// maxSize bytes. A line longer than that is sent on its own.
+func statsdPackets(lines []string, maxSize int) [][]byte {
+	var packets [][]byte
+	var packet []byte
+	for _, line := range lines {
+		if len(packet) > 0 && len(packet)+1+len(line) > maxSize {
+			packets = append(packets, packet)
+			packet = nil
+		}
+		if len(packet) > 0 {
+			packet = append(packet, '\n')
+		}
+		packet = append(packet, line...)
+	}
+	if len(packet) > 0 {
+		packets = append(packets, packet)
+	}
+	return packets
+}
+
+// statsdEmitter sends gauges to a StatsD or DogStatsD agent over UDP
+type statsdEmitter struct {
+	conn    net.Conn
+	prefix  string
+	tags    []string
+	failing bool
+}
+
+// newStatsdEmitter dials the agent; every metric carries the chip model and
+// the configured tags
+func newStatsdEmitter(cfg statsdConfig, chip string) (*statsdEmitter, error) {
+	if _, _, err := net.SplitHostPort(cfg.Addr); err != nil {
+		return nil, fmt.Errorf("invalid StatsD address %q, want host:port", cfg.Addr)
+	}
+	tags := []string{statsdTag("chip", chip)}
+	for _, pair := range strings.Split(cfg.Tags, ",") {
+		if pair = strings.TrimSpace(pair); pair == "" {
+			continue
+		}
+		key, value, _ := strings.Cut(pair, ":")
+		tags = append(tags, statsdTag(strings.TrimSpace(key), strings.TrimSpace(value)))
+	}
+	conn, err := net.Dial("udp", cfg.Addr)
+	if err != nil {
+		return nil, err
+	}
+	return &statsdEmitter{conn: conn, prefix: cfg.Prefix, tags: tags}, nil
+}
+
+// emit sends one tick's gauges. UDP gives no delivery guarantee, so a send
+// error (typically nothing listening) is logged once until it recovers.
+func (e *statsdEmitter) emit(families []*dto.MetricFamily, percentages []float64, topology CoreTopology) {
+	var err error
+	for _, packet := range statsdPackets(statsdGauges(e.prefix, e.tags, families, percentages, topology), statsdMaxPacket) {
+		if _, err = e.conn.Write(packet); err != nil {
+			break
+		}
+	}
+	if err != nil && !e.failing {
+		stderrLogger.Printf("StatsD send to %s failed: %v\n", e.conn.RemoteAddr(), err)
+	} else if err == nil && e.failing {
+		stderrLogger.Printf("StatsD send to %s recovered\n", e.conn.RemoteAddr())
+	}
+	e.failing = err != nil
+}
+
+func (e *statsdEmitter) Close() error {
+	return e.conn.Close()
+}
@@ -0,0 +1,82 @@
+package app
+
+import (
+	"net"
+	"reflect"
+	"sort"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+func TestStatsdPackets(t *testing.T) {
+	tests := []struct {
+		name  string
+		lines []string
+		max   int
+		want  []string
+	}{
+		{"Empty", nil, 10, nil},
+		{"Fits", []string{"a:1|g", "b:2|g"}, 20, []string{"a:1|g\nb:2|g"}},
+		{"Split", []string{"a:1|g", "b:2|g", "c:3|g"}, 11, []string{"a:1|g\nb:2|g", "c:3|g"}},
+		{"Oversized line", []string{"a:1|g", "long:12345|g", "b:2|g"}, 8, []string{"a:1|g", "long:12345|g", "b:2|g"}},
+	}
+	for _, tt := range tests {
+		var got []string
+		for _, p := range statsdPackets(tt.lines, tt.max) {
+			got = append(got, string(p))
+		}
+		if !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("%s: packets = %q, want %q", tt.name, got, tt.want)
+		}
+	}
+}
+
+func TestStatsdEmit(t *testing.T) {
+	agent, err := net.ListenPacket("udp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer agent.Close()
+
+	registry := prometheus.NewRegistry()
+	power := prometheus.NewGauge(prometheus.GaugeOpts{Name: "mactop_power_watts", Help: "Power"})
+	memory := prometheus.NewGaugeVec(prometheus.GaugeOpts{Name: "mactop_memory_gb", Help: "Memory"}, []string{"type"})
+	registry.MustRegister(power, memory)
+	power.Set(12.5)
+	memory.WithLabelValues("used").Set(9)
+	families, _ := registry.Gather()
+
+	emitter, err := newStatsdEmitter(statsdConfig{Addr: agent.LocalAddr().String(), Prefix: "mactop.", Tags: "env:ci, team|a:infra"}, "Apple M3 Pro")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer emitter.Close()
+	topology := CoreTopology{PCoreIndices: []int{1}, ECoreIndices: []int{0}}
+	emitter.emit(families, []float64{20, 75.5}, topology)
+
+	agent.SetReadDeadline(time.Now().Add(5 * time.Second))
+	buf := make([]byte, statsdMaxPacket)
+	n, _, err := agent.ReadFrom(buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	got := strings.Split(string(buf[:n]), "\n")
+	sort.Strings(got)
+	base := "chip:Apple_M3_Pro,env:ci,team_a:infra"
+	want := []string{
+		"mactop.core_usage_percent:20|g|#" + base + ",core:0,core_type:efficiency",
+		"mactop.core_usage_percent:75.5|g|#" + base + ",core:1,core_type:performance",
+		"mactop.memory_gb:9|g|#" + base + ",type:used",
+		"mactop.power_watts:12.5|g|#" + base,
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("datagram lines = %q, want %q", got, want)
+	}
+
+	if _, err := newStatsdEmitter(statsdConfig{Addr: "8125"}, "Apple M1"); err == nil {
+		t.Error("newStatsdEmitter accepted an address without a host")
+	}
+}
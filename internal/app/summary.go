@@ -0,0 +1,352 @@
+package app
+
+import (
+	"fmt"
+	"io"
+	"math"
+	"sort"
+	"strings"
+	"sync"
+	"text/tabwriter"
+	"time"
+)
+
+const (
+	// maxSummarySamples bounds the values kept per metric for percentiles
+	maxSummarySamples = 4096
+	// summaryTopProcesses is how many processes the summary lists
+	summaryTopProcesses = 10
+)
+
+// SessionSummary describes a whole session, written at the end of a
+// headless --count run and when the TUI exits
+type SessionSummary struct {
+	Start         time.Time          `json:"start"`
+	End           time.Time          `json:"end"`
+	Samples       int                `json:"samples"`
+	Metrics       []summaryStats     `json:"metrics"`
+	ThermalStates map[string]float64 `json:"thermal_state_seconds"`
+	TopProcesses  []processCPUTime   `json:"top_processes"`
+}
+
+type summaryStats struct {
+	Metric string  `json:"metric"`
+	Unit   string  `json:"unit"`
+	Min    float64 `json:"min"`
+	Avg    float64 `json:"avg"`
+	P50    float64 `json:"p50"`
+	P95    float64 `json:"p95"`
+	Max    float64 `json:"max"`
+}
+
+type processCPUTime struct {
+	PID        int     `json:"pid"`
+	Command    string  `json:"command"`
+	CPUSeconds float64 `json:"cpu_seconds"`
+}
+
+// summaryMetrics are the history metrics plus the per-cluster usage and the
+// power components the history leaves out
+func summaryMetrics(topology CoreTopology) []historyMetric {
+	history := func(key string) historyMetric {
+		m, _ := lookupHistoryMetric(key)
+		return m
+	}
+	cluster := func(key, description string, cores []int) historyMetric {
+		return historyMetric{key, "%", description, func(out HeadlessOutput) (float64, bool) {
+			var sum float64
+			n := 0
+			for _, idx := range cores {
+				if idx < len(out.CoreUsages) {
+					sum += out.CoreUsages[idx]
+					n++
+				}
+			}
+			if n == 0 {
+				return 0, false
+			}
+			return sum / float64(n), true
+		}}
+	}
+	return []historyMetric{
+		history("cpu_usage"),
+		cluster("ecore_usage", "Efficiency cluster usage", topology.ECoreIndices),
+		cluster("pcore_usage", "Performance cluster usage", topology.PCoreIndices),
+		history("gpu_usage"),
+		history("ane_usage"),
+		history("cpu_power"),
+		history("gpu_power"),
+		{"ane_power", "W", "Neural Engine power", historyOptional(func(out HeadlessOutput) *float64 { return out.SocMetrics.ANEPower })},
+		{"dram_power", "W", "DRAM power", historyOptional(func(out HeadlessOutput) *float64 { return out.SocMetrics.DRAMPower })},
+		{"gpu_sram_power", "W", "GPU SRAM power", historyOptional(func(out HeadlessOutput) *float64 { return out.SocMetrics.GPUSRAMPower })},
+		history("package_power"),
+		history("system_power"),
+		history("cpu_temp"),
+		history("gpu_temp"),
+		{"soc_temp", "°C", "SoC temperature", historyOptional(func(out HeadlessOutput) *float32 { return out.SocMetrics.SocTemp })},
+		history("memory_used"),
+		history("swap_used"),
+		history("network_in"),
+		history("network_out"),
+		history("disk_read"),
+		history("disk_write"),
+	}
+}
+
+// summarySeries tracks the exact min, max and mean of a metric, and keeps
+// every stride-th value for the percentiles. When the values fill up every
+// other one is dropped and the stride doubles, so a long session stays
+// evenly covered.
+type summarySeries struct {
+	min, max, sum float64
+	count         int
+	samples       []float64
+	stride        int
+}
+
+func (s *summarySeries) add(v float64) {
+	if s.count == 0 || v < s.min {
+		s.min = v
+	}
+	if s.count == 0 || v > s.max {
+		s.max = v
+	}
+	s.sum += v
+	if s.stride == 0 {
+		s.stride = 1
+	}
+	if s.count%s.stride == 0 {
+		if len(s.samples) == maxSummarySamples {
+			for i := 0; i < len(s.samples)/2; i++ {
+				s.samples[i] = s.samples[2*i]
+			}
+			s.samples = s.samples[:len(s.samples)/2]
+			s.stride *= 2
+		}
+		if s.count%s.stride == 0 {
+			s.samples = append(s.samples, v)
+		}
+	}
+	s.count++
+}
+
+// percentile returns the nearest-rank percentile p (0-100) of sorted
+func percentile(sorted []float64, p float64) float64 {
+	if len(sorted) == 0 {
+		return 0
+	}
+	rank := int(math.Ceil(p/100*float64(len(sorted)))) - 1
+	return sorted[min(max(rank, 0), len(sorted)-1)]
+}
+
+func (s *summarySeries) stats() summaryStats {
+	sorted := append([]float64(nil), s.samples...)
+	sort.Float64s(sorted)
+	return summaryStats{
+		Min: s.min,
+		Avg: s.sum / float64(s.count),
+		P50: percentile(sorted, 50),
+		P95: percentile(sorted, 95),
+		Max: s.max,
+	}
+}
+
+type processTotal struct {
+	command string
+	// last is the process's CPU time at the latest sample, in seconds
+	last, total float64
+}
+
+// sessionSummary accumulates the samples of a session
+type sessionSummary struct {
+	mu           sync.Mutex
+	start, last  time.Time
+	samples      int
+	metrics      []historyMetric
+	series       []summarySeries
+	thermal      map[string]time.Duration
+	thermalState string
+	processes    map[int]*processTotal
+	// retired are the processes whose PID was since reused
+	retired []processCPUTime
+}
+
+func newSessionSummary(topology CoreTopology, start time.Time) *sessionSummary {
+	metrics := summaryMetrics(topology)
+	return &sessionSummary{
+		start:     start,
+		metrics:   metrics,
+		series:    make([]summarySeries, len(metrics)),
+		thermal:   make(map[string]time.Duration),
+		processes: make(map[int]*processTotal),
+	}
+}
+
+func (s *sessionSummary) record(out HeadlessOutput, now time.Time) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for i, m := range s.metrics {
+		if v, ok := m.value(out); ok && !math.IsNaN(v) && !math.IsInf(v, 0) {
+			s.series[i].add(v)
+		}
+	}
+	// The time until this sample counts towards the previous state
+	if s.samples > 0 {
+		s.thermal[s.thermalState] += now.Sub(s.last)
+	}
+	s.thermalState = out.ThermalState
+	s.last = now
+	s.samples++
+}
+
+// recordProcesses adds the CPU time each process used since the last list.
+// A process only counts from the first list it appears in: the lists are cut
+// to the busiest processes, so one first seen late may have been running,
+// and using CPU, long before the session started.
+func (s *sessionSummary) recordProcesses(processes []ProcessMetrics) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for _, p := range processes {
+		t, ok := s.processes[p.PID]
+		switch {
+		case !ok:
+			t = &processTotal{}
+			s.processes[p.PID] = t
+		case p.LastTime >= t.last:
+			t.total += p.LastTime - t.last
+		default:
+			// The PID was reused by a new process, which starts from here
+			if t.total > 0 {
+				s.retired = append(s.retired, processCPUTime{p.PID, t.command, t.total})
+			}
+			t = &processTotal{}
+			s.processes[p.PID] = t
+		}
+		t.command, t.last = p.Command, p.LastTime
+	}
+}
+
+func (s *sessionSummary) summary() SessionSummary {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	sum := SessionSummary{
+		Start:         s.start,
+		End:           s.last,
+		Samples:       s.samples,
+		Metrics:       []summaryStats{},
+		ThermalStates: make(map[string]float64, len(s.thermal)),
+		TopProcesses:  append([]processCPUTime{}, s.retired...),
+	}
+	for i, m := range s.metrics {
+		if s.series[i].count == 0 {
+			continue
+		}
+		stats := s.series[i].stats()
+		stats.Metric, stats.Unit = m.Key, m.Unit
+		sum.Metrics = append(sum.Metrics, stats)
+	}
+	for state, d := range s.thermal {
+		sum.ThermalStates[state] = d.Seconds()
+	}
+	for pid, t := range s.processes {
+		if t.total > 0 {
+			sum.TopProcesses = append(sum.TopProcesses, processCPUTime{pid, t.command, t.total})
+		}
+	}
+	sort.Slice(sum.TopProcesses, func(i, j int) bool {
+		a, b := sum.TopProcesses[i], sum.TopProcesses[j]
+		if a.CPUSeconds != b.CPUSeconds {
+			return a.CPUSeconds > b.CPUSeconds
+		}
+		return a.PID < b.PID
+	})
+	if len(sum.TopProcesses) > summaryTopProcesses {
+		sum.TopProcesses = sum.TopProcesses[:summaryTopProcesses]
+	}
+	return sum
+}
+
+// summaryValue formats a value in the unit of its metric
+func summaryValue(unit string, v float64) string {
+	switch unit {
+	case "%":
+		return fmt.Sprintf("%.1f%%", v)
+	case "W":
+		return fmt.Sprintf("%.2f W", v)
+	case "°C":
+		return formatTemp(v)
+	case "GiB":
+		return fmt.Sprintf("%.2f GiB", v)
+	case "B/s":
+		return formatBytes(v, networkUnit) + "/s"
+	case "KiB/s":
+		return formatBytes(v*1024, diskUnit) + "/s"
+	}
+	return fmt.Sprintf("%.2f", v)
+}
+
+// writeSessionSummary prints the summary as tables
+func writeSessionSummary(out io.Writer, sum SessionSummary) {
+	fmt.Fprintf(out, "Session summary: %s, %d samples\n\n",
+		sum.End.Sub(sum.Start).Round(time.Second), sum.Samples)
+
+	// Numbers are right aligned, the names padded to stay on the left
+	tw := tabwriter.NewWriter(out, 0, 0, 2, ' ', tabwriter.AlignRight)
+	fmt.Fprintf(tw, "%-14s\tMIN\tAVG\tP50\tP95\tMAX\t\n", "METRIC")
+	for _, m := range sum.Metrics {
+		fmt.Fprintf(tw, "%-14s\t%s\t%s\t%s\t%s\t%s\t\n", m.Metric,
+			summaryValue(m.Unit, m.Min), summaryValue(m.Unit, m.Avg),
+			summaryValue(m.Unit, m.P50), summaryValue(m.Unit, m.P95),
+			summaryValue(m.Unit, m.Max))
+	}
+	tw.Flush()
+
+	var total float64
+	for _, secs := range sum.ThermalStates {
+		total += secs
+	}
+	if total > 0 {
+		// In order of severity, anything unexpected last
+		states := make([]string, 0, len(sum.ThermalStates))
+		for state := range sum.ThermalStates {
+			states = append(states, state)
+		}
+		severity := func(state string) int {
+			for i, name := range thermalStateNames {
+				if name == state {
+					return i
+				}
+			}
+			return len(thermalStateNames)
+		}
+		sort.Slice(states, func(i, j int) bool {
+			if a, b := severity(states[i]), severity(states[j]); a != b {
+				return a < b
+			}
+			return states[i] < states[j]
+		})
+		fmt.Fprintln(out, "\nThermal state:")
+		for _, state := range states {
+			secs := sum.ThermalStates[state]
+			fmt.Fprintf(out, "  %-9s %8s  %5.1f%%\n", state,
+				time.Duration(secs*float64(time.Second)).Round(time.Second), secs/total*100)
+		}
+	}
+
+	if len(sum.TopProcesses) > 0 {
+		fmt.Fprintln(out, "\nTop processes by CPU time:")
+		tw = tabwriter.NewWriter(out, 0, 0, 2, ' ', 0)
+		fmt.Fprintln(tw, "  PID\tCOMMAND\tCPU TIME")
+		for _, p := range sum.TopProcesses {
+			fmt.Fprintf(tw, "  %d\t%s\t%s\n", p.PID, p.Command, formatTime(p.CPUSeconds))
+		}
+		tw.Flush()
+	}
+}
+
+// sessionSummaryText is the summary as shown in the TUI
+func sessionSummaryText(sum SessionSummary) string {
+	var b strings.Builder
+	writeSessionSummary(&b, sum)
+	return strings.TrimSuffix(b.String(), "\n")
+}
@@ -0,0 +1,147 @@
+package app
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestSummarySeries(t *testing.T) {
+	var s summarySeries
+	for i := 1; i <= 100; i++ {
+		s.add(float64(i))
+	}
+	got := s.stats()
+	want := summaryStats{Min: 1, Avg: 50.5, P50: 50, P95: 95, Max: 100}
+	if got != want {
+		t.Errorf("stats = %+v, want %+v", got, want)
+	}
+
+	// Past the limit every other value is dropped, keeping the spread
+	var long summarySeries
+	n := maxSummarySamples*3 + 1
+	for i := 0; i < n; i++ {
+		long.add(float64(i))
+	}
+	if len(long.samples) > maxSummarySamples || long.stride != 4 {
+		t.Errorf("kept %d samples at stride %d", len(long.samples), long.stride)
+	}
+	for i, v := range long.samples {
+		if v != float64(i*long.stride) {
+			t.Fatalf("samples[%d] = %v, want %d", i, v, i*long.stride)
+		}
+	}
+	stats := long.stats()
+	if stats.Min != 0 || stats.Max != float64(n-1) || stats.Avg != float64(n-1)/2 {
+		t.Errorf("exact stats = %+v", stats)
+	}
+	if p50 := stats.P50 / float64(n); p50 < 0.49 || p50 > 0.51 {
+		t.Errorf("p50 = %v of %d", stats.P50, n)
+	}
+}
+
+func TestSessionSummary(t *testing.T) {
+	start := time.Unix(1709283600, 0)
+	topology := CoreTopology{ECoreIndices: []int{0, 1}, PCoreIndices: []int{2, 3}}
+	s := newSessionSummary(topology, start)
+
+	states := []string{"Nominal", "Nominal", "Moderate", "Nominal"}
+	for i, state := range states {
+		power := float64(i + 1)
+		s.record(HeadlessOutput{
+			CPUUsage:     float64(10 * i),
+			CoreUsages:   []float64{0, 10, 50, float64(60 + i)},
+			ThermalState: state,
+			SocMetrics:   HeadlessSocMetrics{CPUPower: &power},
+		}, start.Add(time.Duration(i)*10*time.Second))
+	}
+
+	s.recordProcesses([]ProcessMetrics{{PID: 1, Command: "launchd", LastTime: 100}, {PID: 2, Command: "cc", LastTime: 5}})
+	s.recordProcesses([]ProcessMetrics{{PID: 1, Command: "launchd", LastTime: 101}, {PID: 2, Command: "cc", LastTime: 12}, {PID: 3, Command: "ld", LastTime: 2}})
+	// PID 2 was reused by a new process, and a long running daemon became
+	// busy enough to make the list
+	s.recordProcesses([]ProcessMetrics{{PID: 2, Command: "make", LastTime: 1}, {PID: 4, Command: "mds", LastTime: 36000}})
+	s.recordProcesses([]ProcessMetrics{{PID: 2, Command: "make", LastTime: 4}, {PID: 4, Command: "mds", LastTime: 36002}})
+
+	sum := s.summary()
+	if sum.Samples != 4 || !sum.End.Equal(start.Add(30*time.Second)) {
+		t.Errorf("samples = %d, end = %v", sum.Samples, sum.End)
+	}
+	metrics := make(map[string]summaryStats)
+	var keys []string
+	for _, m := range sum.Metrics {
+		metrics[m.Metric] = m
+		keys = append(keys, m.Metric)
+	}
+	// Optional metrics that never had a value are left out
+	if _, ok := metrics["gpu_usage"]; ok {
+		t.Errorf("gpu_usage without any GPU samples: %+v", metrics["gpu_usage"])
+	}
+	if keys[0] != "cpu_usage" || keys[1] != "ecore_usage" || keys[2] != "pcore_usage" {
+		t.Errorf("metrics in order %v", keys)
+	}
+	if e := metrics["ecore_usage"]; e.Max != 5 || e.Unit != "%" {
+		t.Errorf("ecore_usage = %+v", e)
+	}
+	if p := metrics["pcore_usage"]; p.Min != 55 || p.Max != 56.5 {
+		t.Errorf("pcore_usage = %+v", p)
+	}
+	if c := metrics["cpu_power"]; c.Avg != 2.5 || c.P95 != 4 {
+		t.Errorf("cpu_power = %+v", c)
+	}
+
+	if want := map[string]float64{"Nominal": 20, "Moderate": 10}; !reflect.DeepEqual(sum.ThermalStates, want) {
+		t.Errorf("thermal states = %v, want %v", sum.ThermalStates, want)
+	}
+	// Only CPU time used while the summary watched counts
+	wantTop := []processCPUTime{{2, "cc", 7}, {2, "make", 3}, {4, "mds", 2}, {1, "launchd", 1}}
+	if !reflect.DeepEqual(sum.TopProcesses, wantTop) {
+		t.Errorf("top processes = %+v, want %+v", sum.TopProcesses, wantTop)
+	}
+
+	text := sessionSummaryText(sum)
+	for _, want := range []string{"Session summary: 30s, 4 samples", "cpu_power", "2.50 W", "Moderate", "33.3%", "launchd"} {
+		if !strings.Contains(text, want) {
+			t.Errorf("summary text missing %q:\n%s", want, text)
+		}
+	}
+	if strings.Index(text, "Nominal") > strings.Index(text, "Moderate") {
+		t.Errorf("thermal states out of order:\n%s", text)
+	}
+}
+
+func TestSessionSummarySystemPower(t *testing.T) {
+	start := time.Unix(1709283600, 0)
+	withPSTR := newSessionSummary(CoreTopology{}, start)
+	withoutPSTR := newSessionSummary(CoreTopology{}, start)
+	for i := 0; i < 3; i++ {
+		out := HeadlessOutput{
+			SocMetrics: HeadlessSocMetrics{
+				CPUPower:    optional(4.0, true),
+				SystemPower: optional(float64(5+i), true),
+				TotalPower:  optional(float64(12+i), true),
+			},
+			Capabilities: SocCapabilities{EnergyModel: true, SystemPower: true},
+		}
+		withPSTR.record(out, start.Add(time.Duration(i)*time.Second))
+		out.Capabilities.SystemPower, out.SocMetrics.SystemPower = false, nil
+		withoutPSTR.record(out, start.Add(time.Duration(i)*time.Second))
+	}
+
+	find := func(sum SessionSummary) (summaryStats, bool) {
+		for _, m := range sum.Metrics {
+			if m.Metric == "system_power" {
+				return m, true
+			}
+		}
+		return summaryStats{}, false
+	}
+	// The PSTR reading of the whole machine, not the part beyond the SoC
+	if m, ok := find(withPSTR.summary()); !ok || m.Min != 12 || m.Max != 14 || m.Unit != "W" {
+		t.Errorf("system_power with PSTR = %+v, %v", m, ok)
+	}
+	if m, ok := find(withoutPSTR.summary()); ok {
+		t.Errorf("system_power without PSTR = %+v", m)
+	}
+}
@@ -0,0 +1,109 @@
+package app
+
+import (
+	"encoding/binary"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// sysctlStringKeys are the sysctls read by mactop that hold strings rather
+// than integers
+var sysctlStringKeys = map[string]bool{
+	"machdep.cpu.brand_string": true,
+	"hw.perflevel0.name":       true,
+	"hw.perflevel1.name":       true,
+}
+
+// systemInfoSysctls are the keys read once at startup to build SystemInfo
+var systemInfoSysctls = []string{
+	"machdep.cpu.brand_string",
+	"machdep.cpu.core_count",
+	"hw.physicalcpu",
+}
+
+// formatSysctlValue renders a raw sysctl value the way sysctl(8) prints it.
+// Integers are 32 or 64 bit little endian; longer numeric values such as
+// hw.cachesize are arrays of 64 bit integers.
+func formatSysctlValue(name string, raw []byte) string {
+	if sysctlStringKeys[name] {
+		return strings.TrimRight(string(raw), "\x00")
+	}
+	switch {
+	case len(raw) == 4:
+		return strconv.FormatInt(int64(int32(binary.LittleEndian.Uint32(raw))), 10)
+	case len(raw) > 0 && len(raw)%8 == 0:
+		values := make([]string, 0, len(raw)/8)
+		for i := 0; i < len(raw); i += 8 {
+			values = append(values, strconv.FormatUint(binary.LittleEndian.Uint64(raw[i:]), 10))
+		}
+		return strings.Join(values, " ")
+	}
+	return ""
+}
+
+// readSysctls reads the given sysctls natively, skipping keys the running
+// kernel doesn't know
+func readSysctls(names []string) map[string]string {
+	values := make(map[string]string, len(names))
+	for _, name := range names {
+		raw, err := sysctlRaw(name)
+		if err != nil {
+			continue
+		}
+		if value := formatSysctlValue(name, raw); value != "" {
+			values[name] = value
+		}
+	}
+	return values
+}
+
+// buildSystemInfo assembles SystemInfo from sysctl values and the IORegistry
+// GPU core count. Anything missing is left at zero rather than treated as fatal.
+func buildSystemInfo(sysctl map[string]string, gpuCoreCount int) SystemInfo {
+	pCoreCount, _ := strconv.Atoi(sysctl["hw.perflevel0.logicalcpu"])
+	eCoreCount, _ := strconv.Atoi(sysctl["hw.perflevel1.logicalcpu"])
+	coreCount, err := strconv.Atoi(sysctl["machdep.cpu.core_count"])
+	if err != nil {
+		coreCount, _ = strconv.Atoi(sysctl["hw.physicalcpu"])
+	}
+
+	brandString := sysctl["machdep.cpu.brand_string"]
+	if gpuCoreCount <= 0 {
+		// Fall back to the chip table when the IORegistry doesn't report it
+		gpuCoreCount = lookupChipSpec(SystemInfo{
+			Name:       brandString,
+			ECoreCount: eCoreCount,
+			PCoreCount: pCoreCount,
+		}).GPUCores
+	}
+
+	isUltra := strings.Contains(brandString, "Ultra")
+	isInterleaved := false
+	if isUltra {
+		isInterleaved = strings.Contains(brandString, "M1") || strings.Contains(brandString, "M2")
+	}
+
+	return SystemInfo{
+		Name:          brandString,
+		CoreCount:     coreCount,
+		ECoreCount:    eCoreCount,
+		PCoreCount:    pCoreCount,
+		GPUCoreCount:  gpuCoreCount,
+		IsUltra:       isUltra,
+		IsInterleaved: isInterleaved,
+	}
+}
+
+var (
+	socInfoOnce sync.Once
+	socInfo     SystemInfo
+)
+
+// getSOCInfo returns the system description, read once per process
+func getSOCInfo() SystemInfo {
+	socInfoOnce.Do(func() {
+		socInfo = buildSystemInfo(readSysctls(append(systemInfoSysctls, topologySysctls...)), getGPUCoreCount())
+	})
+	return socInfo
+}
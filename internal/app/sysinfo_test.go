@@ -0,0 +1,47 @@
+package app
+
+import "testing"
+
+func TestFormatSysctlValue(t *testing.T) {
+	tests := []struct {
+		name string
+		key  string
+		raw  []byte
+		want string
+	}{
+		{"String", "machdep.cpu.brand_string", []byte("Apple M2 Max\x00"), "Apple M2 Max"},
+		{"Int32", "hw.perflevel0.logicalcpu", []byte{8, 0, 0, 0}, "8"},
+		{"Int64", "hw.memsize", []byte{0, 0, 0, 0, 4, 0, 0, 0}, "17179869184"},
+		{"Array", "hw.cachesize", []byte{
+			0, 0, 0, 0, 4, 0, 0, 0,
+			0, 0, 1, 0, 0, 0, 0, 0,
+			0, 0, 0x40, 0, 0, 0, 0, 0,
+		}, "17179869184 65536 4194304"},
+		{"Empty", "hw.perflevel0.logicalcpu", nil, ""},
+		{"Odd length", "hw.perflevel0.logicalcpu", []byte{1, 2, 3}, ""},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := formatSysctlValue(tt.key, tt.raw); got != tt.want {
+				t.Errorf("formatSysctlValue() = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestBuildSystemInfo(t *testing.T) {
+	info := buildSystemInfo(map[string]string{
+		"machdep.cpu.brand_string": "Apple M3 Ultra",
+		"machdep.cpu.core_count":   "32",
+		"hw.perflevel0.logicalcpu": "24",
+		"hw.perflevel1.logicalcpu": "8",
+	}, 0)
+	want := SystemInfo{Name: "Apple M3 Ultra", CoreCount: 32, PCoreCount: 24, ECoreCount: 8, GPUCoreCount: 80, IsUltra: true}
+	if info != want {
+		t.Errorf("buildSystemInfo() = %+v, want %+v", info, want)
+	}
+
+	if got := buildSystemInfo(map[string]string{"hw.physicalcpu": "10"}, 16); got.CoreCount != 10 || got.GPUCoreCount != 16 || got.Name != "" {
+		t.Errorf("degraded buildSystemInfo() = %+v", got)
+	}
+}
@@ -0,0 +1,185 @@
+package app
+
+import (
+	"fmt"
+	"image"
+	"math"
+
+	ui "github.com/gizak/termui/v3"
+)
+
+// TempChartWidget plots the CPU and GPU temperature history as braille lines
+// and shades the background of every sample by its thermal state, so that
+// throttling can be lined up with the temperature curve.
+type TempChartWidget struct {
+	*ui.Block
+	cpuTemps, gpuTemps *metricHistory
+	states             []int
+	markers            chartMarkers
+}
+
+// Background shades for Moderate, Heavy and Critical thermal states
+var (
+	thermalBandColors      = []ui.Color{58, 94, 52}
+	thermalBandColorsLight = []ui.Color{229, 223, 217}
+)
+
+func NewTempChartWidget(size int) *TempChartWidget {
+	return &TempChartWidget{
+		Block:    ui.NewBlock(),
+		cpuTemps: newMetricHistory(size),
+		gpuTemps: newMetricHistory(size),
+		states:   make([]int, size),
+	}
+}
+
+func (t *TempChartWidget) Push(cpuTemp, gpuTemp float64, state int) {
+	t.cpuTemps.Push(cpuTemp)
+	t.gpuTemps.Push(gpuTemp)
+	copy(t.states, t.states[1:])
+	t.states[len(t.states)-1] = state
+	t.markers.push(len(t.states))
+}
+
+func (t *TempChartWidget) Reset() {
+	t.cpuTemps.Reset()
+	t.gpuTemps.Reset()
+	clear(t.states)
+	t.markers = chartMarkers{}
+}
+
+// tempRange returns the plotted range for the visible samples, padded so the
+// lines never touch the border and never narrower than 10 degrees.
+func tempRange(series ...[]float64) (low, high float64) {
+	low, high = math.MaxFloat64, 0
+	for _, values := range series {
+		for _, v := range values {
+			if v <= 0 {
+				continue
+			}
+			low = math.Min(low, v)
+			high = math.Max(high, v)
+		}
+	}
+	if high == 0 {
+		return 30, 50
+	}
+	low, high = math.Floor(low-2), math.Ceil(high+2)
+	if high-low < 10 {
+		mid := (high + low) / 2
+		low, high = mid-5, mid+5
+	}
+	return low, high
+}
+
+func (t *TempChartWidget) gpuLineColor() ui.Color {
+	if t.BorderStyle.Fg == ui.ColorMagenta {
+		return ui.ColorCyan
+	}
+	return ui.ColorMagenta
+}
+
+func (t *TempChartWidget) Draw(buf *ui.Buffer) {
+	t.Block.Draw(buf)
+
+	labelWidth := 6
+	drawArea := image.Rect(t.Inner.Min.X+labelWidth, t.Inner.Min.Y, t.Inner.Max.X, t.Inner.Max.Y)
+	if drawArea.Dx() < 2 || drawArea.Dy() < 2 {
+		return
+	}
+
+	// Every braille cell is two samples wide
+	visible := drawArea.Dx() * 2
+	if visible > len(t.states) {
+		visible = len(t.states)
+	}
+	offset := len(t.states) - visible
+	cpuTemps := t.cpuTemps.values[offset:]
+	gpuTemps := t.gpuTemps.values[offset:]
+	states := t.states[offset:]
+	low, high := tempRange(cpuTemps, gpuTemps)
+
+	dotHeight := drawArea.Dy()*4 - 1
+	toDotY := func(v float64) int {
+		scaled := (v - low) / (high - low)
+		return drawArea.Min.Y*4 + dotHeight - int(scaled*float64(dotHeight))
+	}
+	// Right-align the samples so the newest one is at the right border
+	startX := drawArea.Max.X*2 - visible
+
+	canvas := ui.NewCanvas()
+	canvas.Rectangle = drawArea
+	for _, line := range []struct {
+		values []float64
+		color  ui.Color
+	}{
+		{gpuTemps, t.gpuLineColor()},
+		{cpuTemps, t.BorderStyle.Fg},
+	} {
+		for i := 1; i < len(line.values); i++ {
+			prev, cur := line.values[i-1], line.values[i]
+			if cur <= 0 {
+				continue
+			}
+			if prev <= 0 {
+				canvas.SetPoint(image.Pt(startX+i, toDotY(cur)), line.color)
+				continue
+			}
+			canvas.SetLine(
+				image.Pt(startX+i-1, toDotY(prev)),
+				image.Pt(startX+i, toDotY(cur)),
+				line.color,
+			)
+		}
+	}
+	canvas.Draw(buf)
+
+	bands := thermalBandColors
+	if IsLightMode {
+		bands = thermalBandColorsLight
+	}
+	// Two samples share a cell, the hotter state decides its shade
+	cellStates := make([]int, drawArea.Dx())
+	for i, state := range states {
+		if x := (startX+i)/2 - drawArea.Min.X; x >= 0 && x < len(cellStates) && state > cellStates[x] {
+			cellStates[x] = state
+		}
+	}
+	for x, state := range cellStates {
+		if state <= 0 || state > len(bands) {
+			continue
+		}
+		for y := drawArea.Min.Y; y < drawArea.Max.Y; y++ {
+			p := image.Pt(drawArea.Min.X+x, y)
+			cell := buf.GetCell(p)
+			if cell.Rune == 0 {
+				cell = ui.CellClear
+			}
+			cell.Style.Bg = bands[state-1]
+			buf.SetCell(cell, p)
+		}
+	}
+
+	var xs []int
+	var labels []string
+	for _, m := range t.markers.markers {
+		if i := len(t.states) - 1 - m.age - offset; i >= 0 {
+			xs = append(xs, (startX+i)/2)
+			labels = append(labels, m.label)
+		}
+	}
+	drawMarkers(buf, drawArea, xs, labels, markerColor(t.BorderStyle.Fg))
+
+	labelStyle := ui.NewStyle(SecondaryTextColor)
+	buf.SetString(formatTemp(high), labelStyle, image.Pt(t.Inner.Min.X, drawArea.Min.Y))
+	buf.SetString(formatTemp(low), labelStyle, image.Pt(t.Inner.Min.X, drawArea.Max.Y-1))
+}
+
+func updateTempChart(cpuMetrics CPUMetrics) {
+	tempChart.Push(cpuMetrics.CPUTemp, cpuMetrics.GPUTemp, cpuMetrics.ThermalState)
+	tempChart.Title = fmt.Sprintf("Temperature: CPU %s | GPU %s | %s",
+		tempText(cpuMetrics.CPUTemp),
+		tempText(cpuMetrics.GPUTemp),
+		thermalStateName(cpuMetrics.ThermalState),
+	)
+}
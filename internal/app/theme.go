@@ -2,6 +2,7 @@ package app
 
 import (
 	ui "github.com/gizak/termui/v3"
+	w "github.com/gizak/termui/v3/widgets"
 )
 
 var colorMap = map[string]ui.Color{
@@ -95,6 +96,12 @@ func applyTheme(colorName string, lightMode bool) {
 		NetworkInfo.TitleStyle.Fg = color
 	}
 
+	if NetDeviceInfo != nil {
+		NetDeviceInfo.TextStyle = ui.NewStyle(color)
+		NetDeviceInfo.BorderStyle.Fg = color
+		NetDeviceInfo.TitleStyle.Fg = color
+	}
+
 	if PowerChart != nil {
 		PowerChart.TextStyle = ui.NewStyle(color)
 		PowerChart.BorderStyle.Fg = color
@@ -121,6 +128,30 @@ func applyTheme(colorName string, lightMode bool) {
 		gpuSparklineGroup.TitleStyle.Fg = color
 	}
 
+	for _, sl := range []*w.Sparkline{netOutSparkline, netInSparkline, diskReadSparkline, diskWriteSparkline} {
+		if sl != nil {
+			sl.LineColor = color
+			sl.TitleStyle = ui.NewStyle(color)
+		}
+	}
+
+	for _, group := range []*w.SparklineGroup{netSparklineGroup, diskSparklineGroup} {
+		if group != nil {
+			group.BorderStyle.Fg = color
+			group.TitleStyle.Fg = color
+		}
+	}
+
+	if coreHeatmap != nil {
+		coreHeatmap.BorderStyle.Fg = color
+		coreHeatmap.TitleStyle.Fg = color
+	}
+
+	if tempChart != nil {
+		tempChart.BorderStyle.Fg = color
+		tempChart.TitleStyle.Fg = color
+	}
+
 	if cpuCoreWidget != nil {
 		cpuCoreWidget.BorderStyle.Fg = color
 		cpuCoreWidget.TitleStyle.Fg = color
@@ -137,6 +168,12 @@ func applyTheme(colorName string, lightMode bool) {
 		helpText.TitleStyle.Fg = color
 		helpText.TextStyle = ui.NewStyle(color)
 	}
+
+	if summaryText != nil {
+		summaryText.BorderStyle.Fg = color
+		summaryText.TitleStyle.Fg = color
+		summaryText.TextStyle = ui.NewStyle(color)
+	}
 }
 
 func GetThemeColor(colorName string) ui.Color {
@@ -0,0 +1,165 @@
+package app
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// TopologyParser derives the core layout from sysctl values keyed by name, so
+// the derivation can be tested against sysctl dumps
+type TopologyParser interface {
+	ParseTopology(sysctl map[string]string) (CoreTopology, error)
+}
+
+// topologySysctls are the keys perflevelTopologyParser reads
+var topologySysctls = []string{
+	"hw.nperflevels", "hw.cachesize", "hw.l2cachesize",
+	"hw.perflevel0.physicalcpu", "hw.perflevel0.logicalcpu", "hw.perflevel0.l2cachesize",
+	"hw.perflevel1.physicalcpu", "hw.perflevel1.logicalcpu", "hw.perflevel1.cpusperl2",
+	"hw.perflevel1.l2cachesize",
+}
+
+// perflevelTopologyParser counts the cores from the perflevel sysctls.
+// perflevel0 is always the performance level. Each die carries its own
+// E-cluster, so the number of E-clusters gives the number of dies.
+//
+// The kernel numbers the cores cluster by cluster, starting with the
+// cluster of cpu0, and hw.cachesize describes cpu0's caches. Whichever
+// perflevel has the same L2 size as cpu0 therefore comes first on each die.
+type perflevelTopologyParser struct{}
+
+var topologyParser TopologyParser = perflevelTopologyParser{}
+
+func sysctlInt(sysctl map[string]string, key string) (int, error) {
+	value, ok := sysctl[key]
+	if !ok {
+		return 0, fmt.Errorf("missing sysctl %s", key)
+	}
+	n, err := strconv.Atoi(strings.TrimSpace(value))
+	if err != nil {
+		return 0, fmt.Errorf("invalid sysctl %s=%q", key, value)
+	}
+	return n, nil
+}
+
+// perflevelCores returns the core count of a perflevel, preferring physicalcpu
+func perflevelCores(sysctl map[string]string, level int) (int, error) {
+	prefix := fmt.Sprintf("hw.perflevel%d.", level)
+	if n, err := sysctlInt(sysctl, prefix+"physicalcpu"); err == nil {
+		return n, nil
+	}
+	return sysctlInt(sysctl, prefix+"logicalcpu")
+}
+
+// cpu0L2 returns the L2 size of cpu0, from hw.cachesize or hw.l2cachesize
+func cpu0L2(sysctl map[string]string) (int, error) {
+	// hw.cachesize lists the memory size, then L1d, L2, L3...
+	if fields := strings.Fields(sysctl["hw.cachesize"]); len(fields) > 2 {
+		if n, err := strconv.Atoi(fields[2]); err == nil && n > 0 {
+			return n, nil
+		}
+	}
+	return sysctlInt(sysctl, "hw.l2cachesize")
+}
+
+// firstCluster returns "P" or "E" for the type of cpu0's cluster
+func firstCluster(sysctl map[string]string) (string, error) {
+	l2, err := cpu0L2(sysctl)
+	if err != nil {
+		return "", err
+	}
+	pL2, err := sysctlInt(sysctl, "hw.perflevel0.l2cachesize")
+	if err != nil {
+		return "", err
+	}
+	eL2, err := sysctlInt(sysctl, "hw.perflevel1.l2cachesize")
+	if err != nil {
+		return "", err
+	}
+	switch {
+	case pL2 == eL2:
+		return "", fmt.Errorf("both perflevels have a %d byte L2", pL2)
+	case l2 == pL2:
+		return "P", nil
+	case l2 == eL2:
+		return "E", nil
+	}
+	return "", fmt.Errorf("cpu0's %d byte L2 matches neither perflevel", l2)
+}
+
+func (perflevelTopologyParser) ParseTopology(sysctl map[string]string) (CoreTopology, error) {
+	levels, err := sysctlInt(sysctl, "hw.nperflevels")
+	if err != nil {
+		return CoreTopology{}, err
+	}
+	pCores, err := perflevelCores(sysctl, 0)
+	if err != nil {
+		return CoreTopology{}, err
+	}
+	if levels == 1 {
+		return ChipSpec{
+			Order:       []string{"P"},
+			Description: "sysctl: single performance level",
+		}.topology(pCores, 0), nil
+	}
+	if levels != 2 {
+		return CoreTopology{}, fmt.Errorf("unsupported number of perflevels: %d", levels)
+	}
+
+	eCores, err := perflevelCores(sysctl, 1)
+	if err != nil {
+		return CoreTopology{}, err
+	}
+	eCluster, err := sysctlInt(sysctl, "hw.perflevel1.cpusperl2")
+	if err != nil {
+		return CoreTopology{}, err
+	}
+	if eCluster <= 0 || eCores%eCluster != 0 {
+		return CoreTopology{}, fmt.Errorf("%d E-cores do not split into clusters of %d", eCores, eCluster)
+	}
+	dies := eCores / eCluster
+	if pCores%dies != 0 {
+		return CoreTopology{}, fmt.Errorf("%d P-cores do not split across %d dies", pCores, dies)
+	}
+
+	first, err := firstCluster(sysctl)
+	if err != nil {
+		return CoreTopology{}, err
+	}
+	spec := ChipSpec{Order: []string{"P", "E"}, Dies: dies}
+	if first == "E" {
+		spec.Order = []string{"E", "P"}
+	}
+	spec.Description = fmt.Sprintf("sysctl: %s-cores first", first)
+	if dies > 1 {
+		spec.Description += " within each die"
+	}
+	return spec.topology(pCores, eCores), nil
+}
+
+var (
+	detectedTopologyOnce sync.Once
+	detectedTopology     CoreTopology
+	detectedTopologyErr  error
+)
+
+// detectTopology reads the topology sysctls once per process
+func detectTopology() (CoreTopology, error) {
+	detectedTopologyOnce.Do(func() {
+		detectedTopology, detectedTopologyErr = topologyParser.ParseTopology(readSysctls(topologySysctls))
+	})
+	return detectedTopology, detectedTopologyErr
+}
+
+// GetCoreTopology returns the core topology for the given system, derived from
+// sysctl when possible and from the chip table otherwise
+func GetCoreTopology(sysInfo SystemInfo) CoreTopology {
+	if topology, err := detectTopology(); err == nil &&
+		len(topology.PCoreIndices) == sysInfo.PCoreCount &&
+		len(topology.ECoreIndices) == sysInfo.ECoreCount {
+		return topology
+	}
+	return lookupChipSpec(sysInfo).topology(sysInfo.PCoreCount, sysInfo.ECoreCount)
+}
@@ -0,0 +1,140 @@
+package app
+
+import (
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+// parseSysctlOutput parses "name: value" lines as printed by sysctl(8)
+func parseSysctlOutput(out string) map[string]string {
+	values := make(map[string]string)
+	for _, line := range strings.Split(out, "\n") {
+		name, value, ok := strings.Cut(line, ":")
+		if !ok {
+			continue
+		}
+		values[strings.TrimSpace(name)] = strings.TrimSpace(value)
+	}
+	return values
+}
+
+func loadSysctlDump(t *testing.T, name string) map[string]string {
+	t.Helper()
+	data, err := os.ReadFile(filepath.Join("testdata", "sysctl", name+".txt"))
+	if err != nil {
+		t.Fatalf("reading fixture: %v", err)
+	}
+	return parseSysctlOutput(string(data))
+}
+
+func TestPerflevelTopology(t *testing.T) {
+	tests := []struct {
+		dump  string
+		wantP []int
+		wantE []int
+		dies  int
+	}{
+		{"m1", seq(0, 3), seq(4, 7), 1},
+		{"m1_pro", seq(0, 7), seq(8, 9), 1},
+		{"m1_ultra", concat(seq(0, 7), seq(10, 17)), concat(seq(8, 9), seq(18, 19)), 2},
+		{"m2", seq(0, 3), seq(4, 7), 1},
+		{"m2_max", seq(0, 7), seq(8, 11), 1},
+		{"m2_ultra", concat(seq(0, 7), seq(12, 19)), concat(seq(8, 11), seq(20, 23)), 2},
+		{"m3", seq(0, 3), seq(4, 7), 1},
+		{"m3_pro", seq(0, 5), seq(6, 11), 1},
+		{"m3_max", seq(0, 11), seq(12, 15), 1},
+		{"m3_ultra", concat(seq(4, 15), seq(20, 31)), concat(seq(0, 3), seq(16, 19)), 2},
+		{"m4", seq(0, 3), seq(4, 9), 1},
+		{"m4_pro", seq(4, 13), seq(0, 3), 1},
+		{"m4_max", seq(0, 11), seq(12, 15), 1},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.dump, func(t *testing.T) {
+			got, err := topologyParser.ParseTopology(loadSysctlDump(t, tt.dump))
+			if err != nil {
+				t.Fatalf("ParseTopology() error = %v", err)
+			}
+			if !reflect.DeepEqual(got.PCoreIndices, tt.wantP) {
+				t.Errorf("PCoreIndices = %v, want %v", got.PCoreIndices, tt.wantP)
+			}
+			if !reflect.DeepEqual(got.ECoreIndices, tt.wantE) {
+				t.Errorf("ECoreIndices = %v, want %v", got.ECoreIndices, tt.wantE)
+			}
+			if got.Dies != tt.dies {
+				t.Errorf("Dies = %d, want %d", got.Dies, tt.dies)
+			}
+		})
+	}
+}
+
+func TestPerflevelTopologyFollowsCPU0(t *testing.T) {
+	// The same M1 with cpu0 in the E-cluster, reported through either key
+	sysctl := loadSysctlDump(t, "m1")
+	sysctl["hw.cachesize"] = "17179869184 65536 4194304 0 0 0 0 0 0 0"
+	l2Only := loadSysctlDump(t, "m1")
+	delete(l2Only, "hw.cachesize")
+	l2Only["hw.l2cachesize"] = "4194304"
+
+	for name, sysctl := range map[string]map[string]string{"hw.cachesize": sysctl, "hw.l2cachesize": l2Only} {
+		got, err := topologyParser.ParseTopology(sysctl)
+		if err != nil {
+			t.Fatalf("%s: ParseTopology() error = %v", name, err)
+		}
+		if !reflect.DeepEqual(got.ECoreIndices, seq(0, 3)) || !reflect.DeepEqual(got.PCoreIndices, seq(4, 7)) {
+			t.Errorf("%s: got P %v E %v, want E-cores first", name, got.PCoreIndices, got.ECoreIndices)
+		}
+	}
+}
+
+func TestPerflevelTopologyErrors(t *testing.T) {
+	m1 := loadSysctlDump(t, "m1")
+	withOverride := func(key, value string) map[string]string {
+		sysctl := make(map[string]string, len(m1))
+		for k, v := range m1 {
+			sysctl[k] = v
+		}
+		sysctl[key] = value
+		return sysctl
+	}
+
+	tests := []struct {
+		name   string
+		sysctl map[string]string
+	}{
+		{"No perflevels", loadSysctlDump(t, "vm")},
+		{"Three perflevels", withOverride("hw.nperflevels", "3")},
+		{"Uneven clusters", withOverride("hw.perflevel1.cpusperl2", "3")},
+		{"P-cores split unevenly", func() map[string]string {
+			sysctl := loadSysctlDump(t, "m1_ultra")
+			sysctl["hw.perflevel0.physicalcpu"] = "15"
+			return sysctl
+		}()},
+		{"Bad value", withOverride("hw.perflevel1.cpusperl2", "four")},
+		{"No cache sizes", func() map[string]string {
+			sysctl := loadSysctlDump(t, "m1")
+			delete(sysctl, "hw.cachesize")
+			return sysctl
+		}()},
+		{"Same L2 on both levels", withOverride("hw.perflevel1.l2cachesize", "12582912")},
+		{"cpu0 L2 matches neither level", withOverride("hw.cachesize", "17179869184 131072 8388608")},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got, err := topologyParser.ParseTopology(tt.sysctl); err == nil {
+				t.Errorf("ParseTopology() = %+v, want error", got)
+			}
+		})
+	}
+
+	single, err := topologyParser.ParseTopology(map[string]string{
+		"hw.nperflevels":            "1",
+		"hw.perflevel0.physicalcpu": "8",
+	})
+	if err != nil || !reflect.DeepEqual(single.PCoreIndices, seq(0, 7)) || len(single.ECoreIndices) != 0 {
+		t.Errorf("single perflevel = %+v, %v", single, err)
+	}
+}
@@ -3,7 +3,6 @@ package app
 import (
 	"fmt"
 	"image"
-	"strings"
 	"time"
 
 	ui "github.com/gizak/termui/v3"
@@ -22,7 +21,11 @@ type CPUMetrics struct {
 	CoreMetrics                                                      map[string]int
 	ANEW, CPUW, GPUW, DRAMW, GPUSRAMW, PackageW, SystemW             float64
 	CoreUsages                                                       []float64
+	Memory                                                           MemoryMetrics
 	Throttled                                                        bool
+	ThermalState                                                     int
+	ANEActive                                                        float64
+	ANEResidency                                                     bool
 	CPUTemp                                                          float64
 	GPUTemp                                                          float64
 }
@@ -41,125 +44,35 @@ type SystemInfo struct {
 type CoreTopology struct {
 	PCoreIndices []int
 	ECoreIndices []int
+	Dies         int
 	Description  string
 }
 
-// GetCoreTopology returns the correct core topology for the given system
-func GetCoreTopology(sysInfo SystemInfo) CoreTopology {
-	totalCores := sysInfo.PCoreCount + sysInfo.ECoreCount
-	name := sysInfo.Name
-
-	switch {
-	case strings.Contains(name, "M3 Ultra") && sysInfo.PCoreCount == 24 && sysInfo.ECoreCount == 8:
-		// M3 Ultra 32-core (24P + 8E): E-cores first within each die
-		// Die 1: E-cores 0-3, P-cores 4-15
-		// Die 2: E-cores 16-19, P-cores 20-31
-		topology := CoreTopology{
-			Description:  "M3 Ultra 32-core: E-cores first within each die",
-			PCoreIndices: make([]int, 0, 24),
-			ECoreIndices: make([]int, 0, 8),
-		}
-		for i := 0; i < 4; i++ {
-			topology.ECoreIndices = append(topology.ECoreIndices, i)
-		}
-		for i := 4; i < 16; i++ {
-			topology.PCoreIndices = append(topology.PCoreIndices, i)
-		}
-		for i := 16; i < 20; i++ {
-			topology.ECoreIndices = append(topology.ECoreIndices, i)
-		}
-		for i := 20; i < 32; i++ {
-			topology.PCoreIndices = append(topology.PCoreIndices, i)
-		}
-		return topology
-
-	case strings.Contains(name, "M3 Ultra") && sysInfo.PCoreCount == 20 && sysInfo.ECoreCount == 8:
-		// M3 Ultra 28-core (20P + 8E): E-cores first within each die
-		// Die 1: E-cores 0-3, P-cores 4-13
-		// Die 2: E-cores 14-17, P-cores 18-27
-		topology := CoreTopology{
-			Description:  "M3 Ultra 28-core: E-cores first within each die",
-			PCoreIndices: make([]int, 0, 20),
-			ECoreIndices: make([]int, 0, 8),
-		}
-		for i := 0; i < 4; i++ {
-			topology.ECoreIndices = append(topology.ECoreIndices, i)
-		}
-		for i := 4; i < 14; i++ {
-			topology.PCoreIndices = append(topology.PCoreIndices, i)
-		}
-		for i := 14; i < 18; i++ {
-			topology.ECoreIndices = append(topology.ECoreIndices, i)
-		}
-		for i := 18; i < 28; i++ {
-			topology.PCoreIndices = append(topology.PCoreIndices, i)
-		}
-		return topology
-
-	case strings.Contains(name, "M4 Pro"):
-		// M4 Pro: E-cores first, then P-cores
-		topology := CoreTopology{
-			Description:  "M4 Pro: E-cores first, then P-cores",
-			PCoreIndices: make([]int, 0, sysInfo.PCoreCount),
-			ECoreIndices: make([]int, 0, sysInfo.ECoreCount),
-		}
-		for i := 0; i < sysInfo.ECoreCount; i++ {
-			topology.ECoreIndices = append(topology.ECoreIndices, i)
-		}
-		for i := sysInfo.ECoreCount; i < totalCores; i++ {
-			topology.PCoreIndices = append(topology.PCoreIndices, i)
-		}
-		return topology
-
-	case strings.Contains(name, "M1 Ultra") || strings.Contains(name, "M2 Ultra"):
-		// M1/M2 Ultra: P-cores first within each die (interleaved pattern)
-		pCoresPerDie := sysInfo.PCoreCount / 2
-		eCoresPerDie := sysInfo.ECoreCount / 2
-		topology := CoreTopology{
-			Description:  "M1/M2 Ultra: P-cores first within each die",
-			PCoreIndices: make([]int, 0, sysInfo.PCoreCount),
-			ECoreIndices: make([]int, 0, sysInfo.ECoreCount),
-		}
-		// Die 1 P-cores
-		for i := 0; i < pCoresPerDie; i++ {
-			topology.PCoreIndices = append(topology.PCoreIndices, i)
-		}
-		// Die 1 E-cores
-		for i := pCoresPerDie; i < pCoresPerDie+eCoresPerDie; i++ {
-			topology.ECoreIndices = append(topology.ECoreIndices, i)
-		}
-		// Die 2 P-cores
-		for i := pCoresPerDie + eCoresPerDie; i < 2*pCoresPerDie+eCoresPerDie; i++ {
-			topology.PCoreIndices = append(topology.PCoreIndices, i)
-		}
-		// Die 2 E-cores
-		for i := 2*pCoresPerDie + eCoresPerDie; i < totalCores; i++ {
-			topology.ECoreIndices = append(topology.ECoreIndices, i)
-		}
-		return topology
-
-	default:
-		// Default for most chips: P-cores first, then E-cores
-		topology := CoreTopology{
-			Description:  "Standard layout: P-cores first, then E-cores",
-			PCoreIndices: make([]int, 0, sysInfo.PCoreCount),
-			ECoreIndices: make([]int, 0, sysInfo.ECoreCount),
-		}
-		for i := 0; i < sysInfo.PCoreCount; i++ {
-			topology.PCoreIndices = append(topology.PCoreIndices, i)
-		}
-		for i := sysInfo.PCoreCount; i < totalCores; i++ {
-			topology.ECoreIndices = append(topology.ECoreIndices, i)
-		}
-		return topology
-	}
+type NetDiskMetrics struct {
+	OutPacketsPerSec  float64             `json:"out_packets_per_sec"`
+	OutBytesPerSec    float64             `json:"out_bytes_per_sec"`
+	InPacketsPerSec   float64             `json:"in_packets_per_sec"`
+	InBytesPerSec     float64             `json:"in_bytes_per_sec"`
+	ReadOpsPerSec     float64             `json:"read_ops_per_sec"`
+	WriteOpsPerSec    float64             `json:"write_ops_per_sec"`
+	ReadKBytesPerSec  float64             `json:"read_kbytes_per_sec"`
+	WriteKBytesPerSec float64             `json:"write_kbytes_per_sec"`
+	Interfaces        []InterfaceMetrics  `json:"interfaces"`
+	Disks             []DiskDeviceMetrics `json:"disks"`
 }
 
-type NetDiskMetrics struct {
-	OutPacketsPerSec  float64 `json:"out_packets_per_sec"`
-	OutBytesPerSec    float64 `json:"out_bytes_per_sec"`
-	InPacketsPerSec   float64 `json:"in_packets_per_sec"`
-	InBytesPerSec     float64 `json:"in_bytes_per_sec"`
+// InterfaceMetrics holds the rates of a single network interface
+type InterfaceMetrics struct {
+	Name             string  `json:"name"`
+	OutPacketsPerSec float64 `json:"out_packets_per_sec"`
+	OutBytesPerSec   float64 `json:"out_bytes_per_sec"`
+	InPacketsPerSec  float64 `json:"in_packets_per_sec"`
+	InBytesPerSec    float64 `json:"in_bytes_per_sec"`
+}
+
+// DiskDeviceMetrics holds the rates of a single block device
+type DiskDeviceMetrics struct {
+	Name              string  `json:"name"`
 	ReadOpsPerSec     float64 `json:"read_ops_per_sec"`
 	WriteOpsPerSec    float64 `json:"write_ops_per_sec"`
 	ReadKBytesPerSec  float64 `json:"read_kbytes_per_sec"`
@@ -171,6 +84,7 @@ type GPUMetrics struct {
 	ActivePercent float64
 	Power         float64
 	Temp          float32
+	Perf          GPUPerformanceStats
 }
 
 type ProcessMetrics struct {
@@ -0,0 +1,183 @@
+package app
+
+import (
+	"embed"
+	"encoding/json"
+	"fmt"
+	"io/fs"
+	"net/http"
+	"sync"
+	"time"
+)
+
+//go:embed web
+var webAssets embed.FS
+
+// sampleHub holds the latest sample as JSON, a window of recent samples and
+// the process list, and fans samples out to the dashboard's event streams.
+// A slow browser misses samples rather than blocking others.
+type sampleHub struct {
+	mu        sync.Mutex
+	latest    []byte
+	history   *sampleRing
+	processes []ProcessMetrics
+	clients   map[chan []byte]struct{}
+}
+
+func newSampleHub() *sampleHub {
+	return &sampleHub{
+		history: newSampleRing(maxHistorySamples),
+		clients: make(map[chan []byte]struct{}),
+	}
+}
+
+func (h *sampleHub) publish(out HeadlessOutput) {
+	h.publishAt(out, time.Now())
+}
+
+func (h *sampleHub) publishAt(out HeadlessOutput, now time.Time) {
+	data, err := json.Marshal(out)
+	if err != nil {
+		stderrLogger.Printf("Error encoding sample: %v\n", err)
+		return
+	}
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	h.latest = data
+	h.history.push(historyEntry{Time: now, Data: data})
+	h.history.prune(now.Add(-historyRetention))
+	for ch := range h.clients {
+		select {
+		case ch <- data:
+		default:
+		}
+	}
+}
+
+func (h *sampleHub) snapshot() []byte {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	return h.latest
+}
+
+// subscribe returns a channel of samples, primed with the latest one, and a
+// function that stops delivery
+func (h *sampleHub) subscribe() (<-chan []byte, func()) {
+	ch := make(chan []byte, 1)
+	h.mu.Lock()
+	if h.latest != nil {
+		ch <- h.latest
+	}
+	h.clients[ch] = struct{}{}
+	h.mu.Unlock()
+	return ch, func() {
+		h.mu.Lock()
+		delete(h.clients, ch)
+		h.mu.Unlock()
+	}
+}
+
+func (h *sampleHub) serveSnapshot(w http.ResponseWriter, r *http.Request) {
+	data := h.snapshot()
+	if data == nil {
+		http.Error(w, "no sample collected yet", http.StatusServiceUnavailable)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Cache-Control", "no-store")
+	w.Write(data)
+}
+
+// serveStream sends every sample as a Server-Sent Event
+func (h *sampleHub) serveStream(w http.ResponseWriter, r *http.Request) {
+	flusher, ok := w.(http.Flusher)
+	if !ok {
+		http.Error(w, "streaming unsupported", http.StatusInternalServerError)
+		return
+	}
+	rc := http.NewResponseController(w)
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-store")
+	w.Header().Set("X-Accel-Buffering", "no")
+	w.WriteHeader(http.StatusOK)
+	flusher.Flush()
+
+	samples, unsubscribe := h.subscribe()
+	defer unsubscribe()
+	for {
+		select {
+		case <-r.Context().Done():
+			return
+		case data := <-samples:
+			// The server's write timeout would end the stream, bound each event instead
+			rc.SetWriteDeadline(time.Now().Add(remoteWriteTimeout))
+			if _, err := fmt.Fprintf(w, "data: %s\n\n", data); err != nil {
+				return
+			}
+			flusher.Flush()
+		}
+	}
+}
+
+// dashboardHandler serves the embedded dashboard and the JSON API
+func dashboardHandler(hub *sampleHub) http.Handler {
+	static, err := fs.Sub(webAssets, "web")
+	if err != nil {
+		panic(err)
+	}
+	mux := http.NewServeMux()
+	mux.Handle("/", http.FileServer(http.FS(static)))
+	mux.HandleFunc("/api/v1/snapshot", hub.serveSnapshot)
+	mux.HandleFunc("/api/v1/current", hub.serveSnapshot)
+	mux.HandleFunc("/api/v1/history", hub.serveHistory)
+	mux.HandleFunc("/api/v1/processes", hub.serveProcesses)
+	mux.HandleFunc("/api/v1/stream", hub.serveStream)
+	return mux
+}
+
+// metricsOutput builds the headless JSON from the metrics the TUI renders,
+// so the dashboard shows the same numbers as the terminal
+func metricsOutput(cpuMetrics CPUMetrics, gpuMetrics GPUMetrics, netDisk NetDiskMetrics, sysInfo SystemInfo, caps SocCapabilities) HeadlessOutput {
+	energy := caps.EnergyModel
+	temp := func(celsius float64) *float32 {
+		return optional(float32(celsius), caps.Temperature() && celsius > 0)
+	}
+	var cpuUsagePercent float64
+	for _, usage := range cpuMetrics.CoreUsages {
+		cpuUsagePercent += usage
+	}
+	if len(cpuMetrics.CoreUsages) > 0 {
+		cpuUsagePercent /= float64(len(cpuMetrics.CoreUsages))
+	}
+	aneUtil, aneMethod := aneUtilization(cpuMetrics, sysInfo.Name)
+	aneAvailable := cpuMetrics.ANEResidency || energy
+
+	return HeadlessOutput{
+		Timestamp: time.Now().Format(time.RFC3339),
+		SocMetrics: HeadlessSocMetrics{
+			CPUPower:     optional(cpuMetrics.CPUW, energy),
+			GPUPower:     optional(cpuMetrics.GPUW, energy),
+			ANEPower:     optional(cpuMetrics.ANEW, energy),
+			DRAMPower:    optional(cpuMetrics.DRAMW, energy),
+			GPUSRAMPower: optional(cpuMetrics.GPUSRAMW, energy),
+			SystemPower:  optional(cpuMetrics.SystemW, caps.SystemPower),
+			TotalPower:   optional(cpuMetrics.PackageW, caps.Power()),
+			GPUFreqMHz:   optional(int32(gpuMetrics.FreqMHz), caps.GPUStats),
+			CPUTemp:      temp(cpuMetrics.CPUTemp),
+			GPUTemp:      temp(cpuMetrics.GPUTemp),
+		},
+		Memory:       cpuMetrics.Memory,
+		NetDisk:      netDisk,
+		CPUUsage:     cpuUsagePercent,
+		GPUUsage:     optional(gpuMetrics.ActivePercent, caps.GPUStats),
+		CoreUsages:   cpuMetrics.CoreUsages,
+		SystemInfo:   sysInfo,
+		ThermalState: thermalStateName(cpuMetrics.ThermalState),
+		CPUTemp:      temp(cpuMetrics.CPUTemp),
+		GPUTemp:      temp(cpuMetrics.GPUTemp),
+		GPUStats:     optional(gpuMetrics.Perf, gpuMetrics.Perf.Available),
+		ANEUsage:     optional(aneUtil, aneAvailable),
+		ANEMethod:    optional(aneMethod, aneAvailable),
+		Capabilities: caps,
+	}
+}
@@ -0,0 +1,135 @@
+package app
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestDashboardHandler(t *testing.T) {
+	hub := newSampleHub()
+	srv := httptest.NewServer(dashboardHandler(hub))
+	defer srv.Close()
+
+	resp, err := http.Get(srv.URL + "/")
+	if err != nil {
+		t.Fatal(err)
+	}
+	body, _ := io.ReadAll(resp.Body)
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusOK || !strings.Contains(string(body), "api/v1/stream") {
+		t.Errorf("GET / = %d, body missing the dashboard script", resp.StatusCode)
+	}
+
+	resp, err = http.Get(srv.URL + "/api/v1/snapshot")
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusServiceUnavailable {
+		t.Errorf("snapshot before the first sample = %d, want 503", resp.StatusCode)
+	}
+
+	hub.publish((&syntheticSampleSource{}).Sample().Sample)
+	resp, err = http.Get(srv.URL + "/api/v1/snapshot")
+	if err != nil {
+		t.Fatal(err)
+	}
+	var snapshot HeadlessOutput
+	err = json.NewDecoder(resp.Body).Decode(&snapshot)
+	resp.Body.Close()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if resp.Header.Get("Content-Type") != "application/json" || snapshot.SystemInfo.Name != "Apple M2" || snapshot.CPUUsage != 1 {
+		t.Errorf("snapshot = %+v", snapshot)
+	}
+}
+
+func TestDashboardStream(t *testing.T) {
+	hub := newSampleHub()
+	source := &syntheticSampleSource{}
+	hub.publish(source.Sample().Sample)
+	srv := httptest.NewServer(dashboardHandler(hub))
+	defer srv.Close()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	req, _ := http.NewRequestWithContext(ctx, http.MethodGet, srv.URL+"/api/v1/stream", nil)
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+	if ct := resp.Header.Get("Content-Type"); ct != "text/event-stream" {
+		t.Fatalf("Content-Type = %q", ct)
+	}
+
+	events := bufio.NewScanner(resp.Body)
+	next := func() HeadlessOutput {
+		t.Helper()
+		for events.Scan() {
+			if data, ok := strings.CutPrefix(events.Text(), "data: "); ok {
+				var out HeadlessOutput
+				if err := json.Unmarshal([]byte(data), &out); err != nil {
+					t.Fatal(err)
+				}
+				return out
+			}
+		}
+		t.Fatalf("stream ended: %v", events.Err())
+		return HeadlessOutput{}
+	}
+
+	// The latest sample is sent straight away, later ones as they arrive
+	if got := next().CPUUsage; got != 1 {
+		t.Errorf("first event CPU usage = %v, want 1", got)
+	}
+	hub.publish(source.Sample().Sample)
+	if got := next().CPUUsage; got != 2 {
+		t.Errorf("second event CPU usage = %v, want 2", got)
+	}
+
+	cancel()
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		hub.mu.Lock()
+		n := len(hub.clients)
+		hub.mu.Unlock()
+		if n == 0 {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatal("stream client was not unsubscribed")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+func TestMetricsOutput(t *testing.T) {
+	cpuMetrics := CPUMetrics{CPUW: 3, PackageW: 7, SystemW: 1, CPUTemp: 48, ThermalState: 1, CoreUsages: []float64{20, 40}}
+	gpuMetrics := GPUMetrics{FreqMHz: 1398, ActivePercent: 35}
+	sysInfo := SystemInfo{Name: "Apple M2"}
+
+	out := metricsOutput(cpuMetrics, gpuMetrics, NetDiskMetrics{}, sysInfo, SocCapabilities{EnergyModel: true, GPUStats: true, SMCTempKeys: 4})
+	if out.CPUUsage != 30 || deref(out.GPUUsage) != 35 || deref(out.SocMetrics.TotalPower) != 7 || deref(out.CPUTemp) != 48 || out.ThermalState != "Moderate" {
+		t.Errorf("output with every source = %+v", out)
+	}
+	if out.SocMetrics.SystemPower != nil || out.GPUTemp != nil || out.GPUStats != nil {
+		t.Errorf("missing readings should be null: %+v", out)
+	}
+	if deref(out.ANEMethod) != ANEMethodPowerTable {
+		t.Errorf("ANE method = %v", deref(out.ANEMethod))
+	}
+
+	out = metricsOutput(cpuMetrics, gpuMetrics, NetDiskMetrics{}, sysInfo, SocCapabilities{})
+	if out.GPUUsage != nil || out.SocMetrics.CPUPower != nil || out.SocMetrics.TotalPower != nil || out.CPUTemp != nil || out.ANEUsage != nil {
+		t.Errorf("output without sources should be null: %+v", out)
+	}
+}